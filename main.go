@@ -69,16 +69,22 @@ func main() {
 		os.Exit(1)
 	}
 
-	zoneManager, err := zonemgr.NewZoneManager()
+	zoneManager, err := zonemgr.NewZoneManager(mgr.GetEventRecorderFor("secondary-dns"))
 	if err != nil {
 		setupLog.Error(err, "unable to create zone manager")
 		os.Exit(1)
 	}
 
+	if err = mgr.Add(zoneManager); err != nil {
+		setupLog.Error(err, "unable to add zone manager flush loop")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.VirtualMachineInstanceReconciler{
 		Client:      mgr.GetClient(),
 		Log:         ctrl.Log.WithName("controllers").WithName("VirtualMachineInstance"),
 		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("secondary-dns"),
 		ZoneManager: zoneManager,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VirtualMachineInstance")
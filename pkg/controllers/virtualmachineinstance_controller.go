@@ -18,16 +18,28 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"time"
 
 	"github.com/go-logr/logr"
 
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	v1 "kubevirt.io/api/core/v1"
 
@@ -35,11 +47,16 @@ import (
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr"
 )
 
+// nameConflictRequeueInterval is the interval to retry publishing a VMI whose name, or alias, is used by another VMI.
+// The VMI is not reconciled when the name is released, therefore it is published up to this interval after the release.
+const nameConflictRequeueInterval = 30 * time.Second
+
 // VirtualMachineInstanceReconciler reconciles a VirtualMachineInstance object
 type VirtualMachineInstanceReconciler struct {
 	client.Client
 	Log         logr.Logger
 	Scheme      *runtime.Scheme
+	Recorder    record.EventRecorder
 	ZoneManager *zonemgr.ZoneManager
 }
 
@@ -55,14 +72,114 @@ func (r *VirtualMachineInstanceReconciler) Reconcile(ctx context.Context, reques
 		// Error reading the object - requeue the request.
 		return ctrl.Result{}, err
 	}
+	namespace, err := r.getNamespace(ctx, vmi.Namespace)
+	if err != nil {
+		r.Log.Error(err, "Error retrieving VMI namespace")
+		return ctrl.Result{}, err
+	}
+	if !r.ZoneManager.IsNamespaceSelected(namespace.Labels) {
+		// The VMIs of a namespace that does not opt in are not published, or withdrawn when the namespace opts out
+		return ctrl.Result{}, r.ZoneManager.UpdateZone(request.NamespacedName, nil)
+	}
+	inheritNamespaceTTL(vmi, namespace)
 	filteredInterfaces := filter.FilterMultusNonDefaultInterfaces(vmi.Status.Interfaces, vmi.Spec.Networks)
 	// The interface/network name is used to build the FQDN, therefore, interfaces reported without a name are filtered out
 	filteredInterfaces = filter.FilterNamedInterfaces(filteredInterfaces)
-	err = r.ZoneManager.UpdateZone(request.NamespacedName, filteredInterfaces)
+	vmi.Status.Interfaces = filteredInterfaces
+	err = r.ZoneManager.UpdateZone(request.NamespacedName, vmi)
 
+	var nameConflictErr *zonemgr.NameConflictError
+	if errors.As(err, &nameConflictErr) {
+		r.Log.Info("VMI name conflict", "vmi", request.NamespacedName, "owner", nameConflictErr.Owner)
+		r.Recorder.Event(vmi, corev1.EventTypeWarning, "NameConflict", nameConflictErr.Error())
+		return ctrl.Result{RequeueAfter: nameConflictRequeueInterval}, nil
+	}
+	var invalidNameErr *zonemgr.InvalidNameError
+	if errors.As(err, &invalidNameErr) {
+		r.Log.Info("VMI invalid name", "vmi", request.NamespacedName, "error", invalidNameErr.Err.Error())
+		r.Recorder.Event(vmi, corev1.EventTypeWarning, "InvalidName", invalidNameErr.Error())
+		return ctrl.Result{}, nil
+	}
+	// The VMI is published when its aliases conflict or its TTL is invalid, both are reported when both apply
+	result, isPublished := ctrl.Result{}, false
+	var aliasConflictErr *zonemgr.AliasConflictError
+	if errors.As(err, &aliasConflictErr) {
+		r.Log.Info("VMI alias conflict", "vmi", request.NamespacedName, "owners", aliasConflictErr.Owners)
+		r.Recorder.Event(vmi, corev1.EventTypeWarning, "NameConflict", aliasConflictErr.Error())
+		result, isPublished = ctrl.Result{RequeueAfter: nameConflictRequeueInterval}, true
+	}
+	var invalidTTLErr *zonemgr.InvalidTTLError
+	if errors.As(err, &invalidTTLErr) {
+		r.Log.Info("VMI invalid TTL", "vmi", request.NamespacedName, "error", invalidTTLErr.Err.Error())
+		r.Recorder.Event(vmi, corev1.EventTypeWarning, "InvalidTTL", invalidTTLErr.Error())
+		isPublished = true
+	}
+	if isPublished {
+		return result, nil
+	}
 	return ctrl.Result{}, err
 }
 
+// getNamespace returns the namespace of the given name, a namespace that is not found has neither labels nor
+// annotations
+func (r *VirtualMachineInstanceReconciler) getNamespace(ctx context.Context, name string) (*corev1.Namespace, error) {
+	namespace := &corev1.Namespace{}
+	if err := r.Client.Get(ctx, types.NamespacedName{Name: name}, namespace); err != nil {
+		return &corev1.Namespace{}, client.IgnoreNotFound(err)
+	}
+	return namespace, nil
+}
+
+// inheritNamespaceTTL sets the TTL annotation of the VMI namespace to the VMI, unless the VMI sets its own TTL
+func inheritNamespaceTTL(vmi *v1.VirtualMachineInstance, namespace *corev1.Namespace) {
+	if _, exists := vmi.Annotations[zonemgr.TTLAnnotation]; exists {
+		return
+	}
+	ttl, exists := namespace.Annotations[zonemgr.TTLAnnotation]
+	if !exists {
+		return
+	}
+	if vmi.Annotations == nil {
+		vmi.Annotations = map[string]string{}
+	}
+	vmi.Annotations[zonemgr.TTLAnnotation] = ttl
+}
+
+// namespaceVMIs returns the requests of the VMIs of the namespace
+func (r *VirtualMachineInstanceReconciler) namespaceVMIs(namespace client.Object) []reconcile.Request {
+	vmis := &v1.VirtualMachineInstanceList{}
+	if err := r.Client.List(context.TODO(), vmis, client.InNamespace(namespace.GetName())); err != nil {
+		r.Log.Error(err, "Error listing the namespace VMIs", "namespace", namespace.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(vmis.Items))
+	for _, vmi := range vmis.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}})
+	}
+	return requests
+}
+
+// syncVMIs reconciles all the VMIs once the cache is synced, and then tells the zone manager the VMIs are synced, so the
+// zones loaded from the zone files of before the start are rewritten with the records of all the VMIs. The VMIs are
+// reconciled by the controller as well, a VMI that fails here is retried by the controller.
+func (r *VirtualMachineInstanceReconciler) syncVMIs(ctx context.Context, vmisCache cache.Cache) error {
+	if !vmisCache.WaitForCacheSync(ctx) {
+		return nil
+	}
+	vmis := &v1.VirtualMachineInstanceList{}
+	if err := r.Client.List(ctx, vmis); err != nil {
+		return fmt.Errorf("failed to list the VMIs: %w", err)
+	}
+	for _, vmi := range vmis.Items {
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: vmi.Namespace, Name: vmi.Name}}
+		if _, err := r.Reconcile(ctx, request); err != nil {
+			r.Log.Error(err, "Error syncing VMI", "vmi", request.NamespacedName)
+		}
+	}
+	r.Log.Info("Synced the VMIs", "count", len(vmis.Items))
+	return r.ZoneManager.MarkSynced()
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *VirtualMachineInstanceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	onVMIEvent := predicate.Funcs{
@@ -79,8 +196,32 @@ func (r *VirtualMachineInstanceReconciler) SetupWithManager(mgr ctrl.Manager) er
 			return false
 		},
 	}
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.VirtualMachineInstance{}).
-		WithEventFilter(onVMIEvent).
+	// The VMIs of a namespace are reconciled when the namespace TTL annotation is changed, or when the namespace
+	// labels change whether it is selected
+	onNamespaceChange := predicate.Funcs{
+		CreateFunc: func(event.CreateEvent) bool {
+			return false
+		},
+		DeleteFunc: func(event.DeleteEvent) bool {
+			return false
+		},
+		UpdateFunc: func(updateEvent event.UpdateEvent) bool {
+			return updateEvent.ObjectOld.GetAnnotations()[zonemgr.TTLAnnotation] != updateEvent.ObjectNew.GetAnnotations()[zonemgr.TTLAnnotation] ||
+				r.ZoneManager.IsNamespaceSelected(updateEvent.ObjectOld.GetLabels()) != r.ZoneManager.IsNamespaceSelected(updateEvent.ObjectNew.GetLabels())
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+	err := ctrl.NewControllerManagedBy(mgr).
+		For(&v1.VirtualMachineInstance{}, builder.WithPredicates(onVMIEvent)).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.namespaceVMIs),
+			builder.WithPredicates(onNamespaceChange)).
 		Complete(r)
+	if err != nil {
+		return err
+	}
+	return mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		return r.syncVMIs(ctx, mgr.GetCache())
+	}))
 }
@@ -0,0 +1,10 @@
+package zonemgr
+
+// SetZonesDir sets the directory of the zone files, and returns a function that restores the previous directory
+func SetZonesDir(dir string) func() {
+	previousDir := zonesDir
+	zonesDir = dir
+	return func() {
+		zonesDir = previousDir
+	}
+}
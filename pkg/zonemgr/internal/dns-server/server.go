@@ -0,0 +1,326 @@
+package dns_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+)
+
+const (
+	// ednsUDPSize is the UDP payload size the server advertises, it avoids IP fragmentation (DNS flag day 2020)
+	ednsUDPSize = 1232
+	// maxCNAMEChain bounds the CNAME records followed within a zone, to break CNAME loops
+	maxCNAMEChain = 8
+)
+
+var log = logf.Log.WithName("dns-server")
+
+// Zone is the authoritative data of a zone
+type Zone interface {
+	// Origin returns the zone origin as an absolute name
+	Origin() string
+	// SOA returns the zone SOA record
+	SOA() *dns.SOA
+	// Lookup returns the records of the absolute name, and whether the name exists in the zone
+	Lookup(name string) ([]dns.RR, bool, error)
+	// Transfer returns the zone data of a transfer to a secondary server that has the given serial
+	Transfer(serial uint32, isIncremental bool) zone_file_cache.ZoneTransfer
+}
+
+// Zones holds the zones the server is authoritative for
+type Zones interface {
+	// View calls the view function with the zone that contains the absolute name, the zone is not changed while the
+	// view function runs. It returns false when no zone contains the name.
+	View(name string, view func(Zone)) bool
+}
+
+// Server is an authoritative DNS server that answers the queries from the zones, over UDP and TCP, and transfers the
+// zones to the secondary servers
+type Server struct {
+	address  string
+	zones    Zones
+	transfer *Transfer
+
+	lock      sync.Mutex
+	udpServer *dns.Server
+	tcpServer *dns.Server
+	// notifiers send the NOTIFY messages, by secondary server and zone. They run while notifyCtx is set, i.e. while the
+	// server serves.
+	notifiers      map[notifierKey]*notifier
+	notifyCtx      context.Context
+	notifiersGroup sync.WaitGroup
+}
+
+// NewServer creates a server that listens on the given address, e.g. ":5353". A nil transfer disables the zone
+// transfers.
+func NewServer(address string, zones Zones, transfer *Transfer) *Server {
+	return &Server{address: address, zones: zones, transfer: transfer, notifiers: map[notifierKey]*notifier{}}
+}
+
+// ValidateAddress checks that the address is a listen address, with a port
+func ValidateAddress(address string) error {
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid DNS server address %q: %w", address, err)
+	}
+	return nil
+}
+
+// Listen binds the UDP and the TCP sockets of the server address
+func (server *Server) Listen() error {
+	packetConn, err := net.ListenPacket("udp", server.address)
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", server.address)
+	if err != nil {
+		packetConn.Close()
+		return err
+	}
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	server.udpServer = &dns.Server{PacketConn: packetConn, Handler: server, TsigSecret: server.transfer.tsigSecrets()}
+	server.tcpServer = &dns.Server{Listener: listener, Handler: server, TsigSecret: server.transfer.tsigSecrets()}
+	return nil
+}
+
+// UDPAddr returns the address the UDP socket is bound to, once the server listens
+func (server *Server) UDPAddr() net.Addr {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.udpServer.PacketConn.LocalAddr()
+}
+
+// TCPAddr returns the address the TCP socket is bound to, once the server listens
+func (server *Server) TCPAddr() net.Addr {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	return server.tcpServer.Listener.Addr()
+}
+
+// Serve answers the queries and sends the NOTIFY messages until the context is done, the server must listen first, see
+// Listen
+func (server *Server) Serve(ctx context.Context) error {
+	server.lock.Lock()
+	dnsServers := []*dns.Server{server.udpServer, server.tcpServer}
+	server.lock.Unlock()
+	if dnsServers[0] == nil {
+		return errors.New("the DNS server does not listen")
+	}
+
+	var startedServers []*dns.Server
+	serveErrs := make(chan error, len(dnsServers))
+	for _, dnsServer := range dnsServers {
+		isStarted := make(chan struct{})
+		dnsServer.NotifyStartedFunc = func() { close(isStarted) }
+		go func(dnsServer *dns.Server) {
+			serveErrs <- dnsServer.ActivateAndServe()
+		}(dnsServer)
+		select {
+		case <-isStarted:
+			startedServers = append(startedServers, dnsServer)
+		case err := <-serveErrs:
+			return utilerrors.NewAggregate([]error{err, shutdown(startedServers)})
+		}
+	}
+	log.Info("serving DNS", "udp", server.UDPAddr().String(), "tcp", server.TCPAddr().String())
+	stopNotifiers := server.startNotifiers(ctx)
+
+	var errs []error
+	select {
+	case <-ctx.Done():
+	case err := <-serveErrs:
+		errs = append(errs, err)
+	}
+	stopNotifiers()
+	errs = append(errs, shutdown(startedServers))
+	return utilerrors.NewAggregate(errs)
+}
+
+func shutdown(dnsServers []*dns.Server) error {
+	var errs []error
+	for _, dnsServer := range dnsServers {
+		if err := dnsServer.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// ServeDNS answers a query, it implements the dns.Handler. A signed request is answered only when its TSIG is valid.
+func (server *Server) ServeDNS(writer dns.ResponseWriter, request *dns.Msg) {
+	if request.IsTsig() != nil && !server.isTSIGValid(writer) {
+		server.writeReply(writer, request, new(dns.Msg).SetRcode(request, dns.RcodeNotAuth))
+		return
+	}
+	if request.Opcode == dns.OpcodeQuery && len(request.Question) == 1 {
+		switch request.Question[0].Qtype {
+		case dns.TypeAXFR, dns.TypeIXFR:
+			server.serveTransfer(writer, request)
+			return
+		}
+	}
+	server.writeReply(writer, request, server.answer(request))
+}
+
+func (server *Server) isTSIGValid(writer dns.ResponseWriter) bool {
+	return server.transfer.tsigSecrets() != nil && writer.TsigStatus() == nil
+}
+
+// writeReply writes the reply, signed when the request is validly signed, and truncated to the UDP payload size over UDP
+func (server *Server) writeReply(writer dns.ResponseWriter, request *dns.Msg, reply *dns.Msg) {
+	if tsig := request.IsTsig(); tsig != nil && server.isTSIGValid(writer) {
+		reply.SetTsig(tsig.Hdr.Name, tsig.Algorithm, tsig.Fudge, time.Now().Unix())
+	}
+	if _, isUDP := writer.LocalAddr().(*net.UDPAddr); isUDP {
+		reply.Truncate(udpSize(request))
+	}
+	if err := writer.WriteMsg(reply); err != nil {
+		log.Error(err, "failed to write the DNS reply", "client", writer.RemoteAddr().String())
+	}
+}
+
+// udpSize returns the UDP payload size of the reply, the size the client advertises up to the server size
+func udpSize(request *dns.Msg) int {
+	opt := request.IsEdns0()
+	if opt == nil {
+		return dns.MinMsgSize
+	}
+	if opt.UDPSize() > ednsUDPSize {
+		return ednsUDPSize
+	}
+	return int(opt.UDPSize())
+}
+
+// answer returns the reply of the query, a query for a name out of the zones is refused
+func (server *Server) answer(request *dns.Msg) *dns.Msg {
+	reply := new(dns.Msg)
+	if request.Opcode != dns.OpcodeQuery {
+		return reply.SetRcode(request, dns.RcodeNotImplemented)
+	}
+	if len(request.Question) != 1 {
+		return reply.SetRcode(request, dns.RcodeFormatError)
+	}
+	reply.SetReply(request)
+	opt := request.IsEdns0()
+	if opt != nil {
+		reply.SetEdns0(ednsUDPSize, false)
+		if opt.Version() != 0 {
+			reply.Rcode = dns.RcodeBadVers
+			return reply
+		}
+	}
+
+	question := request.Question[0]
+	if question.Qclass != dns.ClassINET && question.Qclass != dns.ClassANY {
+		reply.Rcode = dns.RcodeRefused
+		return reply
+	}
+	if isInZones := server.zones.View(question.Name, func(zone Zone) {
+		answerFromZone(reply, zone, question)
+	}); !isInZones {
+		reply.Rcode = dns.RcodeRefused
+	}
+	return reply
+}
+
+// answerFromZone sets the authoritative answer of the question. A name without records, that exists as it has
+// records below it, has no data. A CNAME record is followed while its target is in the zone, the rcode is the rcode of
+// the last name of the chain (RFC 6604). Negative answers carry the SOA record (RFC 2308).
+func answerFromZone(reply *dns.Msg, zone Zone, question dns.Question) {
+	reply.Authoritative = true
+	name := question.Name
+	for i := 0; i < maxCNAMEChain; i++ {
+		rrs, exists, err := zone.Lookup(name)
+		if err != nil {
+			log.Error(err, "failed to look up the zone records", "name", name)
+			reply.Rcode = dns.RcodeServerFailure
+			return
+		}
+		if !exists {
+			reply.Rcode = dns.RcodeNameError
+			reply.Ns = append(reply.Ns, negativeSOA(zone))
+			return
+		}
+
+		answers := filterRRs(rrs, question.Qtype)
+		if len(answers) > 0 {
+			reply.Answer = append(reply.Answer, answers...)
+			reply.Extra = append(reply.Extra, additionalRRs(zone, answers)...)
+			return
+		}
+		cnames := filterRRs(rrs, dns.TypeCNAME)
+		if len(cnames) == 0 {
+			reply.Ns = append(reply.Ns, negativeSOA(zone))
+			return
+		}
+		reply.Answer = append(reply.Answer, cnames[0])
+		name = cnames[0].(*dns.CNAME).Target
+		if !dns.IsSubDomain(zone.Origin(), name) {
+			return
+		}
+	}
+}
+
+// filterRRs returns the records of the requested type, all the records for the ANY type
+func filterRRs(rrs []dns.RR, qtype uint16) []dns.RR {
+	if qtype == dns.TypeANY {
+		return rrs
+	}
+	var filteredRRs []dns.RR
+	for _, rr := range rrs {
+		if rr.Header().Rrtype == qtype {
+			filteredRRs = append(filteredRRs, rr)
+		}
+	}
+	return filteredRRs
+}
+
+// additionalRRs returns the address records of the NS and SRV records targets that are in the zone, so the client does
+// not need to query them
+func additionalRRs(zone Zone, answers []dns.RR) []dns.RR {
+	var additionals []dns.RR
+	for _, answer := range answers {
+		var target string
+		switch rr := answer.(type) {
+		case *dns.NS:
+			target = rr.Ns
+		case *dns.SRV:
+			target = rr.Target
+		default:
+			continue
+		}
+		if !dns.IsSubDomain(zone.Origin(), target) {
+			continue
+		}
+		rrs, _, err := zone.Lookup(target)
+		if err != nil {
+			continue
+		}
+		for _, rr := range rrs {
+			if rrtype := rr.Header().Rrtype; rrtype == dns.TypeA || rrtype == dns.TypeAAAA {
+				additionals = append(additionals, rr)
+			}
+		}
+	}
+	return additionals
+}
+
+// negativeSOA returns the SOA record of a negative answer, its TTL is the negative caching TTL, bounded by the SOA TTL
+// (RFC 2308 section 3)
+func negativeSOA(zone Zone) *dns.SOA {
+	soa := zone.SOA()
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
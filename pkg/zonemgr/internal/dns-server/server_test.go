@@ -0,0 +1,278 @@
+package dns_server_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-server"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+)
+
+const (
+	soaLine = "vm.domain.com. 3600 IN SOA ns.vm.domain.com. email.vm.domain.com. 5 3600 3600 1209600 300"
+	// negativeSOALine is the SOA record of the negative answers, its TTL is the negative caching TTL
+	negativeSOALine = "vm.domain.com. 300 IN SOA ns.vm.domain.com. email.vm.domain.com. 5 3600 3600 1209600 300"
+)
+
+const zoneContent = `$ORIGIN vm.domain.com.
+$TTL 3600
+@ IN SOA ns.vm.domain.com. email.vm.domain.com. (5 3600 3600 1209600 300)
+@ IN NS ns.vm.domain.com.
+ns IN A 10.0.0.1
+nic1.vmi1.ns1 IN A 10.0.0.5
+nic1.vmi1.ns1 IN AAAA fd00::5
+vmi1.ns1 60 IN A 10.0.0.5
+db.ns1 IN CNAME nic1.vmi1.ns1
+web.ns1 IN CNAME www.example.com.
+dangling.ns1 IN CNAME missing.ns1
+loop1.ns1 IN CNAME loop2.ns1
+loop2.ns1 IN CNAME loop1.ns1
+_etcd._tcp.ns1 IN SRV 10 100 2380 nic1.vmi1.ns1
+`
+
+var _ = Describe("DNS server", func() {
+	var server *dns_server.Server
+	var cancel context.CancelFunc
+	var serveErr chan error
+
+	BeforeEach(func() {
+		content := zoneContent
+		for i := 1; i <= 100; i++ {
+			content += fmt.Sprintf("big.ns1 IN A 10.0.1.%d\n", i)
+		}
+		server = dns_server.NewServer("127.0.0.1:0", &zonesStub{zones: []*zoneStub{newZoneStub(content)}}, nil)
+		Expect(server.Listen()).To(Succeed())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		serveErr = make(chan error, 1)
+		go func() {
+			serveErr <- server.Serve(ctx)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(serveErr).Should(Receive(BeNil()))
+	})
+
+	exchange := func(network string, request *dns.Msg) *dns.Msg {
+		address := server.UDPAddr().String()
+		if network == "tcp" {
+			address = server.TCPAddr().String()
+		}
+		reply, _, err := (&dns.Client{Net: network}).Exchange(request, address)
+		Expect(err).ToNot(HaveOccurred())
+		return reply
+	}
+
+	query := func(network string, name string, qtype uint16) *dns.Msg {
+		return exchange(network, new(dns.Msg).SetQuestion(name, qtype))
+	}
+
+	DescribeTable("answer the queries", func(name string, qtype uint16, expectedRcode int, expectedAnswer []string,
+		expectedNs []string, expectedExtra []string) {
+		for _, network := range []string{"udp", "tcp"} {
+			reply := query(network, name, qtype)
+			Expect(reply.Rcode).To(Equal(expectedRcode), network)
+			Expect(reply.Authoritative).To(BeTrue(), network)
+			Expect(rrLines(reply.Answer)).To(Equal(expectedAnswer), network)
+			Expect(rrLines(reply.Ns)).To(Equal(expectedNs), network)
+			Expect(rrLines(reply.Extra)).To(Equal(expectedExtra), network)
+		}
+	},
+		Entry("address record", "nic1.vmi1.ns1.vm.domain.com.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5"}, nil, nil),
+		Entry("name in another case", "NIC1.Vmi1.ns1.VM.domain.com.", dns.TypeAAAA, dns.RcodeSuccess,
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5"}, nil, nil),
+		Entry("record with its own TTL", "vmi1.ns1.vm.domain.com.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"vmi1.ns1.vm.domain.com. 60 IN A 10.0.0.5"}, nil, nil),
+		Entry("all the records of a name", "nic1.vmi1.ns1.vm.domain.com.", dns.TypeANY, dns.RcodeSuccess,
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5", "nic1.vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5"}, nil, nil),
+		Entry("SOA record", "vm.domain.com.", dns.TypeSOA, dns.RcodeSuccess,
+			[]string{soaLine}, nil, nil),
+		Entry("NS records along with the glue records", "vm.domain.com.", dns.TypeNS, dns.RcodeSuccess,
+			[]string{"vm.domain.com. 3600 IN NS ns.vm.domain.com."}, nil, []string{"ns.vm.domain.com. 3600 IN A 10.0.0.1"}),
+		Entry("SRV records along with the target address records", "_etcd._tcp.ns1.vm.domain.com.", dns.TypeSRV, dns.RcodeSuccess,
+			[]string{"_etcd._tcp.ns1.vm.domain.com. 3600 IN SRV 10 100 2380 nic1.vmi1.ns1.vm.domain.com."}, nil,
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5", "nic1.vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5"}),
+		Entry("CNAME record that is followed in the zone", "db.ns1.vm.domain.com.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"db.ns1.vm.domain.com. 3600 IN CNAME nic1.vmi1.ns1.vm.domain.com.", "nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5"},
+			nil, nil),
+		Entry("CNAME record query", "db.ns1.vm.domain.com.", dns.TypeCNAME, dns.RcodeSuccess,
+			[]string{"db.ns1.vm.domain.com. 3600 IN CNAME nic1.vmi1.ns1.vm.domain.com."}, nil, nil),
+		Entry("CNAME record that targets a name out of the zone", "web.ns1.vm.domain.com.", dns.TypeA, dns.RcodeSuccess,
+			[]string{"web.ns1.vm.domain.com. 3600 IN CNAME www.example.com."}, nil, nil),
+		Entry("CNAME record that targets a missing name", "dangling.ns1.vm.domain.com.", dns.TypeA, dns.RcodeNameError,
+			[]string{"dangling.ns1.vm.domain.com. 3600 IN CNAME missing.ns1.vm.domain.com."}, []string{negativeSOALine}, nil),
+		Entry("name that does not exist", "nic2.vmi1.ns1.vm.domain.com.", dns.TypeA, dns.RcodeNameError,
+			nil, []string{negativeSOALine}, nil),
+		Entry("name without records of the requested type", "vmi1.ns1.vm.domain.com.", dns.TypeAAAA, dns.RcodeSuccess,
+			nil, []string{negativeSOALine}, nil),
+		Entry("empty non-terminal name", "ns1.vm.domain.com.", dns.TypeA, dns.RcodeSuccess,
+			nil, []string{negativeSOALine}, nil),
+	)
+
+	It("should stop following a CNAME loop", func() {
+		reply := query("udp", "loop1.ns1.vm.domain.com.", dns.TypeA)
+		Expect(reply.Rcode).To(Equal(dns.RcodeSuccess))
+		Expect(len(reply.Answer)).To(BeNumerically(">", 1))
+	})
+
+	It("should refuse a query for a name out of the zones", func() {
+		reply := query("udp", "www.example.com.", dns.TypeA)
+		Expect(reply.Rcode).To(Equal(dns.RcodeRefused))
+		Expect(reply.Authoritative).To(BeFalse())
+	})
+
+	It("should refuse a query of another class", func() {
+		request := new(dns.Msg).SetQuestion("nic1.vmi1.ns1.vm.domain.com.", dns.TypeA)
+		request.Question[0].Qclass = dns.ClassCHAOS
+		Expect(exchange("udp", request).Rcode).To(Equal(dns.RcodeRefused))
+	})
+
+	It("should refuse a zone transfer when the transfers are disabled", func() {
+		Expect(query("tcp", "vm.domain.com.", dns.TypeAXFR).Rcode).To(Equal(dns.RcodeRefused))
+	})
+
+	It("should truncate an answer that does not fit in a UDP reply", func() {
+		reply := query("udp", "big.ns1.vm.domain.com.", dns.TypeA)
+		Expect(reply.Truncated).To(BeTrue())
+		Expect(len(reply.Answer)).To(BeNumerically("<", 100))
+
+		reply = query("tcp", "big.ns1.vm.domain.com.", dns.TypeA)
+		Expect(reply.Truncated).To(BeFalse())
+		Expect(reply.Answer).To(HaveLen(100))
+	})
+
+	It("should fit the answer in the UDP payload size of an EDNS query", func() {
+		request := new(dns.Msg).SetQuestion("big.ns1.vm.domain.com.", dns.TypeA)
+		request.SetEdns0(4096, false)
+		reply := exchange("udp", request)
+		Expect(reply.IsEdns0()).ToNot(BeNil())
+		Expect(reply.Truncated).To(BeTrue())
+		reply.Compress = true
+		Expect(reply.Len()).To(BeNumerically("<=", 1232))
+		Expect(len(reply.Answer)).To(BeNumerically(">", 30))
+	})
+
+	It("should reply BADVERS to an unsupported EDNS version", func() {
+		request := new(dns.Msg).SetQuestion("nic1.vmi1.ns1.vm.domain.com.", dns.TypeA)
+		request.SetEdns0(4096, false)
+		request.IsEdns0().SetVersion(1)
+		reply := exchange("udp", request)
+		Expect(reply.Rcode).To(Equal(dns.RcodeBadVers))
+		Expect(reply.Answer).To(BeEmpty())
+	})
+
+	It("should reject a dynamic update", func() {
+		request := new(dns.Msg).SetUpdate("vm.domain.com.")
+		Expect(exchange("udp", request).Rcode).To(Equal(dns.RcodeNotImplemented))
+	})
+})
+
+var _ = Describe("DNS server lifecycle", func() {
+	It("should fail to serve before listening", func() {
+		server := dns_server.NewServer("127.0.0.1:0", &zonesStub{}, nil)
+		Expect(server.Serve(context.Background())).ToNot(Succeed())
+	})
+
+	DescribeTable("validate the address", func(address string, isValid bool) {
+		err := dns_server.ValidateAddress(address)
+		if isValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("port only", ":5353", true),
+		Entry("IP and port", "10.0.0.1:53", true),
+		Entry("IPv6 and port", "[fd00::1]:53", true),
+		Entry("missing port", "10.0.0.1", false),
+	)
+})
+
+// rrLines returns the records in the zone file format, with single spaces
+func rrLines(rrs []dns.RR) []string {
+	var lines []string
+	for _, rr := range rrs {
+		lines = append(lines, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	return lines
+}
+
+type zoneStub struct {
+	origin  string
+	soa     *dns.SOA
+	records map[string][]dns.RR
+	content string
+	// changes is the journal of the zone, up to the serial of the SOA record
+	changes []zone_file_cache.ZoneChange
+}
+
+func newZoneStub(content string) *zoneStub {
+	zone := &zoneStub{records: map[string][]dns.RR{}, content: content}
+	zoneParser := dns.NewZoneParser(strings.NewReader(content), "", "")
+	for rr, ok := zoneParser.Next(); ok; rr, ok = zoneParser.Next() {
+		if soa, isSOA := rr.(*dns.SOA); isSOA {
+			zone.origin = soa.Hdr.Name
+			zone.soa = soa
+		}
+		name := strings.ToLower(rr.Header().Name)
+		zone.records[name] = append(zone.records[name], rr)
+	}
+	Expect(zoneParser.Err()).ToNot(HaveOccurred())
+	return zone
+}
+
+func (zone *zoneStub) Origin() string {
+	return zone.origin
+}
+
+func (zone *zoneStub) SOA() *dns.SOA {
+	return dns.Copy(zone.soa).(*dns.SOA)
+}
+
+func (zone *zoneStub) Lookup(name string) ([]dns.RR, bool, error) {
+	name = strings.ToLower(name)
+	rrs := zone.records[name]
+	exists := len(rrs) > 0
+	for recordName := range zone.records {
+		exists = exists || strings.HasSuffix(recordName, "."+name)
+	}
+	return rrs, exists, nil
+}
+
+func (zone *zoneStub) Transfer(serial uint32, isIncremental bool) zone_file_cache.ZoneTransfer {
+	if isIncremental {
+		if serial >= zone.soa.Serial {
+			return zone_file_cache.ZoneTransfer{IsIncremental: true}
+		}
+		for i, change := range zone.changes {
+			if change.FromSerial == serial {
+				return zone_file_cache.ZoneTransfer{IsIncremental: true, Changes: zone.changes[i:]}
+			}
+		}
+	}
+	return zone_file_cache.ZoneTransfer{Content: zone.content}
+}
+
+type zonesStub struct {
+	zones []*zoneStub
+}
+
+func (zones *zonesStub) View(name string, view func(dns_server.Zone)) bool {
+	for _, zone := range zones.zones {
+		if dns.IsSubDomain(zone.origin, name) {
+			view(zone)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,393 @@
+package dns_server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/tsig"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+)
+
+const (
+	secondariesSeparator = ","
+	secondaryDefaultPort = "53"
+
+	// transferEnvelopeSize bounds the records size of a transfer message, a message can not exceed 64KB
+	transferEnvelopeSize = 16 * 1024
+
+	notifyAttempts      = 5
+	notifyRetryInterval = 2 * time.Second
+)
+
+// TransferParams holds the raw zone transfer parameters
+type TransferParams struct {
+	// Secondaries is a comma separated list of the secondary servers addresses, <IP>[:<port>], they are sent NOTIFY
+	// messages when the zones change, and are allowed to transfer the zones when there is no TSIG key
+	Secondaries string
+	// TSIGKeyName, TSIGSecret and TSIGAlgorithm set the TSIG key that authenticates the transfers and the NOTIFY
+	// messages, the secret is base64 encoded
+	TSIGKeyName   string
+	TSIGSecret    string
+	TSIGAlgorithm string
+}
+
+// Transfer is the zone transfer configuration
+type Transfer struct {
+	secondaries  []string
+	secondaryIPs map[string]bool
+	tsigKey      *tsig.Key
+}
+
+// NewTransfer validates the zone transfer parameters
+func NewTransfer(params TransferParams) (*Transfer, error) {
+	transfer := &Transfer{secondaryIPs: map[string]bool{}}
+	for _, secondary := range strings.Split(params.Secondaries, secondariesSeparator) {
+		if secondary = strings.TrimSpace(secondary); secondary == "" {
+			continue
+		}
+		address, err := parseSecondary(secondary)
+		if err != nil {
+			return nil, err
+		}
+		host, _, _ := net.SplitHostPort(address)
+		transfer.secondaries = append(transfer.secondaries, address)
+		transfer.secondaryIPs[net.ParseIP(host).String()] = true
+	}
+
+	var err error
+	if transfer.tsigKey, err = tsig.NewKey(params.TSIGKeyName, params.TSIGSecret, params.TSIGAlgorithm); err != nil {
+		return nil, err
+	}
+	return transfer, nil
+}
+
+// parseSecondary returns the secondary server address, with the default DNS port when it is omitted
+func parseSecondary(secondary string) (string, error) {
+	host, port, err := net.SplitHostPort(secondary)
+	if err != nil {
+		host, port = strings.Trim(secondary, "[]"), secondaryDefaultPort
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid secondary server %q, must be <IP>[:<port>]", secondary)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// tsigSecrets returns the TSIG secrets the server verifies the requests with
+func (transfer *Transfer) tsigSecrets() map[string]string {
+	if transfer == nil {
+		return nil
+	}
+	return transfer.tsigKey.Secrets()
+}
+
+// isAllowed returns whether the client may transfer the zones: when there is a TSIG key the request must be signed by
+// it, otherwise the client must be one of the secondary servers
+func (transfer *Transfer) isAllowed(writer dns.ResponseWriter, request *dns.Msg) bool {
+	if transfer == nil {
+		return false
+	}
+	if transfer.tsigKey != nil {
+		return request.IsTsig() != nil && writer.TsigStatus() == nil
+	}
+	host, _, err := net.SplitHostPort(writer.RemoteAddr().String())
+	return err == nil && transfer.secondaryIPs[net.ParseIP(host).String()]
+}
+
+// serveTransfer answers an AXFR or IXFR request, over TCP. An IXFR request over UDP is answered with the SOA record
+// only, so the secondary server retries over TCP when it is not up to date (RFC 1995 section 2).
+func (server *Server) serveTransfer(writer dns.ResponseWriter, request *dns.Msg) {
+	question := request.Question[0]
+	if !server.transfer.isAllowed(writer, request) {
+		server.writeReply(writer, request, new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+		return
+	}
+
+	var clientSerial uint32
+	isIncremental := question.Qtype == dns.TypeIXFR
+	if isIncremental {
+		if len(request.Ns) != 1 || request.Ns[0].Header().Rrtype != dns.TypeSOA {
+			server.writeReply(writer, request, new(dns.Msg).SetRcode(request, dns.RcodeFormatError))
+			return
+		}
+		clientSerial = request.Ns[0].(*dns.SOA).Serial
+	}
+
+	var soa *dns.SOA
+	var zoneTransfer zone_file_cache.ZoneTransfer
+	isZoneOrigin := false
+	server.zones.View(question.Name, func(zone Zone) {
+		if isZoneOrigin = dns.CanonicalName(zone.Origin()) == dns.CanonicalName(question.Name); isZoneOrigin {
+			soa = zone.SOA()
+			zoneTransfer = zone.Transfer(clientSerial, isIncremental)
+		}
+	})
+	if !isZoneOrigin {
+		server.writeReply(writer, request, new(dns.Msg).SetRcode(request, dns.RcodeNotAuth))
+		return
+	}
+
+	_, isUDP := writer.LocalAddr().(*net.UDPAddr)
+	if isUDP {
+		if !isIncremental {
+			server.writeReply(writer, request, new(dns.Msg).SetRcode(request, dns.RcodeRefused))
+			return
+		}
+		reply := new(dns.Msg).SetReply(request)
+		reply.Authoritative = true
+		reply.Answer = []dns.RR{soa}
+		server.writeReply(writer, request, reply)
+		return
+	}
+
+	envelopes := make(chan *dns.Envelope)
+	go func() {
+		defer close(envelopes)
+		// A transfer that is cut short lacks its closing SOA record, therefore the secondary server discards it
+		if err := streamTransfer(envelopes, soa, zoneTransfer); err != nil {
+			log.Error(err, "failed to render the zone transfer", "zone", question.Name)
+		}
+	}()
+	if err := new(dns.Transfer).Out(writer, request, envelopes); err != nil {
+		log.Error(err, "failed to transfer the zone", "zone", question.Name, "client", writer.RemoteAddr().String())
+		for range envelopes {
+		}
+	}
+}
+
+// streamTransfer sends the records of the transfer, bounded by SOA records of the current serial. An incremental
+// transfer sends the removed and the added records of each change, each bounded by the SOA records of the serials of
+// the change (RFC 1995 section 4), a full transfer sends the zone content (RFC 5936).
+func streamTransfer(envelopes chan<- *dns.Envelope, soa *dns.SOA, zoneTransfer zone_file_cache.ZoneTransfer) error {
+	sender := &envelopeSender{envelopes: envelopes}
+	sender.send(soa)
+	if zoneTransfer.IsIncremental {
+		if len(zoneTransfer.Changes) == 0 {
+			sender.flush()
+			return nil
+		}
+		for _, change := range zoneTransfer.Changes {
+			fromSOA := dns.Copy(soa).(*dns.SOA)
+			fromSOA.Serial = change.FromSerial
+			sender.send(fromSOA)
+			if err := sender.sendContent(change.Removed, soa.Hdr.Name, soa.Hdr.Ttl); err != nil {
+				return err
+			}
+			toSOA := dns.Copy(soa).(*dns.SOA)
+			toSOA.Serial = change.ToSerial
+			sender.send(toSOA)
+			if err := sender.sendContent(change.Added, soa.Hdr.Name, soa.Hdr.Ttl); err != nil {
+				return err
+			}
+		}
+	} else if err := sender.sendContent(zoneTransfer.Content, soa.Hdr.Name, soa.Hdr.Ttl); err != nil {
+		return err
+	}
+	sender.send(soa)
+	sender.flush()
+	return nil
+}
+
+// envelopeSender groups the transfer records into messages
+type envelopeSender struct {
+	envelopes chan<- *dns.Envelope
+	rrs       []dns.RR
+	size      int
+}
+
+func (sender *envelopeSender) send(rr dns.RR) {
+	sender.rrs = append(sender.rrs, rr)
+	if sender.size += dns.Len(rr); sender.size >= transferEnvelopeSize {
+		sender.flush()
+	}
+}
+
+// sendContent sends the records of the zone file content, but its SOA record, which the transfer sends by itself
+func (sender *envelopeSender) sendContent(content string, origin string, ttl uint32) error {
+	zoneParser := dns.NewZoneParser(strings.NewReader(content), origin, "")
+	zoneParser.SetDefaultTTL(ttl)
+	for rr, ok := zoneParser.Next(); ok; rr, ok = zoneParser.Next() {
+		if rr.Header().Rrtype != dns.TypeSOA {
+			sender.send(rr)
+		}
+	}
+	return zoneParser.Err()
+}
+
+func (sender *envelopeSender) flush() {
+	if len(sender.rrs) > 0 {
+		sender.envelopes <- &dns.Envelope{RR: sender.rrs}
+	}
+	sender.rrs, sender.size = nil, 0
+}
+
+// notifierKey identifies the notifier of a zone to a secondary server
+type notifierKey struct {
+	secondary string
+	origin    string
+}
+
+// notifier sends the NOTIFY messages of a zone to a secondary server, one at a time. The serials that are notified
+// while a message is sent are coalesced, the secondary server is sent the latest serial only, so the serials are never
+// sent out of order.
+type notifier struct {
+	secondary string
+
+	lock sync.Mutex
+	// soa is the SOA record of the serial to send, nil once it is taken for sending
+	soa *dns.SOA
+	// changed is signaled when the serial to send is set
+	changed chan struct{}
+}
+
+func newNotifier(secondary string) *notifier {
+	return &notifier{secondary: secondary, changed: make(chan struct{}, 1)}
+}
+
+// setSOA sets the serial to send, it replaces a serial that was not sent yet
+func (zoneNotifier *notifier) setSOA(soa *dns.SOA) {
+	zoneNotifier.lock.Lock()
+	zoneNotifier.soa = soa
+	zoneNotifier.lock.Unlock()
+	select {
+	case zoneNotifier.changed <- struct{}{}:
+	default:
+	}
+}
+
+// takeSOA returns the serial to send, or nil when it was already taken
+func (zoneNotifier *notifier) takeSOA() *dns.SOA {
+	zoneNotifier.lock.Lock()
+	defer zoneNotifier.lock.Unlock()
+	soa := zoneNotifier.soa
+	zoneNotifier.soa = nil
+	return soa
+}
+
+// Notify tells the secondary servers that the zone changed to the serial of the given SOA record (RFC 1996), so they
+// transfer it. The messages are sent in the background while the server serves, see Serve, by a notifier per secondary
+// server and zone, and are sent again until the secondary servers acknowledge them.
+func (server *Server) Notify(soa *dns.SOA) {
+	if server.transfer == nil {
+		return
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	for _, secondary := range server.transfer.secondaries {
+		key := notifierKey{secondary: secondary, origin: dns.CanonicalName(soa.Hdr.Name)}
+		zoneNotifier, exists := server.notifiers[key]
+		if !exists {
+			zoneNotifier = newNotifier(secondary)
+			server.notifiers[key] = zoneNotifier
+			if server.notifyCtx != nil {
+				server.goRunNotifier(server.notifyCtx, zoneNotifier)
+			}
+		}
+		zoneNotifier.setSOA(soa)
+	}
+}
+
+// startNotifiers runs the notifiers, along with the notifiers that Notify adds meanwhile, until the returned stop
+// function is called
+func (server *Server) startNotifiers(ctx context.Context) func() {
+	notifyCtx, cancelNotify := context.WithCancel(ctx)
+	server.lock.Lock()
+	server.notifyCtx = notifyCtx
+	for _, zoneNotifier := range server.notifiers {
+		server.goRunNotifier(notifyCtx, zoneNotifier)
+	}
+	server.lock.Unlock()
+
+	return func() {
+		cancelNotify()
+		server.lock.Lock()
+		server.notifyCtx = nil
+		server.lock.Unlock()
+		server.notifiersGroup.Wait()
+	}
+}
+
+// goRunNotifier runs the notifier in the background, the server lock must be held
+func (server *Server) goRunNotifier(ctx context.Context, zoneNotifier *notifier) {
+	server.notifiersGroup.Add(1)
+	go func() {
+		defer server.notifiersGroup.Done()
+		server.runNotifier(ctx, zoneNotifier)
+	}()
+}
+
+// runNotifier sends the serials set to the notifier until the context is done
+func (server *Server) runNotifier(ctx context.Context, zoneNotifier *notifier) {
+	client := &dns.Client{TsigSecret: server.transfer.tsigSecrets()}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-zoneNotifier.changed:
+		}
+		if soa := zoneNotifier.takeSOA(); soa != nil {
+			server.notify(ctx, client, zoneNotifier, soa)
+		}
+	}
+}
+
+// notify sends the NOTIFY message until the secondary server acknowledges it, up to notifyAttempts times. A serial
+// that is set to the notifier meanwhile is sent instead, with attempts of its own.
+func (server *Server) notify(ctx context.Context, client *dns.Client, zoneNotifier *notifier, soa *dns.SOA) {
+	for attempt := 1; ; attempt++ {
+		err := server.sendNotify(ctx, client, zoneNotifier.secondary, soa)
+		if err == nil || ctx.Err() != nil {
+			return
+		}
+		if attempt == notifyAttempts {
+			log.Error(err, "failed to notify the secondary server", "secondary", zoneNotifier.secondary,
+				"zone", soa.Hdr.Name, "serial", soa.Serial)
+			return
+		}
+
+		retry := time.NewTimer(notifyRetryInterval)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			return
+		case <-zoneNotifier.changed:
+			retry.Stop()
+			if newerSOA := zoneNotifier.takeSOA(); newerSOA != nil {
+				soa, attempt = newerSOA, 0
+			}
+		case <-retry.C:
+		}
+	}
+}
+
+// sendNotify sends the NOTIFY message and waits for the secondary server answer, the exchange is cut short when the
+// context is done
+func (server *Server) sendNotify(ctx context.Context, client *dns.Client, secondary string, soa *dns.SOA) error {
+	request := new(dns.Msg).SetNotify(soa.Hdr.Name)
+	request.Answer = []dns.RR{soa}
+	if tsigKey := server.transfer.tsigKey; tsigKey != nil {
+		tsigKey.Sign(request)
+	}
+	conn, err := client.DialContext(ctx, secondary)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stopClose := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stopClose()
+
+	reply, _, err := client.ExchangeWithConn(request, conn)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("NOTIFY answered with %s", dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
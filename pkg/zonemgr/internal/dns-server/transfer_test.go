@@ -0,0 +1,298 @@
+package dns_server_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"net"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-server"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+)
+
+const (
+	tsigKeyName = "transfer.key."
+	tsigSecret  = "c2VjcmV0LXRzaWcta2V5LWZvci10ZXN0cw=="
+)
+
+var _ = Describe("Zone transfer", func() {
+	var server *dns_server.Server
+	var zone *zoneStub
+	var cancel context.CancelFunc
+	var serveErr chan error
+
+	startServer := func(params dns_server.TransferParams) {
+		transfer, err := dns_server.NewTransfer(params)
+		Expect(err).ToNot(HaveOccurred())
+		zone = newZoneStub(zoneContent)
+		zone.changes = []zone_file_cache.ZoneChange{
+			{FromSerial: 3, ToSerial: 4, Removed: "old.ns1 IN A 10.0.0.8\n", Added: "new.ns1 IN A 10.0.0.9\n"},
+			{FromSerial: 4, ToSerial: 5, Removed: "new.ns1 IN A 10.0.0.9\n"},
+		}
+		server = dns_server.NewServer("127.0.0.1:0", &zonesStub{zones: []*zoneStub{zone}}, transfer)
+		Expect(server.Listen()).To(Succeed())
+
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		serveErr = make(chan error, 1)
+		go func() {
+			serveErr <- server.Serve(ctx)
+		}()
+	}
+
+	AfterEach(func() {
+		cancel()
+		Eventually(serveErr).Should(Receive(BeNil()))
+	})
+
+	// transferIn returns the records of the transfer, or the error it failed with
+	transferIn := func(request *dns.Msg, tsigSecrets map[string]string) ([]string, error) {
+		transfer := &dns.Transfer{TsigSecret: tsigSecrets}
+		envelopes, err := transfer.In(request, server.TCPAddr().String())
+		if err != nil {
+			return nil, err
+		}
+		var lines []string
+		for envelope := range envelopes {
+			if envelope.Error != nil {
+				return nil, envelope.Error
+			}
+			lines = append(lines, rrLines(envelope.RR)...)
+		}
+		return lines, nil
+	}
+
+	ixfrRequest := func(serial uint32) *dns.Msg {
+		return new(dns.Msg).SetIxfr("vm.domain.com.", serial, "ns.vm.domain.com.", "email.vm.domain.com.")
+	}
+
+	soaLineOf := func(serial string) string {
+		return "vm.domain.com. 3600 IN SOA ns.vm.domain.com. email.vm.domain.com. " + serial + " 3600 3600 1209600 300"
+	}
+
+	Context("to a secondary server", func() {
+		BeforeEach(func() {
+			startServer(dns_server.TransferParams{Secondaries: "127.0.0.1:5300, 10.0.0.2"})
+		})
+
+		It("should transfer the full zone", func() {
+			lines, err := transferIn(new(dns.Msg).SetAxfr("vm.domain.com."), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lines).To(HaveLen(13))
+			Expect(lines[0]).To(Equal(soaLine))
+			Expect(lines[len(lines)-1]).To(Equal(soaLine))
+			Expect(lines).To(ContainElements("vm.domain.com. 3600 IN NS ns.vm.domain.com.",
+				"vmi1.ns1.vm.domain.com. 60 IN A 10.0.0.5", "_etcd._tcp.ns1.vm.domain.com. 3600 IN SRV 10 100 2380 nic1.vmi1.ns1.vm.domain.com."))
+		})
+
+		It("should transfer the changes since the serial of the secondary server", func() {
+			lines, err := transferIn(ixfrRequest(3), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lines).To(Equal([]string{
+				soaLine,
+				soaLineOf("3"),
+				"old.ns1.vm.domain.com. 3600 IN A 10.0.0.8",
+				soaLineOf("4"),
+				"new.ns1.vm.domain.com. 3600 IN A 10.0.0.9",
+				soaLineOf("4"),
+				"new.ns1.vm.domain.com. 3600 IN A 10.0.0.9",
+				soaLine,
+				soaLine,
+			}))
+		})
+
+		It("should transfer the full zone when the changes since the serial are not journaled", func() {
+			lines, err := transferIn(ixfrRequest(1), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lines).To(HaveLen(13))
+			Expect(lines[0]).To(Equal(soaLine))
+			Expect(lines[1]).ToNot(HavePrefix("vm.domain.com. 3600 IN SOA"))
+		})
+
+		It("should answer the SOA record to a secondary server that is up to date", func() {
+			lines, err := transferIn(ixfrRequest(5), nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lines).To(Equal([]string{soaLine}))
+		})
+
+		It("should answer the SOA record to an IXFR request over UDP", func() {
+			reply, _, err := new(dns.Client).Exchange(ixfrRequest(3), server.UDPAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(rrLines(reply.Answer)).To(Equal([]string{soaLine}))
+		})
+
+		It("should refuse an AXFR request over UDP", func() {
+			reply, _, err := new(dns.Client).Exchange(new(dns.Msg).SetAxfr("vm.domain.com."), server.UDPAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply.Rcode).To(Equal(dns.RcodeRefused))
+		})
+
+		It("should reject an IXFR request without the SOA record of the secondary server", func() {
+			request := new(dns.Msg).SetQuestion("vm.domain.com.", dns.TypeIXFR)
+			reply, _, err := (&dns.Client{Net: "tcp"}).Exchange(request, server.TCPAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply.Rcode).To(Equal(dns.RcodeFormatError))
+		})
+
+		It("should not transfer a name that is not a zone origin", func() {
+			_, err := transferIn(new(dns.Msg).SetAxfr("ns1.vm.domain.com."), nil)
+			Expect(err).To(MatchError(ContainSubstring("rcode: 9")))
+		})
+	})
+
+	Context("to a client that is not a secondary server", func() {
+		BeforeEach(func() {
+			startServer(dns_server.TransferParams{Secondaries: "10.0.0.2"})
+		})
+
+		It("should refuse the transfer", func() {
+			_, err := transferIn(new(dns.Msg).SetAxfr("vm.domain.com."), nil)
+			Expect(err).To(MatchError(ContainSubstring("rcode: 5")))
+		})
+	})
+
+	Context("with a TSIG key", func() {
+		BeforeEach(func() {
+			startServer(dns_server.TransferParams{TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret})
+		})
+
+		signedAXFR := func(secret string) ([]string, error) {
+			request := new(dns.Msg).SetAxfr("vm.domain.com.")
+			request.SetTsig(tsigKeyName, dns.HmacSHA256, 300, 0)
+			return transferIn(request, map[string]string{tsigKeyName: secret})
+		}
+
+		It("should transfer the zone to a client that signs the request", func() {
+			lines, err := signedAXFR(tsigSecret)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lines).To(HaveLen(13))
+		})
+
+		It("should refuse the transfer to a client that does not sign the request", func() {
+			_, err := transferIn(new(dns.Msg).SetAxfr("vm.domain.com."), nil)
+			Expect(err).To(MatchError(ContainSubstring("rcode: 5")))
+		})
+
+		It("should reject a request signed by another secret", func() {
+			_, err := signedAXFR("b3RoZXItc2VjcmV0")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should sign the answer to a signed query", func() {
+			request := new(dns.Msg).SetQuestion("nic1.vmi1.ns1.vm.domain.com.", dns.TypeA)
+			request.SetTsig(tsigKeyName, dns.HmacSHA256, 300, 0)
+			client := &dns.Client{TsigSecret: map[string]string{tsigKeyName: tsigSecret}}
+			reply, _, err := client.Exchange(request, server.UDPAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(reply.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(reply.IsTsig()).ToNot(BeNil())
+			Expect(reply.Answer).To(HaveLen(1))
+		})
+	})
+
+	Context("notify", func() {
+		var notifications chan *dns.Msg
+		// replies tells the secondary server whether to acknowledge the notifications it received, in order
+		var replies chan bool
+		var secondaryDone chan struct{}
+		var secondary *dns.Server
+
+		soaOf := func(serial uint32) *dns.SOA {
+			soa := zone.SOA()
+			soa.Serial = serial
+			return soa
+		}
+		receiveSerial := func() uint32 {
+			var notification *dns.Msg
+			Eventually(notifications).Should(Receive(&notification))
+			return notification.Answer[0].(*dns.SOA).Serial
+		}
+
+		BeforeEach(func() {
+			notifications = make(chan *dns.Msg, 10)
+			replies = make(chan bool, 10)
+			secondaryDone = make(chan struct{})
+			packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			secondary = &dns.Server{PacketConn: packetConn, TsigSecret: map[string]string{tsigKeyName: tsigSecret},
+				Handler: dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+					if writer.TsigStatus() == nil {
+						notifications <- request
+					}
+					select {
+					case isAcknowledged := <-replies:
+						if isAcknowledged {
+							Expect(writer.WriteMsg(new(dns.Msg).SetReply(request))).To(Succeed())
+						}
+					case <-secondaryDone:
+					}
+				})}
+			started := make(chan struct{})
+			secondary.NotifyStartedFunc = func() { close(started) }
+			go func() {
+				defer GinkgoRecover()
+				Expect(secondary.ActivateAndServe()).To(Succeed())
+			}()
+			Eventually(started).Should(BeClosed())
+			startServer(dns_server.TransferParams{Secondaries: packetConn.LocalAddr().String(), TSIGKeyName: tsigKeyName,
+				TSIGSecret: tsigSecret})
+		})
+
+		AfterEach(func() {
+			close(secondaryDone)
+			Expect(secondary.Shutdown()).To(Succeed())
+		})
+
+		It("should notify the secondary servers of the zone serial", func() {
+			replies <- true
+			server.Notify(zone.SOA())
+			var notification *dns.Msg
+			Eventually(notifications).Should(Receive(&notification))
+			Expect(notification.Opcode).To(Equal(dns.OpcodeNotify))
+			Expect(notification.IsTsig()).ToNot(BeNil())
+			Expect(notification.Question[0].Name).To(Equal("vm.domain.com."))
+			Expect(rrLines(notification.Answer)).To(Equal([]string{soaLine}))
+		})
+
+		It("should notify the latest serial only of the serials notified while a notification is sent", func() {
+			server.Notify(soaOf(10))
+			Expect(receiveSerial()).To(Equal(uint32(10)))
+			server.Notify(soaOf(11))
+			server.Notify(soaOf(12))
+			replies <- true
+			replies <- true
+			Expect(receiveSerial()).To(Equal(uint32(12)))
+			Consistently(notifications, "300ms").ShouldNot(Receive())
+		})
+
+		It("should stop notifying when the server stops", func() {
+			server.Notify(zone.SOA())
+			Eventually(notifications).Should(Receive())
+			// The secondary server does not answer, the server stops without waiting for the answer
+			cancel()
+			Eventually(serveErr, "500ms").Should(Receive(BeNil()))
+			// The AfterEach expects the result of Serve as well
+			serveErr <- nil
+		})
+	})
+})
+
+var _ = Describe("Zone transfer parameters", func() {
+	DescribeTable("validate the parameters", func(params dns_server.TransferParams, isValid bool) {
+		_, err := dns_server.NewTransfer(params)
+		if isValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("secondary servers", dns_server.TransferParams{Secondaries: "10.0.0.2, 10.0.0.3:5353,fd00::2,[fd00::3]:53"}, true),
+		Entry("TSIG key", dns_server.TransferParams{TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret}, true),
+		Entry("secondary server name", dns_server.TransferParams{Secondaries: "ns2.example.com"}, false),
+		Entry("TSIG key name without a secret", dns_server.TransferParams{TSIGKeyName: tsigKeyName}, false),
+	)
+})
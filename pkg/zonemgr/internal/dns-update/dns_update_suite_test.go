@@ -0,0 +1,13 @@
+package dns_update_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestDNSUpdate(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "DNS Update Suite")
+}
@@ -0,0 +1,404 @@
+package dns_update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/tsig"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+const (
+	serverDefaultPort = "53"
+
+	// updateBatchSize bounds the records of an UPDATE message, a message can not exceed 64KB
+	updateBatchSize = 500
+	exchangeTimeout = 10 * time.Second
+
+	retryIntervalMin = time.Second
+	retryIntervalMax = 5 * time.Minute
+)
+
+var log = logf.Log.WithName("dns-update")
+
+// UpdaterParams holds the raw dynamic updates parameters
+type UpdaterParams struct {
+	// Server is the address of the primary server of the zones, <IP>[:<port>]
+	Server string
+	// TSIGKeyName, TSIGSecret and TSIGAlgorithm set the TSIG key that signs the updates, the secret is base64 encoded
+	TSIGKeyName   string
+	TSIGSecret    string
+	TSIGAlgorithm string
+	// ReconcileInterval is the interval between the full reconciliations of the zones against the server
+	ReconcileInterval time.Duration
+}
+
+// Updater pushes the zones to an external primary server with dynamic updates (RFC 2136). The RRsets changes of the
+// zones updates are sent in UPDATE messages signed with TSIG. A zone is reconciled instead, i.e. transferred from the
+// server and diffed against its content, when it is resynced, when it failed to be pushed, and periodically, so
+// changes made to it on the server are reverted. A zone that failed to be pushed is pushed again with an exponential
+// backoff.
+// The zones on the server are expected to be dedicated to the updater, all their records but the SOA record, the
+// NS records of the apex and the addresses of the name servers are managed.
+type Updater struct {
+	server            string
+	tsigKey           *tsig.Key
+	reconcileInterval time.Duration
+
+	// lock guards the zones content and changes, which are updated on the zones flush and pushed by the update loop
+	lock  sync.Mutex
+	zones map[string]*zone
+	// changed is signaled when a zone content is updated
+	changed chan struct{}
+}
+
+// zone is a zone pushed to the server
+type zone struct {
+	origin  string
+	content string
+	// changes are the RRsets changes that are not pushed yet, they are dropped when the zone is to be reconciled
+	changes []sink.RRsetChange
+	// isReconciled is unset when the zone on the server is not known to match the content, so the zone is reconciled
+	// on the next push
+	isReconciled bool
+}
+
+// NewUpdater validates the dynamic updates parameters, the TSIG key is required
+func NewUpdater(params UpdaterParams) (*Updater, error) {
+	server, err := parseServer(params.Server)
+	if err != nil {
+		return nil, err
+	}
+	tsigKey, err := tsig.NewKey(params.TSIGKeyName, params.TSIGSecret, params.TSIGAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	if tsigKey == nil {
+		return nil, errors.New("the dynamic updates require a TSIG key")
+	}
+	if params.ReconcileInterval <= 0 {
+		return nil, fmt.Errorf("invalid reconcile interval %s: must be positive", params.ReconcileInterval)
+	}
+	return &Updater{
+		server:            server,
+		tsigKey:           tsigKey,
+		reconcileInterval: params.ReconcileInterval,
+		zones:             map[string]*zone{},
+		changed:           make(chan struct{}, 1),
+	}, nil
+}
+
+// parseServer returns the server address, with the default DNS port when it is omitted
+func parseServer(server string) (string, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		host, port = strings.Trim(server, "[]"), serverDefaultPort
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid dynamic updates server %q, must be <IP>[:<port>]", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func (updater *Updater) Name() string {
+	return "dns-update"
+}
+
+// Update queues the RRsets changes of the update, which are pushed in the background by Run. A resync update has no
+// changes, the zone is reconciled against its content instead.
+func (updater *Updater) Update(update sink.ZoneUpdate) error {
+	origin := dns.CanonicalName(update.Origin)
+	updater.lock.Lock()
+	zoneToPush, exists := updater.zones[origin]
+	if !exists {
+		zoneToPush = &zone{origin: origin}
+		updater.zones[origin] = zoneToPush
+	}
+	zoneToPush.content = update.Content
+	if update.Resync {
+		zoneToPush.changes = nil
+		zoneToPush.isReconciled = false
+	} else if zoneToPush.isReconciled {
+		zoneToPush.changes = append(zoneToPush.changes, update.Changes...)
+	}
+	updater.lock.Unlock()
+
+	select {
+	case updater.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run pushes the zones to the server as they are updated, until the context is done. The updates set before the
+// context is done are pushed once more on shutdown.
+func (updater *Updater) Run(ctx context.Context) error {
+	reconcileTicker := time.NewTicker(updater.reconcileInterval)
+	defer reconcileTicker.Stop()
+
+	var retryInterval time.Duration
+	for {
+		var retry <-chan time.Time
+		var changed <-chan struct{}
+		if updater.pushZones() {
+			retryInterval = 0
+			changed = updater.changed
+		} else {
+			retryInterval = nextRetryInterval(retryInterval)
+			retry = time.After(retryInterval)
+		}
+
+		select {
+		case <-ctx.Done():
+			updater.pushZones()
+			return nil
+		case <-changed:
+		case <-retry:
+		case <-reconcileTicker.C:
+			updater.unsetReconciled()
+		}
+	}
+}
+
+func nextRetryInterval(retryInterval time.Duration) time.Duration {
+	if retryInterval *= 2; retryInterval < retryIntervalMin {
+		return retryIntervalMin
+	}
+	if retryInterval > retryIntervalMax {
+		return retryIntervalMax
+	}
+	return retryInterval
+}
+
+// unsetReconciled unsets the zones reconciled state, so the zones are reconciled on the next push
+func (updater *Updater) unsetReconciled(zones ...*zone) {
+	updater.lock.Lock()
+	defer updater.lock.Unlock()
+	if len(zones) == 0 {
+		for _, zoneToPush := range updater.zones {
+			zones = append(zones, zoneToPush)
+		}
+	}
+	for _, zoneToPush := range zones {
+		zoneToPush.changes = nil
+		zoneToPush.isReconciled = false
+	}
+}
+
+// pushZones pushes the changes of the zones, and reconciles the zones that are not reconciled. It returns false when
+// a zone failed to be pushed, the zone is then reconciled on the next push.
+func (updater *Updater) pushZones() bool {
+	isPushed := true
+	for _, pending := range updater.pendingZones() {
+		var err error
+		if pending.isReconcile {
+			err = updater.reconcileZone(pending.zone.origin, pending.content)
+		} else {
+			err = updater.pushChanges(pending.zone.origin, pending.changes)
+		}
+		if err != nil {
+			log.Error(err, "failed to update the zone on the server, retrying", "zone", pending.zone.origin,
+				"server", updater.server)
+			updater.unsetReconciled(pending.zone)
+			isPushed = false
+		}
+	}
+	return isPushed
+}
+
+// pendingZone is the snapshot of a zone to push, either its changes or its content to reconcile
+type pendingZone struct {
+	zone        *zone
+	content     string
+	changes     []sink.RRsetChange
+	isReconcile bool
+}
+
+// pendingZones takes the zones to push, the zones are considered pushed until the push fails
+func (updater *Updater) pendingZones() []pendingZone {
+	updater.lock.Lock()
+	defer updater.lock.Unlock()
+	var pendingZones []pendingZone
+	for _, zoneToPush := range updater.zones {
+		if zoneToPush.isReconciled && len(zoneToPush.changes) == 0 {
+			continue
+		}
+		pendingZones = append(pendingZones, pendingZone{zone: zoneToPush, content: zoneToPush.content,
+			changes: zoneToPush.changes, isReconcile: !zoneToPush.isReconciled})
+		zoneToPush.changes = nil
+		zoneToPush.isReconciled = true
+	}
+	sort.Slice(pendingZones, func(i, j int) bool {
+		return pendingZones[i].zone.origin < pendingZones[j].zone.origin
+	})
+	return pendingZones
+}
+
+// pushChanges sends the RRsets changes in UPDATE messages, an RRset change is not split across messages. An RRset is
+// removed by its name and type, so a replaced RRset is removed and then added in the same message.
+func (updater *Updater) pushChanges(origin string, changes []sink.RRsetChange) error {
+	request, recordsCount := new(dns.Msg).SetUpdate(origin), 0
+	for _, change := range changes {
+		changeRecordsCount := len(change.RRset.RRs) + 1
+		if recordsCount > 0 && recordsCount+changeRecordsCount > updateBatchSize {
+			if err := updater.sendUpdate(request); err != nil {
+				return err
+			}
+			request, recordsCount = new(dns.Msg).SetUpdate(origin), 0
+		}
+		if change.Operation != sink.Add {
+			request.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: change.RRset.Name, Rrtype: change.RRset.Type}}})
+		}
+		if change.Operation != sink.Remove {
+			// Insert sets the class of the records it is given
+			for _, rr := range change.RRset.RRs {
+				request.Insert([]dns.RR{dns.Copy(rr)})
+			}
+		}
+		recordsCount += changeRecordsCount
+	}
+	if recordsCount == 0 {
+		return nil
+	}
+	return updater.sendUpdate(request)
+}
+
+// reconcileZone transfers the zone from the server, sends the records of the content that the server misses, and
+// removes the records of the server that the content does not have
+func (updater *Updater) reconcileZone(origin string, content string) error {
+	zoneParser := dns.NewZoneParser(strings.NewReader(content), origin, "")
+	var rrs []dns.RR
+	for rr, ok := zoneParser.Next(); ok; rr, ok = zoneParser.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zoneParser.Err(); err != nil {
+		return fmt.Errorf("failed to parse the zone: %w", err)
+	}
+	records := managedRecords(origin, rrs)
+
+	remoteRRs, err := updater.transferZone(origin)
+	if err != nil {
+		return fmt.Errorf("failed to transfer the zone from the server: %w", err)
+	}
+	changes := diffRecords(managedRecords(origin, remoteRRs), records)
+	for start := 0; start < len(changes); start += updateBatchSize {
+		request := new(dns.Msg).SetUpdate(origin)
+		for _, change := range changes[start:min(start+updateBatchSize, len(changes))] {
+			// Remove and Insert set the class of the records they are given
+			rr := dns.Copy(change.rr)
+			if change.isRemoved {
+				request.Remove([]dns.RR{rr})
+			} else {
+				request.Insert([]dns.RR{rr})
+			}
+		}
+		if err := updater.sendUpdate(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordChange is a record to add to the zone on the server, or to remove from it
+type recordChange struct {
+	key       string
+	rr        dns.RR
+	isRemoved bool
+}
+
+// diffRecords returns the changes from the remote records to the records, the removals first, so a record whose TTL
+// changed is removed and then added with its new TTL
+func diffRecords(remoteRecords map[string]dns.RR, records map[string]dns.RR) []recordChange {
+	var removed, added []recordChange
+	for key, rr := range remoteRecords {
+		if _, exists := records[key]; !exists {
+			removed = append(removed, recordChange{key: key, rr: rr, isRemoved: true})
+		}
+	}
+	for key, rr := range records {
+		if _, exists := remoteRecords[key]; !exists {
+			added = append(added, recordChange{key: key, rr: rr})
+		}
+	}
+	sortRecordChanges(removed)
+	sortRecordChanges(added)
+	return append(removed, added...)
+}
+
+func sortRecordChanges(changes []recordChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key < changes[j].key
+	})
+}
+
+// managedRecords returns the records of the zone by their text, but the SOA record, the NS records of the apex and the
+// addresses of the name servers, which belong to the server
+func managedRecords(origin string, rrs []dns.RR) map[string]dns.RR {
+	nameServers := map[string]bool{}
+	for _, rr := range rrs {
+		if ns, isNS := rr.(*dns.NS); isNS && dns.CanonicalName(ns.Hdr.Name) == origin {
+			nameServers[dns.CanonicalName(ns.Ns)] = true
+		}
+	}
+
+	records := map[string]dns.RR{}
+	for _, rr := range rrs {
+		header := rr.Header()
+		header.Name = dns.CanonicalName(header.Name)
+		switch {
+		case header.Rrtype == dns.TypeSOA:
+			continue
+		case header.Rrtype == dns.TypeNS && header.Name == origin:
+			continue
+		case (header.Rrtype == dns.TypeA || header.Rrtype == dns.TypeAAAA) && nameServers[header.Name]:
+			continue
+		}
+		records[rr.String()] = rr
+	}
+	return records
+}
+
+// transferZone returns the records of the zone on the server
+func (updater *Updater) transferZone(origin string) ([]dns.RR, error) {
+	request := new(dns.Msg).SetAxfr(origin)
+	updater.tsigKey.Sign(request)
+	transfer := &dns.Transfer{TsigSecret: updater.tsigKey.Secrets(), DialTimeout: exchangeTimeout,
+		ReadTimeout: exchangeTimeout, WriteTimeout: exchangeTimeout}
+	envelopes, err := transfer.In(request, updater.server)
+	if err != nil {
+		return nil, err
+	}
+	var rrs []dns.RR
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			return nil, envelope.Error
+		}
+		rrs = append(rrs, envelope.RR...)
+	}
+	return rrs, nil
+}
+
+// sendUpdate signs and sends the UPDATE message, that the server applies atomically. Adding a record that exists and
+// removing a record or an RRset that does not are ignored by the server, so the message can be sent again when its
+// outcome is not known.
+func (updater *Updater) sendUpdate(request *dns.Msg) error {
+	updater.tsigKey.Sign(request)
+	client := &dns.Client{Net: "tcp", TsigSecret: updater.tsigKey.Secrets(), Timeout: exchangeTimeout}
+	reply, _, err := client.Exchange(request, updater.server)
+	if err != nil {
+		return err
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("UPDATE answered with %s", dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
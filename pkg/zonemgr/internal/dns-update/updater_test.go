@@ -0,0 +1,385 @@
+package dns_update_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-update"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+const (
+	origin      = "vm.domain.com."
+	tsigKeyName = "update.key."
+	tsigSecret  = "c2VjcmV0LXRzaWcta2V5LWZvci10ZXN0cw=="
+)
+
+const zoneContent = `$ORIGIN vm.domain.com.
+$TTL 3600
+@ IN SOA ns.vm.domain.com. email.vm.domain.com. (5 3600 3600 1209600 300)
+@ IN NS ns.vm.domain.com.
+ns IN A 10.0.0.1
+nic1.vmi1.ns1 IN A 10.0.0.5
+vmi1.ns1 IN A 10.0.0.5
+`
+
+// remoteZoneContent is the zone on the primary server, its SOA and NS records and the address of its name server
+// belong to the server
+const remoteZoneContent = `$ORIGIN vm.domain.com.
+$TTL 3600
+@ IN SOA primary.vm.domain.com. hostmaster.domain.com. (100 3600 3600 1209600 300)
+@ IN NS primary.vm.domain.com.
+@ IN NS ns1.corp.com.
+primary IN A 10.1.1.1
+stale.ns1 IN A 10.0.0.9
+`
+
+var _ = Describe("Dynamic updates", func() {
+	var primary *primaryStub
+	var updater *dns_update.Updater
+	var cancel context.CancelFunc
+	var runErr chan error
+
+	startUpdater := func(reconcileInterval time.Duration) {
+		var err error
+		updater, err = dns_update.NewUpdater(dns_update.UpdaterParams{Server: primary.address, TSIGKeyName: tsigKeyName,
+			TSIGSecret: tsigSecret, ReconcileInterval: reconcileInterval})
+		Expect(err).ToNot(HaveOccurred())
+		var ctx context.Context
+		ctx, cancel = context.WithCancel(context.Background())
+		runErr = make(chan error, 1)
+		go func() {
+			runErr <- updater.Run(ctx)
+		}()
+	}
+
+	BeforeEach(func() {
+		primary = newPrimaryStub(remoteZoneContent)
+		cancel = nil
+	})
+
+	AfterEach(func() {
+		if cancel != nil {
+			cancel()
+			Eventually(runErr).Should(Receive(BeNil()))
+		}
+		primary.shutdown()
+	})
+
+	remoteRecordsWith := func(lines ...string) []string {
+		records := append(strings.Split(strings.TrimSpace(`
+vm.domain.com. 3600 IN SOA primary.vm.domain.com. hostmaster.domain.com. 100 3600 3600 1209600 300
+vm.domain.com. 3600 IN NS primary.vm.domain.com.
+vm.domain.com. 3600 IN NS ns1.corp.com.
+primary.vm.domain.com. 3600 IN A 10.1.1.1`), "\n"), lines...)
+		sort.Strings(records)
+		return records
+	}
+
+	It("should push the records of the zone and remove the stale records of the server", func() {
+		startUpdater(time.Hour)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.records).Should(Equal(remoteRecordsWith(
+			"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5",
+			"vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5",
+		)))
+		Expect(primary.transfersCount()).To(Equal(1))
+	})
+
+	It("should push only the changed records", func() {
+		startUpdater(time.Hour)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.updatesCount).Should(Equal(1))
+
+		content := strings.Replace(zoneContent, "\nvmi1.ns1 IN A 10.0.0.5", "\nvmi1.ns1 60 IN A 10.0.0.5", 1)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Content: content, Changes: []sink.RRsetChange{
+			{Operation: sink.Replace, RRset: newRRset("vmi1.ns1 60 IN A 10.0.0.5")},
+		}})).To(Succeed())
+		Eventually(primary.records).Should(Equal(remoteRecordsWith(
+			"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5",
+			"vmi1.ns1.vm.domain.com. 60 IN A 10.0.0.5",
+		)))
+		Expect(primary.updatesCount()).To(Equal(2))
+		Expect(primary.lastUpdate()).To(Equal([]string{
+			"vmi1.ns1.vm.domain.com. 0 CLASS255 A",
+			"vmi1.ns1.vm.domain.com. 60 IN A 10.0.0.5",
+		}))
+		Expect(primary.transfersCount()).To(Equal(1))
+	})
+
+	It("should push the added and removed RRsets", func() {
+		startUpdater(time.Hour)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.updatesCount).Should(Equal(1))
+
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Changes: []sink.RRsetChange{
+			{Operation: sink.Remove, RRset: newRRset("nic1.vmi1.ns1 IN A 10.0.0.5")},
+			{Operation: sink.Add, RRset: newRRset("vmi1.ns1 IN AAAA fd00::5", "vmi1.ns1 IN AAAA fd00::6")},
+		}})).To(Succeed())
+		Eventually(primary.records).Should(Equal(remoteRecordsWith(
+			"vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5",
+			"vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5",
+			"vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::6",
+		)))
+		Expect(primary.updatesCount()).To(Equal(2))
+		Expect(primary.transfersCount()).To(Equal(1))
+	})
+
+	It("should reconcile the zone when the changes failed to be pushed", func() {
+		startUpdater(time.Hour)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.updatesCount).Should(Equal(1))
+
+		primary.setFailedUpdates(1)
+		content := strings.Replace(zoneContent, "\nvmi1.ns1 IN A 10.0.0.5", "\nvmi1.ns1 60 IN A 10.0.0.5", 1)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Content: content, Changes: []sink.RRsetChange{
+			{Operation: sink.Replace, RRset: newRRset("vmi1.ns1 60 IN A 10.0.0.5")},
+		}})).To(Succeed())
+		Eventually(primary.records, 5*time.Second).Should(ContainElement("vmi1.ns1.vm.domain.com. 60 IN A 10.0.0.5"))
+		Expect(primary.transfersCount()).To(Equal(2))
+	})
+
+	It("should retry a failed update", func() {
+		primary.setFailedUpdates(1)
+		startUpdater(time.Hour)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.records, 5*time.Second).Should(ContainElement("vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5"))
+		Expect(primary.updatesCount()).To(Equal(2))
+	})
+
+	It("should reconcile the zone periodically", func() {
+		startUpdater(200 * time.Millisecond)
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		Eventually(primary.updatesCount).Should(Equal(1))
+
+		primary.addRecord("rogue.ns1 IN A 10.0.0.66")
+		Eventually(primary.records).ShouldNot(ContainElement("rogue.ns1.vm.domain.com. 3600 IN A 10.0.0.66"))
+		Expect(primary.transfersCount()).To(BeNumerically(">", 1))
+	})
+
+	It("should push the pending updates on shutdown", func() {
+		var err error
+		updater, err = dns_update.NewUpdater(dns_update.UpdaterParams{Server: primary.address, TSIGKeyName: tsigKeyName,
+			TSIGSecret: tsigSecret, ReconcileInterval: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		ctx, cancelRun := context.WithCancel(context.Background())
+		cancelRun()
+		Expect(updater.Run(ctx)).To(Succeed())
+		Expect(primary.records()).To(ContainElement("vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5"))
+	})
+
+	It("should not update the zone with another TSIG secret", func() {
+		var err error
+		updater, err = dns_update.NewUpdater(dns_update.UpdaterParams{Server: primary.address, TSIGKeyName: tsigKeyName,
+			TSIGSecret: "b3RoZXItc2VjcmV0", ReconcileInterval: time.Hour})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updater.Update(sink.ZoneUpdate{Origin: origin, Resync: true, Content: zoneContent})).To(Succeed())
+		ctx, cancelRun := context.WithCancel(context.Background())
+		cancelRun()
+		Expect(updater.Run(ctx)).To(Succeed())
+		Expect(primary.records()).To(Equal(remoteRecordsWith("stale.ns1.vm.domain.com. 3600 IN A 10.0.0.9")))
+	})
+})
+
+var _ = Describe("Dynamic updates parameters", func() {
+	DescribeTable("validate the parameters", func(params dns_update.UpdaterParams, isValid bool) {
+		_, err := dns_update.NewUpdater(params)
+		if isValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("server and TSIG key", dns_update.UpdaterParams{Server: "10.0.0.2", TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret,
+			ReconcileInterval: time.Minute}, true),
+		Entry("server with a port", dns_update.UpdaterParams{Server: "[fd00::2]:5353", TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret,
+			ReconcileInterval: time.Minute}, true),
+		Entry("server name", dns_update.UpdaterParams{Server: "ns1.corp.com", TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret,
+			ReconcileInterval: time.Minute}, false),
+		Entry("missing TSIG key", dns_update.UpdaterParams{Server: "10.0.0.2", ReconcileInterval: time.Minute}, false),
+		Entry("invalid TSIG key", dns_update.UpdaterParams{Server: "10.0.0.2", TSIGKeyName: tsigKeyName, ReconcileInterval: time.Minute}, false),
+		Entry("zero reconcile interval", dns_update.UpdaterParams{Server: "10.0.0.2", TSIGKeyName: tsigKeyName, TSIGSecret: tsigSecret}, false),
+	)
+})
+
+// primaryStub is a primary server that serves a zone, it applies the dynamic updates and transfers the zone to the
+// requests that are signed by the TSIG key
+type primaryStub struct {
+	address string
+	server  *dns.Server
+
+	lock          sync.Mutex
+	rrs           []dns.RR
+	updates       [][]string
+	transfers     int
+	failedUpdates int
+}
+
+func newPrimaryStub(content string) *primaryStub {
+	primary := &primaryStub{}
+	zoneParser := dns.NewZoneParser(strings.NewReader(content), "", "")
+	for rr, ok := zoneParser.Next(); ok; rr, ok = zoneParser.Next() {
+		primary.rrs = append(primary.rrs, rr)
+	}
+	Expect(zoneParser.Err()).ToNot(HaveOccurred())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).ToNot(HaveOccurred())
+	primary.address = listener.Addr().String()
+	started := make(chan struct{})
+	primary.server = &dns.Server{Listener: listener, TsigSecret: map[string]string{tsigKeyName: tsigSecret},
+		Handler: primary, NotifyStartedFunc: func() { close(started) },
+		// The default accept function rejects the UPDATE messages
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }}
+	go func() {
+		defer GinkgoRecover()
+		Expect(primary.server.ActivateAndServe()).To(Succeed())
+	}()
+	Eventually(started).Should(BeClosed())
+	return primary
+}
+
+func (primary *primaryStub) shutdown() {
+	Expect(primary.server.Shutdown()).To(Succeed())
+}
+
+func (primary *primaryStub) ServeDNS(writer dns.ResponseWriter, request *dns.Msg) {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+
+	reply := new(dns.Msg).SetReply(request)
+	switch {
+	case request.IsTsig() == nil || writer.TsigStatus() != nil:
+		reply.Rcode = dns.RcodeNotAuth
+	case request.Opcode == dns.OpcodeUpdate:
+		reply.Rcode = primary.update(request.Ns)
+	case len(request.Question) == 1 && request.Question[0].Qtype == dns.TypeAXFR:
+		primary.transfers++
+		envelopes := make(chan *dns.Envelope, 1)
+		// The zone is bounded by its SOA record, which is its first record
+		envelopes <- &dns.Envelope{RR: append(append([]dns.RR{}, primary.rrs...), primary.rrs[0])}
+		close(envelopes)
+		Expect(new(dns.Transfer).Out(writer, request, envelopes)).To(Succeed())
+		return
+	default:
+		reply.Rcode = dns.RcodeRefused
+	}
+	if reply.Rcode != dns.RcodeNotAuth {
+		reply.SetTsig(tsigKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+	}
+	Expect(writer.WriteMsg(reply)).To(Succeed())
+}
+
+// update applies the records to add, the records to remove and the RRsets to remove of an UPDATE message
+func (primary *primaryStub) update(rrs []dns.RR) int {
+	primary.updates = append(primary.updates, rrLines(rrs))
+	if primary.failedUpdates > 0 {
+		primary.failedUpdates--
+		return dns.RcodeServerFailure
+	}
+	for _, rr := range rrs {
+		if rr.Header().Class == dns.ClassANY {
+			primary.removeRRset(rr.Header().Name, rr.Header().Rrtype)
+			continue
+		}
+		isRemoved := rr.Header().Class == dns.ClassNONE
+		rr = dns.Copy(rr)
+		rr.Header().Class = dns.ClassINET
+		exists := false
+		for i, existing := range primary.rrs {
+			if dns.IsDuplicate(existing, rr) {
+				exists = true
+				if isRemoved {
+					primary.rrs = append(primary.rrs[:i], primary.rrs[i+1:]...)
+				}
+				break
+			}
+		}
+		if !isRemoved && !exists {
+			primary.rrs = append(primary.rrs, rr)
+		}
+	}
+	return dns.RcodeSuccess
+}
+
+func (primary *primaryStub) removeRRset(name string, rrType uint16) {
+	var rrs []dns.RR
+	for _, rr := range primary.rrs {
+		if !strings.EqualFold(rr.Header().Name, name) || rr.Header().Rrtype != rrType {
+			rrs = append(rrs, rr)
+		}
+	}
+	primary.rrs = rrs
+}
+
+func (primary *primaryStub) addRecord(line string) {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	rr, err := dns.NewRR("$ORIGIN " + origin + "\n" + line)
+	Expect(err).ToNot(HaveOccurred())
+	primary.rrs = append(primary.rrs, rr)
+}
+
+func (primary *primaryStub) setFailedUpdates(count int) {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	primary.failedUpdates = count
+}
+
+// records returns the records of the zone, sorted
+func (primary *primaryStub) records() []string {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	records := rrLines(primary.rrs)
+	sort.Strings(records)
+	return records
+}
+
+func (primary *primaryStub) updatesCount() int {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	return len(primary.updates)
+}
+
+func (primary *primaryStub) lastUpdate() []string {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	return primary.updates[len(primary.updates)-1]
+}
+
+func (primary *primaryStub) transfersCount() int {
+	primary.lock.Lock()
+	defer primary.lock.Unlock()
+	return primary.transfers
+}
+
+// newRRset returns the RRset of the records, which share their name and type
+func newRRset(lines ...string) sink.RRset {
+	var rrset sink.RRset
+	for _, line := range lines {
+		rr, err := dns.NewRR("$ORIGIN " + origin + "\n$TTL 3600\n" + line)
+		Expect(err).ToNot(HaveOccurred())
+		rrset.Name, rrset.Type = dns.CanonicalName(rr.Header().Name), rr.Header().Rrtype
+		rrset.RRs = append(rrset.RRs, rr)
+	}
+	return rrset
+}
+
+// rrLines returns the records in the zone file format, with single spaces
+func rrLines(rrs []dns.RR) []string {
+	var lines []string
+	for _, rr := range rrs {
+		lines = append(lines, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	return lines
+}
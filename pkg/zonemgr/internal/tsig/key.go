@@ -0,0 +1,72 @@
+package tsig
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	algorithmDefault = "hmac-sha256"
+	fudge            = 300
+)
+
+var algorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// Key is a TSIG key (RFC 8945), that authenticates the DNS messages exchanged with another server
+type Key struct {
+	Name      string
+	Algorithm string
+	// Secret is base64 encoded
+	Secret string
+}
+
+// NewKey validates the TSIG key parameters, an empty algorithm defaults to hmac-sha256. It returns nil when neither the
+// key name nor the secret are set.
+func NewKey(name string, secret string, algorithm string) (*Key, error) {
+	if name == "" && secret == "" {
+		return nil, nil
+	}
+	if name == "" || secret == "" {
+		return nil, errors.New("the TSIG key name and secret must be set together")
+	}
+	if _, isValid := dns.IsDomainName(name); !isValid {
+		return nil, fmt.Errorf("invalid TSIG key name %q", name)
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return nil, fmt.Errorf("invalid TSIG secret, must be base64 encoded: %w", err)
+	}
+	algorithmName := strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	if algorithmName == "" {
+		algorithmName = algorithmDefault
+	}
+	dnsAlgorithm, isSupported := algorithms[algorithmName]
+	if !isSupported {
+		return nil, fmt.Errorf("invalid TSIG algorithm %q, supported algorithms are hmac-sha1, hmac-sha224, hmac-sha256, "+
+			"hmac-sha384 and hmac-sha512", algorithm)
+	}
+	return &Key{Name: dns.CanonicalName(name), Algorithm: dnsAlgorithm, Secret: secret}, nil
+}
+
+// Secrets returns the secret by key name, as the miekg/dns servers and clients expect it, or nil when there is no key
+func (key *Key) Secrets() map[string]string {
+	if key == nil {
+		return nil
+	}
+	return map[string]string{key.Name: key.Secret}
+}
+
+// Sign adds the TSIG record of the key to the message, the message is signed once it is written
+func (key *Key) Sign(msg *dns.Msg) {
+	msg.SetTsig(key.Name, key.Algorithm, fudge, time.Now().Unix())
+}
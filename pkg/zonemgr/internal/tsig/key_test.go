@@ -0,0 +1,56 @@
+package tsig_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/tsig"
+)
+
+const (
+	keyName = "transfer.key."
+	secret  = "c2VjcmV0LXRzaWcta2V5LWZvci10ZXN0cw=="
+)
+
+var _ = Describe("TSIG key", func() {
+	DescribeTable("create the key", func(name string, secret string, algorithm string, expectedKey *tsig.Key) {
+		key, err := tsig.NewKey(name, secret, algorithm)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key).To(Equal(expectedKey))
+	},
+		Entry("no key", "", "", "", nil),
+		Entry("default algorithm", keyName, secret, "", &tsig.Key{Name: keyName, Algorithm: dns.HmacSHA256, Secret: secret}),
+		Entry("relative name in another case", "Transfer.Key", secret, "HMAC-SHA512.",
+			&tsig.Key{Name: keyName, Algorithm: dns.HmacSHA512, Secret: secret}),
+	)
+
+	DescribeTable("reject invalid parameters", func(name string, secret string, algorithm string) {
+		_, err := tsig.NewKey(name, secret, algorithm)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("name without a secret", keyName, "", ""),
+		Entry("secret without a name", "", secret, ""),
+		Entry("invalid name", "transfer..key", secret, ""),
+		Entry("secret not base64 encoded", keyName, "secret!", ""),
+		Entry("unsupported algorithm", keyName, secret, "hmac-md5"),
+	)
+
+	It("should return the secrets by key name", func() {
+		key, err := tsig.NewKey(keyName, secret, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(key.Secrets()).To(Equal(map[string]string{keyName: secret}))
+		Expect((*tsig.Key)(nil).Secrets()).To(BeNil())
+	})
+
+	It("should sign a message", func() {
+		key, err := tsig.NewKey(keyName, secret, "")
+		Expect(err).ToNot(HaveOccurred())
+		msg := new(dns.Msg).SetQuestion("vm.domain.com.", dns.TypeSOA)
+		key.Sign(msg)
+		Expect(msg.IsTsig()).ToNot(BeNil())
+		Expect(msg.IsTsig().Hdr.Name).To(Equal(keyName))
+		Expect(msg.IsTsig().Algorithm).To(Equal(dns.HmacSHA256))
+	})
+})
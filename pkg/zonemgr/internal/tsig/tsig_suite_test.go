@@ -0,0 +1,13 @@
+package tsig_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestTSIG(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "TSIG Suite")
+}
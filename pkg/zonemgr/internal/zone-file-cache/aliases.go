@@ -0,0 +1,83 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// AliasesAnnotation is the VMI annotation that declares alias names of the VMI, as a comma separated list of
+// <alias>[=<interface>] entries. Each alias is published as CNAME to the interface name, or to the VMI default name when
+// the interface is omitted. The alias name is rendered by the default name template with the alias as the VMI name and
+// hostname, e.g. <alias>.<namespace> with the default template.
+const AliasesAnnotation = "secondarydns.kubevirt.io/aliases"
+
+const recordTypeCNAME = "CNAME"
+
+const (
+	aliasesSeparator     = ","
+	aliasTargetSeparator = "="
+)
+
+// generateAliasNames returns the VMI alias names mapped to their target names, relative to the zone domain. The data is
+// the name templates data of the VMI.
+func (naming *Naming) generateAliasNames(vmi *v1.VirtualMachineInstance, data nameTemplateData, names vmiNames) (map[string]string, error) {
+	annotation, exists := vmi.Annotations[AliasesAnnotation]
+	if !exists {
+		return nil, nil
+	}
+
+	aliasNames := map[string]string{}
+	for _, entry := range strings.Split(annotation, aliasesSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alias, interfaceName, hasInterface := strings.Cut(entry, aliasTargetSeparator)
+		alias = strings.TrimSpace(alias)
+		if errs := validation.IsDNS1123Label(alias); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid alias %q: %s", alias, strings.Join(errs, ", "))
+		}
+
+		target := names.defaultName
+		if hasInterface {
+			interfaceName = strings.TrimSpace(interfaceName)
+			interfaceTarget, isPublished := names.interfaceNames[interfaceName]
+			if !isPublished {
+				return nil, fmt.Errorf("invalid alias %q: the interface %q is not reported by the VMI", alias, interfaceName)
+			}
+			target = interfaceTarget
+		}
+
+		aliasData := data
+		aliasData.Name, aliasData.Hostname = alias, alias
+		aliasName, err := renderName(naming.defaultTemplate, aliasData, naming.domain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid alias %q: %w", alias, err)
+		}
+		if aliasName == names.defaultName && alias != data.Name && alias != data.Hostname {
+			return nil, fmt.Errorf("invalid alias %q: the default name template does not render a distinct name for the alias, "+
+				"it does not refer to the VMI name", alias)
+		}
+		if _, isDuplicate := aliasNames[aliasName]; isDuplicate {
+			return nil, fmt.Errorf("the alias %q is declared more than once", alias)
+		}
+		if names.isRecordName(aliasName) {
+			return nil, fmt.Errorf("the alias %q is also the name of a VMI record", alias)
+		}
+		aliasNames[aliasName] = target
+	}
+	return aliasNames, nil
+}
+
+// generateAliasRecords returns the CNAME records of the aliases
+func generateAliasRecords(aliasNames map[string]string) []Record {
+	var recordsArr []Record
+	for aliasName, target := range aliasNames {
+		recordsArr = append(recordsArr, newRecord(aliasName, recordTypeCNAME, target))
+	}
+	return recordsArr
+}
@@ -0,0 +1,131 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI aliases", func() {
+	const domain = "vm.domain.com"
+
+	var (
+		vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+		vmi2 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi2"}
+	)
+
+	newAliasesVMI := func(aliases string) *v1.VirtualMachineInstance {
+		vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{
+			{IPs: []string{"10.0.0.1"}, Name: "nic1"},
+			{IPs: []string{"10.0.0.2"}, Name: "nic2"},
+		})
+		vmi.Annotations = map[string]string{AliasesAnnotation: aliases}
+		return vmi
+	}
+
+	DescribeTable("generate alias names", func(aliases string, expectedAliasNames map[string]string) {
+		names, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, newAliasesVMI(aliases))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names.aliasNames).To(Equal(expectedAliasNames))
+	},
+		Entry("alias without interface targets the default name", "db", map[string]string{"db.ns1": "vmi1.ns1"}),
+		Entry("alias with interface targets the interface name", "db=nic2", map[string]string{"db.ns1": "nic2.vmi1.ns1"}),
+		Entry("multiple aliases", "db=nic1, db-primary = nic2",
+			map[string]string{"db.ns1": "nic1.vmi1.ns1", "db-primary.ns1": "nic2.vmi1.ns1"}),
+		Entry("empty entries are ignored", "db,,", map[string]string{"db.ns1": "vmi1.ns1"}),
+	)
+
+	DescribeTable("render the alias names by the default name template", func(namingMode NamingMode, defaultTemplate string,
+		expectedAliasNames map[string]string) {
+		naming, err := NewNaming(namingMode, "", defaultTemplate, "", domain)
+		Expect(err).ToNot(HaveOccurred())
+		vmi := newAliasesVMI("db")
+		vmi.Spec.Hostname = "host1"
+		vmi.Spec.Subdomain = "sub1"
+		vmi.Labels = map[string]string{"team": "team-a"}
+		names, err := naming.generateNames(vmi1, vmi)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names.aliasNames).To(Equal(expectedAliasNames))
+	},
+		Entry("hostname mode keeps the subdomain", NamingModeHostname, "", map[string]string{"db.sub1.ns1": "host1.sub1.ns1"}),
+		Entry("custom template", NamingModeName, "{{.Name}}-vm.{{.Label \"team\"}}", map[string]string{"db-vm.team-a": "vmi1-vm.team-a"}),
+	)
+
+	It("should reject the aliases when the default name template does not refer to the VMI name", func() {
+		naming, err := NewNaming(NamingModeName, "{{.Interface}}.{{.Namespace}}", "{{.Namespace}}", "", domain)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = naming.generateNames(vmi1, newAliasesVMI("db"))
+		Expect(err).To(MatchError(ContainSubstring("does not refer to the VMI name")))
+	})
+
+	DescribeTable("reject invalid aliases", func(aliases string) {
+		_, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, newAliasesVMI(aliases))
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("alias with dots", "db.team-a"),
+		Entry("alias with upper case characters", "DB"),
+		Entry("alias starting with a hyphen", "-db"),
+		Entry("alias without a name", "=nic1"),
+		Entry("alias of an interface that is not reported", "db=nic3"),
+		Entry("alias declared more than once", "db=nic1,db=nic2"),
+		Entry("alias that is the VMI default name", "vmi1"),
+	)
+
+	It("should publish the aliases as CNAME records", func() {
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newAliasesVMI("db=nic2,web")).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal(
+			"db.ns1 IN CNAME nic2.vmi1.ns1\n" +
+				"vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic2.vmi1.ns1 IN A 10.0.0.2\n" +
+				"web.ns1 IN CNAME vmi1.ns1\n"))
+	})
+
+	It("should publish a VMI without the alias claimed by another VMI until it is released", func() {
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newAliasesVMI("db")).IsChanged()).To(BeTrue())
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi2, newAliasesVMI("db,web")).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal(
+			"db.ns1 IN CNAME vmi1.ns1\n" +
+				"vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic2.vmi1.ns1 IN A 10.0.0.2\n" +
+				"vmi2.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi2.ns1 IN A 10.0.0.1\n" +
+				"nic2.vmi2.ns1 IN A 10.0.0.2\n" +
+				"web.ns1 IN CNAME vmi2.ns1\n"))
+		_, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+		Expect(isConflicted).To(BeFalse())
+		Expect(zoneFileCache.GetAliasConflicts(vmi2)).To(Equal(map[string]k8stypes.NamespacedName{"db.ns1": vmi1}))
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newAliasesVMI("")).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.UpdateVMIRecords(vmi2, newAliasesVMI("db,web")).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.GetAliasConflicts(vmi2)).To(BeEmpty())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(ContainSubstring("db.ns1 IN CNAME vmi2.ns1\n"))
+	})
+
+	It("should not publish a VMI whose record name is the alias of another VMI", func() {
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newAliasesVMI("vmi2")).IsChanged()).To(BeTrue())
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi2, newAliasesVMI("")).IsChanged()).To(BeFalse())
+		owner, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+		Expect(isConflicted).To(BeTrue())
+		Expect(owner).To(Equal(vmi1))
+	})
+
+	It("should scope the aliases to the VMI namespace", func() {
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newAliasesVMI("db")).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: "ns2", Name: "vmi1"}, newAliasesVMI("db")).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(ContainSubstring("db.ns2 IN CNAME vmi1.ns2\n"))
+	})
+})
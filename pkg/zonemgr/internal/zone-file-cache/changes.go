@@ -0,0 +1,130 @@
+package zone_file_cache
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+// ChangeSet is the change of the records of a VMI by an update, the records of each list are ordered canonically
+type ChangeSet struct {
+	Added     []Record
+	Removed   []Record
+	Unchanged []Record
+}
+
+// IsChanged returns whether the update added or removed records
+func (changeSet ChangeSet) IsChanged() bool {
+	return len(changeSet.Added) > 0 || len(changeSet.Removed) > 0
+}
+
+// diffRecords returns the change from the old records to the new records, both are expected to be ordered canonically
+func diffRecords(oldRecords, newRecords []Record) ChangeSet {
+	var changeSet ChangeSet
+	i, j := 0, 0
+	for i < len(oldRecords) && j < len(newRecords) {
+		switch {
+		case oldRecords[i] == newRecords[j]:
+			changeSet.Unchanged = append(changeSet.Unchanged, newRecords[j])
+			i++
+			j++
+		case isRecordLess(oldRecords[i], newRecords[j]):
+			changeSet.Removed = append(changeSet.Removed, oldRecords[i])
+			i++
+		default:
+			changeSet.Added = append(changeSet.Added, newRecords[j])
+			j++
+		}
+	}
+	changeSet.Removed = append(changeSet.Removed, oldRecords[i:]...)
+	changeSet.Added = append(changeSet.Added, newRecords[j:]...)
+	return changeSet
+}
+
+// rrsetChange is the change of the records of a name and type on a flush, the records are the records after the
+// change, or the removed records when the change removes the name and type records
+type rrsetChange struct {
+	operation sink.Operation
+	records   []Record
+}
+
+type rrsetKey struct {
+	name     string
+	typeCode uint16
+}
+
+// rrsetChanges groups the records that were added and removed by a merge into the changes of their RRsets, in
+// canonical order. It is called right after the merge, so the records of a name are the records after the changes.
+func (store *recordStore) rrsetChanges(addedRecords, removedRecords []Record) []rrsetChange {
+	if len(addedRecords) == 0 && len(removedRecords) == 0 {
+		return nil
+	}
+
+	// countsDelta holds the number of added records less the number of removed records of each RRset
+	countsDelta := map[rrsetKey]int{}
+	canonicalNames := map[rrsetKey]string{}
+	removedByKey := map[rrsetKey][]Record{}
+	for _, record := range addedRecords {
+		key := rrsetKey{name: strings.ToLower(record.name), typeCode: record.typeCode}
+		countsDelta[key]++
+		canonicalNames[key] = record.canonicalName
+	}
+	for _, record := range removedRecords {
+		key := rrsetKey{name: strings.ToLower(record.name), typeCode: record.typeCode}
+		countsDelta[key]--
+		canonicalNames[key] = record.canonicalName
+		removedByKey[key] = append(removedByKey[key], record)
+	}
+
+	keys := make([]rrsetKey, 0, len(countsDelta))
+	for key := range countsDelta {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if canonicalNames[keys[i]] != canonicalNames[keys[j]] {
+			return canonicalNames[keys[i]] < canonicalNames[keys[j]]
+		}
+		return keys[i].typeCode < keys[j].typeCode
+	})
+
+	changes := make([]rrsetChange, 0, len(keys))
+	for _, key := range keys {
+		nameRecords, _ := store.lookup(key.name)
+		var records []Record
+		for _, record := range nameRecords {
+			if record.typeCode == key.typeCode {
+				records = append(records, record)
+			}
+		}
+		switch {
+		case len(records) == 0:
+			changes = append(changes, rrsetChange{operation: sink.Remove, records: removedByKey[key]})
+		case len(records) == countsDelta[key]:
+			changes = append(changes, rrsetChange{operation: sink.Add, records: records})
+		default:
+			changes = append(changes, rrsetChange{operation: sink.Replace, records: records})
+		}
+	}
+	return changes
+}
+
+// Changes returns the changes of the zone RRsets on the last flush
+func (zoneFileCache *ZoneFileCache) Changes() ([]sink.RRsetChange, error) {
+	changes := make([]sink.RRsetChange, 0, len(zoneFileCache.changes))
+	for _, change := range zoneFileCache.changes {
+		rrset := sink.RRset{Type: change.records[0].typeCode}
+		for _, record := range change.records {
+			rr, err := record.toRR(zoneFileCache.Origin(), zoneFileCache.soa.ttl)
+			if err != nil {
+				return nil, err
+			}
+			rrset.RRs = append(rrset.RRs, rr)
+		}
+		rrset.Name = dns.CanonicalName(rrset.RRs[0].Header().Name)
+		changes = append(changes, sink.RRsetChange{Operation: change.operation, RRset: rrset})
+	}
+	return changes, nil
+}
@@ -0,0 +1,162 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+var _ = Describe("VMI change sets", func() {
+	const domain = "vm.domain.com"
+
+	var vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+	var zoneFileCache *ZoneFileCache
+
+	updateVMI := func(ips ...string) ChangeSet {
+		var vmi *v1.VirtualMachineInstance
+		if len(ips) > 0 {
+			vmi = newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: ips, Name: "nic1"}})
+		}
+		return zoneFileCache.UpdateVMIRecords(vmi1, vmi)
+	}
+
+	recordLines := func(records []Record) []string {
+		var lines []string
+		for _, record := range records {
+			lines = append(lines, record.String())
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		soa, err := NewSOA(SOAParams{})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache = NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+	})
+
+	It("should return the records of a new VMI as added", func() {
+		changeSet := updateVMI("10.0.0.1")
+		Expect(changeSet.IsChanged()).To(BeTrue())
+		Expect(recordLines(changeSet.Added)).To(Equal([]string{"vmi1.ns1 IN A 10.0.0.1\n", "nic1.vmi1.ns1 IN A 10.0.0.1\n"}))
+		Expect(changeSet.Removed).To(BeEmpty())
+		Expect(changeSet.Unchanged).To(BeEmpty())
+	})
+
+	It("should return the added, the removed and the unchanged records of an update", func() {
+		updateVMI("10.0.0.1", "10.0.0.2")
+		changeSet := updateVMI("10.0.0.1", "10.0.0.3")
+		Expect(recordLines(changeSet.Added)).To(Equal([]string{"vmi1.ns1 IN A 10.0.0.3\n", "nic1.vmi1.ns1 IN A 10.0.0.3\n"}))
+		Expect(recordLines(changeSet.Removed)).To(Equal([]string{"vmi1.ns1 IN A 10.0.0.2\n", "nic1.vmi1.ns1 IN A 10.0.0.2\n"}))
+		Expect(recordLines(changeSet.Unchanged)).To(Equal([]string{"vmi1.ns1 IN A 10.0.0.1\n", "nic1.vmi1.ns1 IN A 10.0.0.1\n"}))
+
+		records, _ := zoneFileCache.records.lookup("nic1.vmi1.ns1")
+		Expect(recordLines(records)).To(Equal([]string{"nic1.vmi1.ns1 IN A 10.0.0.1\n", "nic1.vmi1.ns1 IN A 10.0.0.3\n"}))
+	})
+
+	It("should return the records of an update that changed nothing as unchanged", func() {
+		updateVMI("10.0.0.1")
+		changeSet := updateVMI("10.0.0.1")
+		Expect(changeSet.IsChanged()).To(BeFalse())
+		Expect(changeSet.Unchanged).To(HaveLen(2))
+	})
+
+	It("should return the records of a removed VMI as removed", func() {
+		updateVMI("10.0.0.1")
+		changeSet := updateVMI()
+		Expect(recordLines(changeSet.Removed)).To(Equal([]string{"vmi1.ns1 IN A 10.0.0.1\n", "nic1.vmi1.ns1 IN A 10.0.0.1\n"}))
+		Expect(changeSet.Added).To(BeEmpty())
+	})
+
+	It("should expose the record fields", func() {
+		record := updateVMI("10.0.0.1").Added[1]
+		Expect(record.Name()).To(Equal("nic1.vmi1.ns1"))
+		Expect(record.Type()).To(Equal("A"))
+		Expect(record.Data()).To(Equal("10.0.0.1"))
+		_, hasTTL := record.TTL()
+		Expect(hasTTL).To(BeFalse())
+
+		ttl, hasTTL := record.withTTL(60).TTL()
+		Expect(hasTTL).To(BeTrue())
+		Expect(ttl).To(Equal(uint32(60)))
+	})
+})
+
+var _ = Describe("Zone changes", func() {
+	const domain = "vm.domain.com"
+
+	var vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+	var zoneFileCache *ZoneFileCache
+
+	updateVMI := func(ips ...string) {
+		var vmi *v1.VirtualMachineInstance
+		if len(ips) > 0 {
+			vmi = newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: ips, Name: "nic1"}})
+		}
+		zoneFileCache.UpdateVMIRecords(vmi1, vmi)
+		zoneFileCache.Flush()
+	}
+
+	// changeLines returns the operation, the owner name and the records of each change
+	changeLines := func() [][]string {
+		changes, err := zoneFileCache.Changes()
+		Expect(err).ToNot(HaveOccurred())
+		var lines [][]string
+		for _, change := range changes {
+			changeLine := []string{string(change.Operation), change.RRset.Name}
+			for _, rr := range change.RRset.RRs {
+				changeLine = append(changeLine, rr.String())
+			}
+			lines = append(lines, changeLine)
+		}
+		return lines
+	}
+
+	BeforeEach(func() {
+		soa, err := NewSOA(SOAParams{})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache = NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		updateVMI("10.0.0.1")
+	})
+
+	It("should add the RRsets of a new VMI", func() {
+		Expect(changeLines()).To(Equal([][]string{
+			{string(sink.Add), "vmi1.ns1.vm.domain.com.", "vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1"},
+			{string(sink.Add), "nic1.vmi1.ns1.vm.domain.com.", "nic1.vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1"},
+		}))
+	})
+
+	It("should replace the RRsets whose records changed", func() {
+		updateVMI("10.0.0.1", "10.0.0.2")
+		Expect(changeLines()).To(Equal([][]string{
+			{string(sink.Replace), "vmi1.ns1.vm.domain.com.", "vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1",
+				"vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.2"},
+			{string(sink.Replace), "nic1.vmi1.ns1.vm.domain.com.", "nic1.vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1",
+				"nic1.vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.2"},
+		}))
+	})
+
+	It("should add the RRsets of a new record type of a name", func() {
+		updateVMI("10.0.0.1", "fd00::1")
+		Expect(changeLines()).To(Equal([][]string{
+			{string(sink.Add), "vmi1.ns1.vm.domain.com.", "vmi1.ns1.vm.domain.com.\t3600\tIN\tAAAA\tfd00::1"},
+			{string(sink.Add), "nic1.vmi1.ns1.vm.domain.com.", "nic1.vmi1.ns1.vm.domain.com.\t3600\tIN\tAAAA\tfd00::1"},
+		}))
+	})
+
+	It("should remove the RRsets of a removed VMI", func() {
+		updateVMI()
+		Expect(changeLines()).To(Equal([][]string{
+			{string(sink.Remove), "vmi1.ns1.vm.domain.com.", "vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1"},
+			{string(sink.Remove), "nic1.vmi1.ns1.vm.domain.com.", "nic1.vmi1.ns1.vm.domain.com.\t3600\tIN\tA\t10.0.0.1"},
+		}))
+	})
+
+	It("should have no change when the zone was regenerated without record changes", func() {
+		zoneFileCache.ForceUpdate()
+		Expect(changeLines()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,103 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// PrimaryInterfaceAnnotation is the VMI annotation that names the interface, or the interface network, whose IPs are
+// published under the VMI default name. It can be set as a VMI label too, e.g. by the VM spec.template.metadata.labels,
+// the annotation takes precedence over the label.
+const PrimaryInterfaceAnnotation = "secondarydns.kubevirt.io/primary-interface"
+
+const (
+	// DefaultInterfacePolicyName picks the first interface sorted by name
+	DefaultInterfacePolicyName = "name"
+	// DefaultInterfacePolicySpec picks the first interface in the VMI spec networks order
+	DefaultInterfacePolicySpec = "spec"
+	// DefaultInterfacePolicyNetworkPrefix prefers the interface connected to the given network, e.g. network:nad1
+	DefaultInterfacePolicyNetworkPrefix = "network:"
+)
+
+// DefaultInterfacePolicy determines which interface IPs are published under the VMI default name, when the VMI does
+// not have the primary interface annotation. The first interface that has IPs of a family is picked for that family.
+type DefaultInterfacePolicy struct {
+	order   string
+	network string
+}
+
+// ParseDefaultInterfacePolicy parses the cluster-wide default interface policy, an empty policy is the name policy
+func ParseDefaultInterfacePolicy(policy string) (DefaultInterfacePolicy, error) {
+	switch {
+	case policy == "" || policy == DefaultInterfacePolicyName:
+		return DefaultInterfacePolicy{order: DefaultInterfacePolicyName}, nil
+	case policy == DefaultInterfacePolicySpec:
+		return DefaultInterfacePolicy{order: DefaultInterfacePolicySpec}, nil
+	case strings.HasPrefix(policy, DefaultInterfacePolicyNetworkPrefix) && len(policy) > len(DefaultInterfacePolicyNetworkPrefix):
+		return DefaultInterfacePolicy{order: DefaultInterfacePolicyName, network: strings.TrimPrefix(policy, DefaultInterfacePolicyNetworkPrefix)}, nil
+	default:
+		return DefaultInterfacePolicy{}, fmt.Errorf("invalid default interface policy %q, supported values are %q, %q and %q<network name>",
+			policy, DefaultInterfacePolicyName, DefaultInterfacePolicySpec, DefaultInterfacePolicyNetworkPrefix)
+	}
+}
+
+// sortDefaultCandidates returns the VMI interfaces sorted by their preference to back the VMI default name. The
+// interface named by the VMI annotation or label comes first, then the interface on the policy network, then the rest of the
+// interfaces by the policy order.
+func (policy DefaultInterfacePolicy) sortDefaultCandidates(vmi *v1.VirtualMachineInstance) []v1.VirtualMachineInstanceNetworkInterface {
+	sortedInterfaces := make([]v1.VirtualMachineInstanceNetworkInterface, len(vmi.Status.Interfaces))
+	copy(sortedInterfaces, vmi.Status.Interfaces)
+
+	specOrder := map[string]int{}
+	for i, network := range vmi.Spec.Networks {
+		specOrder[network.Name] = i
+	}
+	getSpecOrder := func(name string) int {
+		if order, exists := specOrder[name]; exists {
+			return order
+		}
+		return len(specOrder)
+	}
+
+	primaryInterface := getPrimaryInterface(vmi)
+	getPreference := func(iface v1.VirtualMachineInstanceNetworkInterface) int {
+		networkName := getNetworkName(vmi.Spec.Networks, iface.Name)
+		switch {
+		case primaryInterface != "" && iface.Name == primaryInterface:
+			return 0
+		case primaryInterface != "" && networkName == primaryInterface:
+			return 1
+		case policy.network != "" && networkName == policy.network:
+			return 2
+		default:
+			return 3
+		}
+	}
+
+	sort.SliceStable(sortedInterfaces, func(i, j int) bool {
+		iPreference, jPreference := getPreference(sortedInterfaces[i]), getPreference(sortedInterfaces[j])
+		if iPreference != jPreference {
+			return iPreference < jPreference
+		}
+		if policy.order == DefaultInterfacePolicySpec {
+			iOrder, jOrder := getSpecOrder(sortedInterfaces[i].Name), getSpecOrder(sortedInterfaces[j].Name)
+			if iOrder != jOrder {
+				return iOrder < jOrder
+			}
+		}
+		return sortedInterfaces[i].Name < sortedInterfaces[j].Name
+	})
+	return sortedInterfaces
+}
+
+// getPrimaryInterface returns the interface, or the interface network, named by the VMI annotation, or by the VMI label
+// when the annotation is not set
+func getPrimaryInterface(vmi *v1.VirtualMachineInstance) string {
+	if primaryInterface, exists := vmi.Annotations[PrimaryInterfaceAnnotation]; exists {
+		return primaryInterface
+	}
+	return vmi.Labels[PrimaryInterfaceAnnotation]
+}
@@ -0,0 +1,98 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI default interface", func() {
+	DescribeTable("parse default interface policy", func(policy string, expectedPolicy DefaultInterfacePolicy, expectedValid bool) {
+		parsedPolicy, err := ParseDefaultInterfacePolicy(policy)
+		if expectedValid {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsedPolicy).To(Equal(expectedPolicy))
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("empty policy defaults to name order", "", DefaultInterfacePolicy{order: DefaultInterfacePolicyName}, true),
+		Entry("name order", "name", DefaultInterfacePolicy{order: DefaultInterfacePolicyName}, true),
+		Entry("spec order", "spec", DefaultInterfacePolicy{order: DefaultInterfacePolicySpec}, true),
+		Entry("preferred network", "network:nad1", DefaultInterfacePolicy{order: DefaultInterfacePolicyName, network: "nad1"}, true),
+		Entry("preferred network with no name is invalid", "network:", DefaultInterfacePolicy{}, false),
+		Entry("unknown policy is invalid", "random", DefaultInterfacePolicy{}, false),
+	)
+
+	Describe("default records", func() {
+		const (
+			domain    = "vm.domain.com"
+			namespace = "ns1"
+			vmiName   = "vmi1"
+		)
+
+		newMultiNICVMI := func(annotations map[string]string) *v1.VirtualMachineInstance {
+			vmi := &v1.VirtualMachineInstance{}
+			vmi.Annotations = annotations
+			vmi.Spec.Networks = []v1.Network{
+				{Name: "z-data", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "data-nad"}}},
+				{Name: "b-storage", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "ns1/storage-nad"}}},
+				{Name: "a-mgmt", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "mgmt-nad"}}},
+			}
+			vmi.Status.Interfaces = []v1.VirtualMachineInstanceNetworkInterface{
+				{Name: "a-mgmt", IPs: []string{"10.0.0.1"}},
+				{Name: "b-storage", IPs: []string{"10.0.0.2", "2001:db8::2"}},
+				{Name: "z-data", IPs: []string{"10.0.0.3"}},
+			}
+			return vmi
+		}
+
+		DescribeTable("should publish the default records of the preferred interface", func(policy string,
+			annotations map[string]string, expectedDefaultRecords string) {
+			naming, err := NewNaming(NamingModeName, "", "", policy, domain)
+			Expect(err).ToNot(HaveOccurred())
+			zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, naming, nil)
+			Expect(zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace, Name: vmiName}, newMultiNICVMI(annotations)).IsChanged()).To(BeTrue())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).To(ContainSubstring(expectedDefaultRecords))
+		},
+			Entry("first interface by name", "name", nil,
+				"vmi1.ns1 IN A 10.0.0.1\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("first interface by spec order", "spec", nil,
+				"vmi1.ns1 IN A 10.0.0.3\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("interface on the preferred network", "network:storage-nad", nil,
+				"vmi1.ns1 IN A 10.0.0.2\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("preferred network that is not connected falls back to name order", "network:other-nad", nil,
+				"vmi1.ns1 IN A 10.0.0.1\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("interface named by the annotation", "name", map[string]string{PrimaryInterfaceAnnotation: "z-data"},
+				"vmi1.ns1 IN A 10.0.0.3\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("interface network named by the annotation", "spec", map[string]string{PrimaryInterfaceAnnotation: "mgmt-nad"},
+				"vmi1.ns1 IN A 10.0.0.1\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("annotation takes precedence over the preferred network", "network:storage-nad", map[string]string{PrimaryInterfaceAnnotation: "a-mgmt"},
+				"vmi1.ns1 IN A 10.0.0.1\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("annotation naming a missing interface falls back to the policy", "spec", map[string]string{PrimaryInterfaceAnnotation: "missing"},
+				"vmi1.ns1 IN A 10.0.0.3\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+		)
+
+		DescribeTable("should publish the default records of the interface named by the label",
+			func(annotations map[string]string, labels map[string]string, expectedDefaultRecords string) {
+				naming, err := NewNaming(NamingModeName, "", "", "name", domain)
+				Expect(err).ToNot(HaveOccurred())
+				zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, naming, nil)
+				vmi := newMultiNICVMI(annotations)
+				vmi.Labels = labels
+				Expect(zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace, Name: vmiName}, vmi).IsChanged()).To(BeTrue())
+				zoneFileCache.Flush()
+				Expect(zoneFileCache.aRecords).To(ContainSubstring(expectedDefaultRecords))
+			},
+			Entry("interface named by the label", nil, map[string]string{PrimaryInterfaceAnnotation: "z-data"},
+				"vmi1.ns1 IN A 10.0.0.3\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("interface network named by the label", nil, map[string]string{PrimaryInterfaceAnnotation: "storage-nad"},
+				"vmi1.ns1 IN A 10.0.0.2\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+			Entry("annotation takes precedence over the label", map[string]string{PrimaryInterfaceAnnotation: "z-data"},
+				map[string]string{PrimaryInterfaceAnnotation: "b-storage"}, "vmi1.ns1 IN A 10.0.0.3\nvmi1.ns1 IN AAAA 2001:db8::2\n"),
+		)
+	})
+})
@@ -0,0 +1,87 @@
+package zone_file_cache
+
+// journalMaxChanges is the number of zone changes the journal holds at most
+const journalMaxChanges = 100
+
+// ZoneChange is the change of the zone records from a SOA serial to the next one, the records are zone file lines
+type ZoneChange struct {
+	FromSerial uint32
+	ToSerial   uint32
+	Removed    string
+	Added      string
+
+	recordsCount int
+}
+
+// ZoneTransfer is the zone data a secondary server is sent, it is either the changes since the serial of the
+// secondary, or the zone content of the last flush. The records are zone file lines, so they are parsed once the zone
+// can be updated again.
+type ZoneTransfer struct {
+	IsIncremental bool
+	Changes       []ZoneChange
+	Content       string
+}
+
+// journal holds the recent changes of the zone, so the secondary servers transfer the changes only (RFC 1995). The
+// journal is bounded, and the records of all its changes are not more than the records of the zone, as beyond that a
+// full transfer is smaller.
+type journal struct {
+	changes      []ZoneChange
+	recordsCount int
+}
+
+func newJournal() *journal {
+	return &journal{}
+}
+
+func (journal *journal) add(fromSerial, toSerial uint32, addedRecords, removedRecords []Record, zoneRecordsCount int) {
+	change := ZoneChange{
+		FromSerial:   fromSerial,
+		ToSerial:     toSerial,
+		Removed:      joinRecords(removedRecords, 0),
+		Added:        joinRecords(addedRecords, 0),
+		recordsCount: len(addedRecords) + len(removedRecords),
+	}
+	journal.changes = append(journal.changes, change)
+	journal.recordsCount += change.recordsCount
+	for len(journal.changes) > journalMaxChanges || (len(journal.changes) > 1 && journal.recordsCount > zoneRecordsCount) {
+		journal.recordsCount -= journal.changes[0].recordsCount
+		journal.changes = journal.changes[1:]
+	}
+}
+
+// changesSince returns the changes from the given serial to the last serial, and whether the journal holds them
+func (journal *journal) changesSince(serial uint32) ([]ZoneChange, bool) {
+	for i, change := range journal.changes {
+		if change.FromSerial == serial {
+			return journal.changes[i:], true
+		}
+	}
+	return nil, false
+}
+
+// EnableJournal keeps the recent changes of the zone from now on, so the changes since a serial can be transferred, see
+// Transfer
+func (zoneFileCache *ZoneFileCache) EnableJournal() {
+	zoneFileCache.journal = newJournal()
+}
+
+// Transfer returns the zone data of a transfer to a secondary server that has the given serial. An incremental
+// transfer sends the changes since the serial, when the journal holds them, otherwise the zone content of the last
+// flush is sent. A secondary server that is up to date is sent no change.
+func (zoneFileCache *ZoneFileCache) Transfer(serial uint32, isIncremental bool) ZoneTransfer {
+	if isIncremental {
+		if serial == zoneFileCache.soaSerial || isSerialGreater(serial, zoneFileCache.soaSerial) {
+			return ZoneTransfer{IsIncremental: true}
+		}
+		if zoneFileCache.journal != nil {
+			if changes, isJournaled := zoneFileCache.journal.changesSince(serial); isJournaled {
+				return ZoneTransfer{IsIncremental: true, Changes: changes}
+			}
+		}
+	}
+	if zoneFileCache.Content == "" {
+		return ZoneTransfer{Content: zoneFileCache.header}
+	}
+	return ZoneTransfer{Content: zoneFileCache.Content}
+}
@@ -0,0 +1,105 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"fmt"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("Zone journal", func() {
+	const domain = "vm.domain.com"
+
+	var vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+	var vmi2 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi2"}
+	var vmi3 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi3"}
+	var zoneFileCache *ZoneFileCache
+
+	updateVMI := func(namespacedName k8stypes.NamespacedName, ips ...string) {
+		var vmi *v1.VirtualMachineInstance
+		if len(ips) > 0 {
+			vmi = newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: ips, Name: "nic1"}})
+		}
+		zoneFileCache.UpdateVMIRecords(namespacedName, vmi)
+		zoneFileCache.Flush()
+	}
+
+	BeforeEach(func() {
+		soa, err := NewSOA(SOAParams{})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache = NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		zoneFileCache.EnableJournal()
+		updateVMI(vmi1, "10.0.0.1")
+		updateVMI(vmi2, "10.0.0.2")
+		updateVMI(vmi3, "10.0.0.3")
+		Expect(zoneFileCache.soaSerial).To(Equal(uint32(3)))
+	})
+
+	It("should transfer the changes since the serial of the secondary server", func() {
+		zoneTransfer := zoneFileCache.Transfer(1, true)
+		Expect(zoneTransfer.IsIncremental).To(BeTrue())
+		Expect(zoneTransfer.Changes).To(HaveLen(2))
+		Expect(zoneTransfer.Changes[0].FromSerial).To(Equal(uint32(1)))
+		Expect(zoneTransfer.Changes[0].ToSerial).To(Equal(uint32(2)))
+		Expect(zoneTransfer.Changes[0].Removed).To(BeEmpty())
+		Expect(zoneTransfer.Changes[0].Added).To(Equal("vmi2.ns1 IN A 10.0.0.2\nnic1.vmi2.ns1 IN A 10.0.0.2\n"))
+		Expect(zoneTransfer.Changes[1].FromSerial).To(Equal(uint32(2)))
+		Expect(zoneTransfer.Changes[1].ToSerial).To(Equal(uint32(3)))
+		Expect(zoneTransfer.Changes[1].Added).To(Equal("vmi3.ns1 IN A 10.0.0.3\nnic1.vmi3.ns1 IN A 10.0.0.3\n"))
+	})
+
+	It("should journal the removed and the added records of an update", func() {
+		updateVMI(vmi1, "10.0.0.4")
+		changes := zoneFileCache.Transfer(3, true).Changes
+		Expect(changes).To(HaveLen(1))
+		Expect(changes[0].Removed).To(Equal("vmi1.ns1 IN A 10.0.0.1\nnic1.vmi1.ns1 IN A 10.0.0.1\n"))
+		Expect(changes[0].Added).To(Equal("vmi1.ns1 IN A 10.0.0.4\nnic1.vmi1.ns1 IN A 10.0.0.4\n"))
+	})
+
+	It("should transfer no change to a secondary server that is up to date", func() {
+		Expect(zoneFileCache.Transfer(3, true)).To(Equal(ZoneTransfer{IsIncremental: true}))
+	})
+
+	It("should transfer the full zone when the changes since the serial are not journaled", func() {
+		updateVMI(vmi1, "10.0.0.4")
+		zoneTransfer := zoneFileCache.Transfer(0, true)
+		Expect(zoneTransfer.IsIncremental).To(BeFalse())
+		Expect(zoneTransfer.Content).To(Equal(zoneFileCache.Content))
+	})
+
+	It("should transfer the full zone on a full transfer", func() {
+		zoneTransfer := zoneFileCache.Transfer(1, false)
+		Expect(zoneTransfer.IsIncremental).To(BeFalse())
+		Expect(zoneTransfer.Content).To(Equal(zoneFileCache.Content))
+		Expect(zoneTransfer.Content).To(ContainSubstring("nic1.vmi3.ns1 IN A 10.0.0.3\n"))
+	})
+
+	It("should not journal the changes of a zone whose journal is disabled", func() {
+		soa, err := NewSOA(SOAParams{})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache = NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		updateVMI(vmi1, "10.0.0.1")
+		updateVMI(vmi2, "10.0.0.2")
+		Expect(zoneFileCache.Transfer(1, true).IsIncremental).To(BeFalse())
+	})
+
+	It("should not hold more changes than the zone records", func() {
+		updateVMI(vmi1, "10.0.0.4")
+		Expect(zoneFileCache.Transfer(2, true).Changes).To(HaveLen(2))
+		Expect(zoneFileCache.Transfer(1, true).IsIncremental).To(BeFalse())
+	})
+
+	It("should not hold more than the maximal number of changes", func() {
+		for i := 0; i < journalMaxChanges; i++ {
+			updateVMI(k8stypes.NamespacedName{Namespace: "ns2", Name: fmt.Sprintf("vmi%d", i)}, "10.0.1.1")
+		}
+		Expect(zoneFileCache.journal.changes).To(HaveLen(journalMaxChanges))
+		Expect(zoneFileCache.Transfer(3, true).Changes).To(HaveLen(journalMaxChanges))
+		Expect(zoneFileCache.Transfer(2, true).IsIncremental).To(BeFalse())
+		lastChange := zoneFileCache.journal.changes[journalMaxChanges-1]
+		Expect(lastChange.Added).To(ContainSubstring(fmt.Sprintf("nic1.vmi%d.ns2 IN A 10.0.1.1\n", journalMaxChanges-1)))
+	})
+})
@@ -0,0 +1,91 @@
+package zone_file_cache
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const apexName = "@"
+
+// Origin returns the zone origin as an absolute name
+func (zoneFileCache *ZoneFileCache) Origin() string {
+	return dns.Fqdn(zoneFileCache.origin)
+}
+
+// SOA returns the SOA record of the zone, with the serial of the last flush
+func (zoneFileCache *ZoneFileCache) SOA() *dns.SOA {
+	soa := zoneFileCache.soa
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zoneFileCache.Origin(), Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soa.ttl},
+		Ns:      dns.Fqdn(zoneFileCache.nameServerName),
+		Mbox:    dns.Fqdn(zoneFileCache.adminEmail),
+		Serial:  zoneFileCache.soaSerial,
+		Refresh: soa.refresh,
+		Retry:   soa.retry,
+		Expire:  soa.expire,
+		Minttl:  soa.negativeTTL,
+	}
+}
+
+// Lookup returns the records of the given absolute name, and whether the name exists in the zone, i.e. whether it has
+// records or records below it. The records are those of the cache, including the records updated since the last flush.
+func (zoneFileCache *ZoneFileCache) Lookup(name string) ([]dns.RR, bool, error) {
+	relativeName, isInZone := zoneFileCache.relativeName(name)
+	if !isInZone {
+		return nil, false, nil
+	}
+
+	records, exists := zoneFileCache.records.lookup(relativeName)
+	for _, record := range zoneFileCache.headerRecords {
+		if strings.EqualFold(record.name, relativeName) {
+			records = append(records, record)
+		}
+		exists = exists || isNameOrDescendant(record.name, relativeName)
+	}
+
+	var rrs []dns.RR
+	if relativeName == apexName {
+		exists = true
+		rrs = append(rrs, zoneFileCache.SOA())
+	}
+	for _, record := range records {
+		rr, err := record.toRR(zoneFileCache.Origin(), zoneFileCache.soa.ttl)
+		if err != nil {
+			return nil, false, err
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs, exists, nil
+}
+
+// relativeName returns the absolute name relative to the zone origin, the origin itself is the apex name
+func (zoneFileCache *ZoneFileCache) relativeName(name string) (string, bool) {
+	name = strings.TrimSuffix(name, ".")
+	if strings.EqualFold(name, zoneFileCache.origin) {
+		return apexName, true
+	}
+	suffix := "." + zoneFileCache.origin
+	if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", false
+	}
+	return name[:len(name)-len(suffix)], true
+}
+
+// isNameOrDescendant returns whether the relative name is the given name or is below it
+func isNameOrDescendant(name string, ancestor string) bool {
+	return strings.EqualFold(name, ancestor) ||
+		(len(name) > len(ancestor) && strings.EqualFold(name[len(name)-len(ancestor)-1:], "."+ancestor))
+}
+
+// toRR parses the record line, relative names are relative to the given origin, and a record without a TTL has the
+// given TTL
+func (record Record) toRR(origin string, ttl uint32) (dns.RR, error) {
+	zoneParser := dns.NewZoneParser(strings.NewReader(record.String()), origin, "")
+	zoneParser.SetDefaultTTL(ttl)
+	rr, _ := zoneParser.Next()
+	if err := zoneParser.Err(); err != nil {
+		return nil, err
+	}
+	return rr, nil
+}
@@ -0,0 +1,88 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"strings"
+
+	"github.com/miekg/dns"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("Zone lookup", func() {
+	const domain = "vm.domain.com"
+
+	var vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+	var zoneFileCache *ZoneFileCache
+
+	BeforeEach(func() {
+		soa, err := NewSOA(SOAParams{NameServers: "dns1=10.0.0.1,ns.example.com.", NegativeTTL: "300"})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache = NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5", "fd00::5"}, Name: "nic1"}})
+		vmi.Annotations = map[string]string{AliasesAnnotation: "db=nic1"}
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, vmi).IsChanged()).To(BeTrue())
+	})
+
+	lookup := func(name string) ([]string, bool) {
+		rrs, exists, err := zoneFileCache.Lookup(name)
+		Expect(err).ToNot(HaveOccurred())
+		var lines []string
+		for _, rr := range rrs {
+			lines = append(lines, strings.Join(strings.Fields(rr.String()), " "))
+		}
+		return lines, exists
+	}
+
+	DescribeTable("look up the zone records", func(name string, expectedRecords []string, expectedExists bool) {
+		records, exists := lookup(name)
+		Expect(records).To(Equal(expectedRecords))
+		Expect(exists).To(Equal(expectedExists))
+	},
+		Entry("VMI interface records", "nic1.vmi1.ns1.vm.domain.com.",
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5", "nic1.vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5"}, true),
+		Entry("name in another case", "NIC1.vmi1.NS1.vm.Domain.com.",
+			[]string{"nic1.vmi1.ns1.vm.domain.com. 3600 IN A 10.0.0.5", "nic1.vmi1.ns1.vm.domain.com. 3600 IN AAAA fd00::5"}, true),
+		Entry("alias record with a relative target", "db.ns1.vm.domain.com.",
+			[]string{"db.ns1.vm.domain.com. 3600 IN CNAME nic1.vmi1.ns1.vm.domain.com."}, true),
+		Entry("apex SOA and NS records", "vm.domain.com.",
+			[]string{"vm.domain.com. 3600 IN SOA dns1.vm.domain.com. email.vm.domain.com. 0 3600 3600 1209600 300",
+				"vm.domain.com. 3600 IN NS dns1.vm.domain.com.", "vm.domain.com. 3600 IN NS ns.example.com."}, true),
+		Entry("name server glue record", "dns1.vm.domain.com.", []string{"dns1.vm.domain.com. 3600 IN A 10.0.0.1"}, true),
+		Entry("empty non-terminal name", "ns1.vm.domain.com", nil, true),
+		Entry("missing name", "nic2.vmi1.ns1.vm.domain.com.", nil, false),
+		Entry("missing name that suffixes an existing name", "c1.vmi1.ns1.vm.domain.com.", nil, false),
+		Entry("name out of the zone", "vmi1.ns1.example.com.", nil, false),
+	)
+
+	It("should look up the records as soon as they are updated, before the zone is flushed", func() {
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, nil).IsChanged()).To(BeTrue())
+		records, exists := lookup("nic1.vmi1.ns1.vm.domain.com.")
+		Expect(records).To(BeEmpty())
+		Expect(exists).To(BeFalse())
+		_, exists = lookup("ns1.vm.domain.com.")
+		Expect(exists).To(BeFalse())
+		_, exists = lookup("dns1.vm.domain.com.")
+		Expect(exists).To(BeTrue())
+	})
+
+	It("should serve the SOA serial of the last flush", func() {
+		Expect(zoneFileCache.SOA().Serial).To(Equal(uint32(0)))
+		Expect(zoneFileCache.Flush()).To(BeTrue())
+		Expect(zoneFileCache.SOA().Serial).To(Equal(uint32(1)))
+		Expect(zoneFileCache.Origin()).To(Equal(domain + "."))
+	})
+
+	It("should look up the reverse zone records", func() {
+		reverseZoneFileCache := NewReverseZoneFileCache("", domain, "0.0.10.in-addr.arpa", nil, SerialSchemeCounter, nil)
+		ptrRecords := []Record{newRecord("5", recordTypePTR, "nic1.vmi1.ns1.vm.domain.com.")}
+		Expect(reverseZoneFileCache.UpdateVMIPTRRecords(vmi1, nil, ptrRecords).IsChanged()).To(BeTrue())
+		rrs, exists, err := reverseZoneFileCache.Lookup("5.0.0.10.in-addr.arpa.")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exists).To(BeTrue())
+		Expect(rrs).To(HaveLen(1))
+		Expect(rrs[0].(*dns.PTR).Ptr).To(Equal("nic1.vmi1.ns1.vm.domain.com."))
+	})
+})
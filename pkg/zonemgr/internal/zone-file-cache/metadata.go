@@ -0,0 +1,143 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"strings"
+
+	netutils "k8s.io/utils/net"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+const recordTypeTXT = "TXT"
+
+const (
+	// MetadataFieldUID publishes the VMI UID
+	MetadataFieldUID = "uid"
+	// MetadataFieldNode publishes the name of the node the VMI runs on
+	MetadataFieldNode = "node"
+	// MetadataFieldNetwork publishes the NetworkAttachmentDefinition name of the interface, interface records only
+	MetadataFieldNetwork = "network"
+	// MetadataFieldMAC publishes the MAC address of the interface, interface records only
+	MetadataFieldMAC = "mac"
+	// MetadataFieldLabelPrefix publishes the value of the VMI label with the given key, e.g. label:app
+	MetadataFieldLabelPrefix = "label:"
+
+	metadataFieldsSeparator = ","
+
+	// maxTXTStringLength is the maximal length of a TXT character-string, in bytes
+	maxTXTStringLength = 255
+)
+
+// Metadata determines the VMI fields published in the TXT record of each VMI name. Only the fields chosen by the
+// operator are published, no TXT records are published when no field is chosen.
+type Metadata struct {
+	uid     bool
+	node    bool
+	network bool
+	mac     bool
+	labels  []string
+}
+
+// NewMetadata parses the comma separated list of the metadata fields to publish, e.g. uid,node,network,mac,label:app
+func NewMetadata(fields string) (*Metadata, error) {
+	metadata := &Metadata{}
+	for _, field := range strings.Split(fields, metadataFieldsSeparator) {
+		switch field = strings.TrimSpace(field); {
+		case field == "":
+		case field == MetadataFieldUID:
+			metadata.uid = true
+		case field == MetadataFieldNode:
+			metadata.node = true
+		case field == MetadataFieldNetwork:
+			metadata.network = true
+		case field == MetadataFieldMAC:
+			metadata.mac = true
+		case strings.HasPrefix(field, MetadataFieldLabelPrefix) && len(field) > len(MetadataFieldLabelPrefix):
+			metadata.labels = append(metadata.labels, strings.TrimPrefix(field, MetadataFieldLabelPrefix))
+		default:
+			return nil, fmt.Errorf("invalid metadata field %q, supported fields are %q, %q, %q, %q and %q<label key>",
+				field, MetadataFieldUID, MetadataFieldNode, MetadataFieldNetwork, MetadataFieldMAC, MetadataFieldLabelPrefix)
+		}
+	}
+	return metadata, nil
+}
+
+func (metadata *Metadata) isEnabled() bool {
+	return metadata != nil && (metadata.uid || metadata.node || metadata.network || metadata.mac || len(metadata.labels) > 0)
+}
+
+// generateTXTRecords returns the TXT records of the VMI default name and of its interfaces names that have address
+// records
+func (metadata *Metadata) generateTXTRecords(names vmiNames, vmi *v1.VirtualMachineInstance) []Record {
+	if !metadata.isEnabled() {
+		return nil
+	}
+
+	vmiStrings := metadata.generateVMIStrings(vmi)
+	var recordsArr []Record
+	hasAddressRecords := false
+	for _, iface := range vmi.Status.Interfaces {
+		interfaceName, exists := names.interfaceNames[iface.Name]
+		if !exists || !hasPublishableIPs(iface) {
+			continue
+		}
+		hasAddressRecords = true
+		interfaceStrings := append([]string{}, vmiStrings...)
+		if metadata.network {
+			interfaceStrings = appendMetadataString(interfaceStrings, MetadataFieldNetwork, getNetworkName(vmi.Spec.Networks, iface.Name))
+		}
+		if metadata.mac {
+			interfaceStrings = appendMetadataString(interfaceStrings, MetadataFieldMAC, iface.MAC)
+		}
+		recordsArr = append(recordsArr, generateTXTRecord(interfaceName, interfaceStrings)...)
+	}
+	if !hasAddressRecords {
+		return nil
+	}
+
+	return append(recordsArr, generateTXTRecord(names.defaultName, vmiStrings)...)
+}
+
+func hasPublishableIPs(iface v1.VirtualMachineInstanceNetworkInterface) bool {
+	return len(getPublishableIPs(iface.IPs, netutils.IsIPv4String)) > 0 || len(getPublishableIPs(iface.IPs, netutils.IsIPv6String)) > 0
+}
+
+// generateVMIStrings returns the TXT strings of the metadata fields that are common to all the VMI names
+func (metadata *Metadata) generateVMIStrings(vmi *v1.VirtualMachineInstance) []string {
+	var vmiStrings []string
+	if metadata.uid {
+		vmiStrings = appendMetadataString(vmiStrings, MetadataFieldUID, string(vmi.UID))
+	}
+	if metadata.node {
+		vmiStrings = appendMetadataString(vmiStrings, MetadataFieldNode, vmi.Status.NodeName)
+	}
+	for _, key := range metadata.labels {
+		if value, exists := vmi.Labels[key]; exists {
+			vmiStrings = appendMetadataString(vmiStrings, MetadataFieldLabelPrefix+key, value)
+		}
+	}
+	return vmiStrings
+}
+
+// appendMetadataString appends the quoted <field>=<value> TXT string, empty values are omitted. A string that is longer
+// than a TXT character-string can be is split into consecutive character-strings, which the readers concatenate.
+func appendMetadataString(txtStrings []string, field string, value string) []string {
+	if value == "" {
+		return txtStrings
+	}
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	for txtString := field + "=" + value; txtString != ""; {
+		chunkLength := min(len(txtString), maxTXTStringLength)
+		txtStrings = append(txtStrings, `"`+escaper.Replace(txtString[:chunkLength])+`"`)
+		txtString = txtString[chunkLength:]
+	}
+	return txtStrings
+}
+
+func generateTXTRecord(fqdn string, txtStrings []string) []Record {
+	if len(txtStrings) == 0 {
+		return nil
+	}
+	return []Record{newRecord(fqdn, recordTypeTXT, strings.Join(txtStrings, " "))}
+}
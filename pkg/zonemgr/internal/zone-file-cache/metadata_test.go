@@ -0,0 +1,115 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI TXT metadata", func() {
+	const domain = "vm.domain.com"
+
+	vmi1 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+
+	newMetadataVMI := func() *v1.VirtualMachineInstance {
+		vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{
+			{IPs: []string{"10.0.0.1"}, MAC: "02:00:00:00:00:01", Name: "nic1"},
+			{IPs: []string{"fe80::1"}, MAC: "02:00:00:00:00:02", Name: "nic2"},
+		})
+		vmi.UID = "1234-abcd"
+		vmi.Labels = map[string]string{"app": "db", "owner": "team-a"}
+		vmi.Spec.Networks = []v1.Network{
+			{Name: "nic1", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "ns1/nad1"}}},
+		}
+		vmi.Status.NodeName = "node01"
+		return vmi
+	}
+
+	DescribeTable("parse metadata fields", func(fields string, expectedMetadata *Metadata, expectedValid bool) {
+		metadata, err := NewMetadata(fields)
+		if expectedValid {
+			Expect(err).ToNot(HaveOccurred())
+			Expect(metadata).To(Equal(expectedMetadata))
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("no fields", "", &Metadata{}, true),
+		Entry("all fields", "uid, node,network,mac,label:app,label:owner",
+			&Metadata{uid: true, node: true, network: true, mac: true, labels: []string{"app", "owner"}}, true),
+		Entry("unknown field", "uid,annotations", nil, false),
+		Entry("label without a key", "label:", nil, false),
+	)
+
+	DescribeTable("generate TXT records", func(fields string, expectedRecords []string) {
+		metadata, err := NewMetadata(fields)
+		Expect(err).ToNot(HaveOccurred())
+		vmi := newMetadataVMI()
+		names, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, vmi)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(renderRecords(metadata.generateTXTRecords(names, vmi))).To(Equal(expectedRecords))
+	},
+		Entry("no fields", "", nil),
+		Entry("all fields", "uid,node,network,mac,label:app",
+			[]string{
+				"nic1.vmi1.ns1 IN TXT \"uid=1234-abcd\" \"node=node01\" \"label:app=db\" \"network=nad1\" \"mac=02:00:00:00:00:01\"\n",
+				"vmi1.ns1 IN TXT \"uid=1234-abcd\" \"node=node01\" \"label:app=db\"\n",
+			},
+		),
+		Entry("interface fields only", "mac",
+			[]string{"nic1.vmi1.ns1 IN TXT \"mac=02:00:00:00:00:01\"\n"},
+		),
+		Entry("missing label is omitted", "uid,label:zone",
+			[]string{
+				"nic1.vmi1.ns1 IN TXT \"uid=1234-abcd\"\n",
+				"vmi1.ns1 IN TXT \"uid=1234-abcd\"\n",
+			},
+		),
+	)
+
+	It("should split a long metadata string into TXT character-strings of up to 255 bytes", func() {
+		metadata, err := NewMetadata("label:description")
+		Expect(err).ToNot(HaveOccurred())
+		vmi := newMetadataVMI()
+		longValue := strings.Repeat("a", 300)
+		vmi.Labels["description"] = longValue
+		names, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, vmi)
+		Expect(err).ToNot(HaveOccurred())
+
+		txtString := "label:description=" + longValue
+		expectedRecord := fmt.Sprintf("vmi1.ns1 IN TXT \"%s\" \"%s\"\n", txtString[:255], txtString[255:])
+		records := metadata.generateTXTRecords(names, vmi)
+		Expect(renderRecords(records)).To(ContainElement(expectedRecord))
+
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), metadata)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, vmi).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		rr, err := dns.NewRR("$ORIGIN " + domain + ".\n" + expectedRecord)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Join(rr.(*dns.TXT).Txt, "")).To(Equal(txtString))
+		Expect(zoneFileCache.Content).To(ContainSubstring(expectedRecord))
+	})
+
+	It("should publish the TXT records along with the VMI records", func() {
+		metadata, err := NewMetadata("node")
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), metadata)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newMetadataVMI()).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal(
+			"vmi1.ns1 IN A 10.0.0.1\n" +
+				"vmi1.ns1 IN TXT \"node=node01\"\n" +
+				"nic1.vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi1.ns1 IN TXT \"node=node01\"\n"))
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, nil).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(BeEmpty())
+	})
+})
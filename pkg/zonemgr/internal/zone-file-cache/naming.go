@@ -0,0 +1,301 @@
+package zone_file_cache
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+type NamingMode string
+
+const (
+	// NamingModeName names the VMI records after the VMI object name
+	NamingModeName NamingMode = "name"
+	// NamingModeHostname names the VMI records after the VMI spec hostname and subdomain, the VMI object name is used
+	// when the hostname is not set
+	NamingModeHostname NamingMode = "hostname"
+)
+
+const (
+	vmiNameTemplateByName     = "{{.Name}}"
+	vmiNameTemplateByHostname = "{{.Hostname}}{{with .Subdomain}}.{{.}}{{end}}"
+
+	interfaceNameTemplateFmt = "{{.Interface}}.%s.{{.Namespace}}"
+	defaultNameTemplateFmt   = "%s.{{.Namespace}}"
+
+	maxDNSNameLength  = 253
+	maxDNSLabelLength = 63
+)
+
+// nameTemplateData holds the fields the name templates can refer to
+type nameTemplateData struct {
+	// Interface is the VMI interface name, it is set for the interface records names only
+	Interface string
+	// NetworkName is the NetworkAttachmentDefinition name of the interface network, without its namespace
+	NetworkName string
+	// Name is the VMI object name
+	Name string
+	// Hostname is the VMI spec hostname, or the VMI object name when the hostname is not set
+	Hostname  string
+	Subdomain string
+	Namespace string
+
+	labels       map[string]string
+	labelDefault string
+}
+
+// Label returns the value of the VMI label with the given key
+func (data nameTemplateData) Label(key string) string {
+	if value, exists := data.labels[key]; exists {
+		return value
+	}
+	return data.labelDefault
+}
+
+// sampleNameTemplateData is used to validate the name templates on startup
+var sampleNameTemplateData = nameTemplateData{
+	Interface:    "nic1",
+	NetworkName:  "network1",
+	Name:         "vmi1",
+	Hostname:     "host1",
+	Subdomain:    "subdomain1",
+	Namespace:    "namespace1",
+	labelDefault: "label1",
+}
+
+// sampleInterfaces maps the interfaces of the sample VMI to their network names, it is used to check on startup that the
+// name templates render distinct names for the interfaces of a VMI
+var sampleInterfaces = map[string]string{"nic1": "network1", "nic2": "network2"}
+
+// Naming renders the names of the VMI records, relative to the zone domain, from the interface and default name templates
+type Naming struct {
+	domain                 string
+	interfaceTemplate      *template.Template
+	defaultTemplate        *template.Template
+	defaultInterfacePolicy DefaultInterfacePolicy
+}
+
+// vmiNames holds the rendered names of a VMI records
+type vmiNames struct {
+	defaultName    string
+	interfaceNames map[string]string
+	// aliasNames maps the VMI alias names to their target names
+	aliasNames map[string]string
+	// services holds the SRV records of the services the VMI advertises, their names are not owned by the VMI
+	services []serviceRecord
+}
+
+// getNames returns the names owned by the VMI, i.e. all the names of its records except for the services names
+func (names vmiNames) getNames() []string {
+	allNames := names.getAddressNames()
+	for name := range names.aliasNames {
+		allNames = append(allNames, name)
+	}
+	return allNames
+}
+
+// getAddressNames returns the names of the VMI address records, i.e. its default name and its interfaces names
+func (names vmiNames) getAddressNames() []string {
+	addressNames := []string{names.defaultName}
+	for _, name := range names.interfaceNames {
+		addressNames = append(addressNames, name)
+	}
+	return addressNames
+}
+
+func (names vmiNames) isRecordName(name string) bool {
+	for _, recordName := range names.getNames() {
+		if recordName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// NewNaming creates the names renderer of the zone domain records. An empty template is replaced by the default
+// template of the naming mode, i.e. <interface>.<vm>.<namespace> and <vm>.<namespace>.
+// The default interface policy determines the interface whose IPs are published under the VMI default name.
+func NewNaming(namingMode NamingMode, interfaceTemplate string, defaultTemplate string, defaultInterfacePolicy string,
+	domain string) (*Naming, error) {
+	var vmiNameTemplate string
+	switch namingMode {
+	case NamingModeName:
+		vmiNameTemplate = vmiNameTemplateByName
+	case NamingModeHostname:
+		vmiNameTemplate = vmiNameTemplateByHostname
+	default:
+		return nil, fmt.Errorf("invalid naming mode %q, supported values are %q and %q", namingMode, NamingModeName, NamingModeHostname)
+	}
+	if interfaceTemplate == "" {
+		interfaceTemplate = fmt.Sprintf(interfaceNameTemplateFmt, vmiNameTemplate)
+	}
+	if defaultTemplate == "" {
+		defaultTemplate = fmt.Sprintf(defaultNameTemplateFmt, vmiNameTemplate)
+	}
+
+	policy, err := ParseDefaultInterfacePolicy(defaultInterfacePolicy)
+	if err != nil {
+		return nil, err
+	}
+	naming := &Naming{domain: domain, defaultInterfacePolicy: policy}
+	if naming.interfaceTemplate, err = parseNameTemplate("interface", interfaceTemplate, domain); err != nil {
+		return nil, err
+	}
+	if naming.defaultTemplate, err = parseNameTemplate("default", defaultTemplate, domain); err != nil {
+		return nil, err
+	}
+	if err = naming.validateDistinctNames(); err != nil {
+		return nil, fmt.Errorf("invalid name templates %q and %q: %w", interfaceTemplate, defaultTemplate, err)
+	}
+	return naming, nil
+}
+
+// validateDistinctNames checks the name templates render distinct names for the interfaces of the sample VMI and for
+// its default records, e.g. an interface template that does not refer to the interface renders the same name for all
+// the interfaces
+func (naming *Naming) validateDistinctNames() error {
+	data := sampleNameTemplateData
+	defaultName, err := renderName(naming.defaultTemplate, data, naming.domain)
+	if err != nil {
+		return err
+	}
+	interfaceNames := map[string]string{}
+	for interfaceName, networkName := range sampleInterfaces {
+		data.Interface, data.NetworkName = interfaceName, networkName
+		if interfaceNames[interfaceName], err = renderName(naming.interfaceTemplate, data, naming.domain); err != nil {
+			return err
+		}
+	}
+	return checkDistinctNames(defaultName, interfaceNames)
+}
+
+// checkDistinctNames checks the interfaces names are distinct from each other and from the default name, so the
+// records of an interface are not merged with the records of another interface or with the default records. The names
+// are compared case insensitively, as DNS names are.
+func checkDistinctNames(defaultName string, interfaceNames map[string]string) error {
+	interfaces := make([]string, 0, len(interfaceNames))
+	for interfaceName := range interfaceNames {
+		interfaces = append(interfaces, interfaceName)
+	}
+	sort.Strings(interfaces)
+
+	nameUsers := map[string]string{strings.ToLower(defaultName): "the default name"}
+	for _, interfaceName := range interfaces {
+		name := strings.ToLower(interfaceNames[interfaceName])
+		if user, isUsed := nameUsers[name]; isUsed {
+			return fmt.Errorf("the name %q of interface %s is also %s", interfaceNames[interfaceName], interfaceName, user)
+		}
+		nameUsers[name] = fmt.Sprintf("the name of interface %s", interfaceName)
+	}
+	return nil
+}
+
+// parseNameTemplate parses the template and checks it renders a valid DNS name
+func parseNameTemplate(templateName string, text string, domain string) (*template.Template, error) {
+	nameTemplate, err := template.New(templateName).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s name template: %w", templateName, err)
+	}
+	name, err := renderName(nameTemplate, sampleNameTemplateData, domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s name template %q: %w", templateName, text, err)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("invalid %s name template %q: the rendered name is empty", templateName, text)
+	}
+	return nameTemplate, nil
+}
+
+func renderName(nameTemplate *template.Template, data nameTemplateData, domain string) (string, error) {
+	var name bytes.Buffer
+	if err := nameTemplate.Execute(&name, data); err != nil {
+		return "", err
+	}
+	if err := validateDNSName(name.String(), domain); err != nil {
+		return "", err
+	}
+	return name.String(), nil
+}
+
+// validateDNSName checks the name, relative to the domain, consists of valid host name labels
+func validateDNSName(name string, domain string) error {
+	if len(name)+len(domain)+1 > maxDNSNameLength {
+		return fmt.Errorf("the name %q is longer than %d characters", name, maxDNSNameLength)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > maxDNSLabelLength {
+			return fmt.Errorf("the name %q contains an empty or a longer than %d characters label", name, maxDNSLabelLength)
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return fmt.Errorf("the name %q contains a label that starts or ends with a hyphen", name)
+		}
+		for _, char := range label {
+			if !isDNSLabelChar(char) {
+				return fmt.Errorf("the name %q contains the invalid character %q", name, char)
+			}
+		}
+	}
+	return nil
+}
+
+func isDNSLabelChar(char rune) bool {
+	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || (char >= '0' && char <= '9') ||
+		char == '-' || char == '_'
+}
+
+// generateNames renders the names of the VMI default records, of its interfaces records, of its aliases and of the
+// services it advertises
+func (naming *Naming) generateNames(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance) (vmiNames, error) {
+	data := nameTemplateData{
+		Name:      namespacedName.Name,
+		Hostname:  namespacedName.Name,
+		Subdomain: vmi.Spec.Subdomain,
+		Namespace: namespacedName.Namespace,
+		labels:    vmi.Labels,
+	}
+	if vmi.Spec.Hostname != "" {
+		data.Hostname = vmi.Spec.Hostname
+	}
+
+	names := vmiNames{interfaceNames: map[string]string{}}
+	var err error
+	if names.defaultName, err = renderName(naming.defaultTemplate, data, naming.domain); err != nil {
+		return vmiNames{}, fmt.Errorf("failed to generate the VMI default name: %w", err)
+	}
+	for _, iface := range vmi.Status.Interfaces {
+		data.Interface = iface.Name
+		data.NetworkName = getNetworkName(vmi.Spec.Networks, iface.Name)
+		if names.interfaceNames[iface.Name], err = renderName(naming.interfaceTemplate, data, naming.domain); err != nil {
+			return vmiNames{}, fmt.Errorf("failed to generate the name of interface %s: %w", iface.Name, err)
+		}
+	}
+	if err = checkDistinctNames(names.defaultName, names.interfaceNames); err != nil {
+		return vmiNames{}, err
+	}
+	data.Interface, data.NetworkName = "", ""
+	if names.aliasNames, err = naming.generateAliasNames(vmi, data, names); err != nil {
+		return vmiNames{}, fmt.Errorf("failed to generate the VMI aliases: %w", err)
+	}
+	if names.services, err = generateServiceRecords(vmi, names, naming.domain); err != nil {
+		return vmiNames{}, fmt.Errorf("failed to generate the VMI services: %w", err)
+	}
+	return names, nil
+}
+
+// getNetworkName returns the NetworkAttachmentDefinition name of the network, the name may be prefixed by the
+// NetworkAttachmentDefinition namespace which is omitted
+func getNetworkName(networks []v1.Network, name string) string {
+	for _, network := range networks {
+		if network.Name == name && network.Multus != nil {
+			networkName := network.Multus.NetworkName
+			return networkName[strings.LastIndex(networkName, "/")+1:]
+		}
+	}
+	return ""
+}
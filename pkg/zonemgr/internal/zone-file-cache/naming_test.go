@@ -0,0 +1,236 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI naming", func() {
+	const (
+		vmiName   = "vmi1"
+		namespace = "ns1"
+	)
+
+	const domain = "vm.domain.com"
+
+	DescribeTable("create naming", func(namingMode NamingMode, interfaceTemplate, defaultTemplate string, expectedValid bool) {
+		_, err := NewNaming(namingMode, interfaceTemplate, defaultTemplate, "", domain)
+		if expectedValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("name mode is valid", NamingModeName, "", "", true),
+		Entry("hostname mode is valid", NamingModeHostname, "", "", true),
+		Entry("unknown mode is invalid", NamingMode("fqdn"), "", "", false),
+		Entry("custom templates are valid", NamingModeName, "{{.Name}}-{{.Interface}}.{{.Namespace}}", "{{.Name}}", true),
+		Entry("template with label and network name is valid", NamingModeName, "{{.NetworkName}}.{{.Label \"team\"}}", "", true),
+		Entry("template with syntax error is invalid", NamingModeName, "{{.Name", "", false),
+		Entry("template with unknown field is invalid", NamingModeName, "", "{{.Node}}.{{.Namespace}}", false),
+		Entry("template rendering an empty label is invalid", NamingModeName, "{{.Interface}}..{{.Namespace}}", "", false),
+		Entry("template rendering an invalid character is invalid", NamingModeName, "", "{{.Name}}/{{.Namespace}}", false),
+		Entry("template rendering an empty name is invalid", NamingModeName, "", "{{if false}}{{.Name}}{{end}}", false),
+		Entry("template collapsing the interfaces names is invalid", NamingModeName, "{{.Name}}.{{.Namespace}}", "{{.Name}}", false),
+		Entry("template rendering an interface name as the default name is invalid", NamingModeName, "{{.Interface}}-{{.Name}}",
+			"nic2-{{.Name}}", false),
+	)
+
+	DescribeTable("generate VMI names", func(naming *Naming, vmi *v1.VirtualMachineInstance, expectedDefaultName string,
+		expectedInterfaceNames map[string]string) {
+		names, err := naming.generateNames(k8stypes.NamespacedName{Namespace: namespace, Name: vmiName}, vmi)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names.defaultName).To(Equal(expectedDefaultName))
+		Expect(names.interfaceNames).To(Equal(expectedInterfaceNames))
+	},
+		Entry("name mode ignores the hostname",
+			newTestNaming(NamingModeName, domain),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{Hostname: "host1", Subdomain: "sub1"}, nil),
+			"vmi1.ns1",
+			map[string]string{"nic1": "nic1.vmi1.ns1"},
+		),
+		Entry("hostname mode with hostname",
+			newTestNaming(NamingModeHostname, domain),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{Hostname: "host1"}, nil),
+			"host1.ns1",
+			map[string]string{"nic1": "nic1.host1.ns1"},
+		),
+		Entry("hostname mode with hostname and subdomain",
+			newTestNaming(NamingModeHostname, domain),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{Hostname: "host1", Subdomain: "sub1"}, nil),
+			"host1.sub1.ns1",
+			map[string]string{"nic1": "nic1.host1.sub1.ns1"},
+		),
+		Entry("hostname mode with subdomain only",
+			newTestNaming(NamingModeHostname, domain),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{Subdomain: "sub1"}, nil),
+			"vmi1.sub1.ns1",
+			map[string]string{"nic1": "nic1.vmi1.sub1.ns1"},
+		),
+		Entry("hostname mode falls back to the VMI name",
+			newTestNaming(NamingModeHostname, domain),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{}, nil),
+			"vmi1.ns1",
+			map[string]string{"nic1": "nic1.vmi1.ns1"},
+		),
+		Entry("custom templates without namespace",
+			newCustomNaming("{{.Name}}-{{.Interface}}.{{.Label \"cluster\"}}", "{{.Name}}"),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{}, map[string]string{"cluster": "east"}),
+			"vmi1",
+			map[string]string{"nic1": "vmi1-nic1.east"},
+		),
+		Entry("custom template with network name",
+			newCustomNaming("{{.NetworkName}}.{{.Name}}", ""),
+			newNamingVMI(v1.VirtualMachineInstanceSpec{Networks: []v1.Network{
+				{Name: "nic1", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "default/nad1"}}},
+			}}, nil),
+			"vmi1.ns1",
+			map[string]string{"nic1": "nad1.vmi1"},
+		),
+	)
+
+	It("should fail to generate names when a label is missing", func() {
+		naming := newCustomNaming("{{.Interface}}.{{.Label \"cluster\"}}", "")
+		_, err := naming.generateNames(k8stypes.NamespacedName{Namespace: namespace, Name: vmiName},
+			newNamingVMI(v1.VirtualMachineInstanceSpec{}, nil))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail to generate names when the interfaces names collapse", func() {
+		naming := newCustomNaming("{{.NetworkName}}.{{.Name}}", "")
+		vmi := newNamingVMI(v1.VirtualMachineInstanceSpec{Networks: []v1.Network{
+			{Name: "nic1", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "nad1"}}},
+			{Name: "nic2", NetworkSource: v1.NetworkSource{Multus: &v1.MultusNetwork{NetworkName: "default/nad1"}}},
+		}}, nil)
+		vmi.Status.Interfaces = append(vmi.Status.Interfaces, v1.VirtualMachineInstanceNetworkInterface{IPs: []string{"1.2.3.5"}, Name: "nic2"})
+		_, err := naming.generateNames(k8stypes.NamespacedName{Namespace: namespace, Name: vmiName}, vmi)
+		Expect(err).To(MatchError(`the name "nad1.vmi1" of interface nic2 is also the name of interface nic1`))
+	})
+
+	Describe("hostname conflicts", func() {
+		const nameServerIP = "185.251.75.10"
+
+		var (
+			zoneFileCache *ZoneFileCache
+			vmi1          = k8stypes.NamespacedName{Namespace: namespace, Name: "vmi1"}
+			vmi2          = k8stypes.NamespacedName{Namespace: namespace, Name: "vmi2"}
+		)
+
+		newHostnameVMI := func(hostname string, IP string) *v1.VirtualMachineInstance {
+			return &v1.VirtualMachineInstance{
+				Spec:   v1.VirtualMachineInstanceSpec{Hostname: hostname},
+				Status: v1.VirtualMachineInstanceStatus{Interfaces: []v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IP}, Name: "nic1"}}},
+			}
+		}
+
+		BeforeEach(func() {
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeHostname, domain), nil)
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, newHostnameVMI("host1", "1.2.3.4")).IsChanged()).To(BeTrue())
+		})
+
+		It("should not publish a VMI claiming a used hostname", func() {
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeFalse())
+			owner, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+			Expect(isConflicted).To(BeTrue())
+			Expect(owner).To(Equal(vmi1))
+			_, isPublished := zoneFileCache.GetInterfacesNames(vmi2)
+			Expect(isPublished).To(BeFalse())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).ToNot(ContainSubstring("5.6.7.8"))
+		})
+
+		It("should keep publishing the VMI that owns the hostname", func() {
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeFalse())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, newHostnameVMI("host1", "1.2.3.5")).IsChanged()).To(BeTrue())
+			_, isConflicted := zoneFileCache.GetNameConflict(vmi1)
+			Expect(isConflicted).To(BeFalse())
+			interfaceNames, isPublished := zoneFileCache.GetInterfacesNames(vmi1)
+			Expect(isPublished).To(BeTrue())
+			Expect(interfaceNames).To(Equal(map[string]string{"nic1": "nic1.host1.ns1"}))
+		})
+
+		It("should publish the VMI once the hostname is released", func() {
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeFalse())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, nil).IsChanged()).To(BeTrue())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeTrue())
+			_, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+			Expect(isConflicted).To(BeFalse())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).To(Equal("host1.ns1 IN A 5.6.7.8\nnic1.host1.ns1 IN A 5.6.7.8\n"))
+		})
+
+		It("should withdraw the records of a VMI whose hostname is changed to a used one", func() {
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host2", "5.6.7.8")).IsChanged()).To(BeTrue())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeTrue())
+			_, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+			Expect(isConflicted).To(BeTrue())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).To(Equal("host1.ns1 IN A 1.2.3.4\nnic1.host1.ns1 IN A 1.2.3.4\n"))
+		})
+
+		It("should not publish a VMI whose interface name is used by another VMI", func() {
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newCustomNaming("{{.Name}}-{{.Interface}}", "{{.Name}}"), nil)
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, newHostnameVMI("", "1.2.3.4")).IsChanged()).To(BeTrue())
+			vmi3 := k8stypes.NamespacedName{Namespace: "ns2", Name: "vmi1"}
+			Expect(zoneFileCache.UpdateVMIRecords(vmi3, newHostnameVMI("", "5.6.7.8")).IsChanged()).To(BeFalse())
+			owner, isConflicted := zoneFileCache.GetNameConflict(vmi3)
+			Expect(isConflicted).To(BeTrue())
+			Expect(owner).To(Equal(vmi1))
+		})
+
+		It("should not publish a VMI named like a name server glue record", func() {
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newCustomNaming("{{.Name}}-{{.Interface}}", "{{.Name}}"), nil)
+			glueVMI := k8stypes.NamespacedName{Namespace: namespace, Name: "ns"}
+			Expect(zoneFileCache.UpdateVMIRecords(glueVMI, newHostnameVMI("", "5.6.7.8")).IsChanged()).To(BeFalse())
+			owner, isConflicted := zoneFileCache.GetNameConflict(glueVMI)
+			Expect(isConflicted).To(BeTrue())
+			Expect(owner).To(Equal(NameServersOwner))
+			Expect(zoneFileCache.Flush()).To(BeFalse())
+			Expect(zoneFileCache.header).To(HaveSuffix("ns IN A " + nameServerIP + "\n"))
+		})
+
+		It("should not publish a VMI whose name differs from a used name by case only", func() {
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newCustomNaming("{{.Name}}-{{.Interface}}", "{{.Label \"host\"}}"), nil)
+			vmiObject := newHostnameVMI("", "1.2.3.4")
+			vmiObject.Labels = map[string]string{"host": "host1"}
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, vmiObject).IsChanged()).To(BeTrue())
+			vmiObject = newHostnameVMI("", "5.6.7.8")
+			vmiObject.Labels = map[string]string{"host": "HOST1"}
+			Expect(zoneFileCache.UpdateVMIRecords(vmi2, vmiObject).IsChanged()).To(BeFalse())
+			owner, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+			Expect(isConflicted).To(BeTrue())
+			Expect(owner).To(Equal(vmi1))
+		})
+
+		It("should not publish a VMI with invalid names", func() {
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newCustomNaming("", "{{.Name}}.{{.Label \"cluster\"}}"), nil)
+			Expect(zoneFileCache.UpdateVMIRecords(vmi1, newHostnameVMI("", "1.2.3.4")).IsChanged()).To(BeFalse())
+			Expect(zoneFileCache.GetInvalidNameError(vmi1)).To(HaveOccurred())
+			_, isPublished := zoneFileCache.GetInterfacesNames(vmi1)
+			Expect(isPublished).To(BeFalse())
+		})
+
+		It("should allow the same hostname in different namespaces", func() {
+			vmi3 := k8stypes.NamespacedName{Namespace: "ns2", Name: "vmi2"}
+			Expect(zoneFileCache.UpdateVMIRecords(vmi3, newHostnameVMI("host1", "5.6.7.8")).IsChanged()).To(BeTrue())
+			_, isConflicted := zoneFileCache.GetNameConflict(vmi3)
+			Expect(isConflicted).To(BeFalse())
+		})
+	})
+})
+
+func newCustomNaming(interfaceTemplate, defaultTemplate string) *Naming {
+	naming, err := NewNaming(NamingModeName, interfaceTemplate, defaultTemplate, "", "vm.domain.com")
+	Expect(err).ToNot(HaveOccurred())
+	return naming
+}
+
+func newNamingVMI(spec v1.VirtualMachineInstanceSpec, labels map[string]string) *v1.VirtualMachineInstance {
+	vmi := &v1.VirtualMachineInstance{Spec: spec}
+	vmi.Labels = labels
+	vmi.Status.Interfaces = []v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"1.2.3.4"}, Name: "nic1"}}
+	return vmi
+}
@@ -0,0 +1,317 @@
+package zone_file_cache
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// recordLineOverhead is the length of the separators of a record line, i.e. "<name> [<ttl>] IN <type> <data>\n",
+// without the TTL
+const recordLineOverhead = len(" IN ") + len(" ") + len("\n")
+
+// canonicalLabelSeparator separates the labels of a record canonical name, it sorts before any label character, so a
+// label sorts before the longer labels it prefixes
+const canonicalLabelSeparator = "\x00"
+
+// Record is a resource record of a zone, its name is relative to the zone origin. A record without a TTL has the TTL of
+// the zone.
+type Record struct {
+	name       string
+	ttl        string
+	recordType string
+	data       string
+
+	// canonicalName and typeCode are the sort keys of the record
+	canonicalName string
+	typeCode      uint16
+}
+
+func newRecord(name string, recordType string, data string) Record {
+	return Record{
+		name:          name,
+		recordType:    recordType,
+		data:          data,
+		canonicalName: toCanonicalName(name),
+		typeCode:      dns.StringToType[recordType],
+	}
+}
+
+// toCanonicalName returns the name labels in reverse order, lower cased, so comparing the canonical names compares the
+// names label by label starting with the rightmost label, as the DNSSEC canonical order does (RFC 4034 section 6.1)
+func toCanonicalName(name string) string {
+	labels := strings.Split(strings.ToLower(name), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, canonicalLabelSeparator)
+}
+
+// Name returns the owner name of the record, relative to the zone origin
+func (record Record) Name() string {
+	return record.name
+}
+
+// Type returns the type of the record, e.g. "A"
+func (record Record) Type() string {
+	return record.recordType
+}
+
+// TTL returns the TTL of the record, and false when the record has the TTL of the zone
+func (record Record) TTL() (uint32, bool) {
+	if record.ttl == "" {
+		return 0, false
+	}
+	ttl, err := strconv.ParseUint(record.ttl, 10, 32)
+	return uint32(ttl), err == nil
+}
+
+// Data returns the data of the record, in the zone file format
+func (record Record) Data() string {
+	return record.data
+}
+
+// withTTL returns the record with an explicit TTL
+func (record Record) withTTL(ttl uint32) Record {
+	record.ttl = strconv.FormatUint(uint64(ttl), 10)
+	return record
+}
+
+// String returns the record line in the zone file format
+func (record Record) String() string {
+	var line strings.Builder
+	line.Grow(record.lineLength())
+	record.writeTo(&line)
+	return line.String()
+}
+
+func (record Record) lineLength() int {
+	lineLength := len(record.name) + len(record.recordType) + len(record.data) + recordLineOverhead
+	if record.ttl != "" {
+		lineLength += len(" ") + len(record.ttl)
+	}
+	return lineLength
+}
+
+func (record Record) writeTo(builder *strings.Builder) {
+	builder.WriteString(record.name)
+	if record.ttl != "" {
+		builder.WriteString(" ")
+		builder.WriteString(record.ttl)
+	}
+	builder.WriteString(" IN ")
+	builder.WriteString(record.recordType)
+	builder.WriteString(" ")
+	builder.WriteString(record.data)
+	builder.WriteString("\n")
+}
+
+// isRecordLess orders the records canonically, by owner name and then by type, as DNSSEC does. The records of the
+// same name and type are ordered by their data and TTL, so the order is stable.
+func isRecordLess(record, other Record) bool {
+	if record.canonicalName != other.canonicalName {
+		return record.canonicalName < other.canonicalName
+	}
+	if record.typeCode != other.typeCode {
+		return record.typeCode < other.typeCode
+	}
+	if record.name != other.name {
+		return record.name < other.name
+	}
+	if record.data != other.data {
+		return record.data < other.data
+	}
+	return record.ttl < other.ttl
+}
+
+func sortZoneRecords(records []Record) {
+	sort.Slice(records, func(i, j int) bool {
+		return isRecordLess(records[i], records[j])
+	})
+}
+
+// recordStore holds the records of each VMI along with all the zone records, sorted. A VMI update is recorded as
+// pending additions and removals, that are merged into the sorted records on render. Therefore, an update costs the
+// size of the VMI records only, and a render costs a single pass over the zone records, into a buffer of the known
+// zone size.
+// The records are also indexed by name, so the records of a name are looked up as soon as they are set.
+type recordStore struct {
+	vmiRecords     map[string][]Record
+	sortedRecords  []Record
+	addedRecords   []Record
+	removedRecords map[Record]int
+	linesLength    int
+
+	// nameRecords holds the records of each lower cased name, and descendantsCount counts the records below each
+	// name, so a name without records that has records below it is known to exist, as an empty non-terminal
+	nameRecords      map[string][]Record
+	descendantsCount map[string]int
+}
+
+func newRecordStore() *recordStore {
+	return &recordStore{
+		vmiRecords:       map[string][]Record{},
+		removedRecords:   map[Record]int{},
+		nameRecords:      map[string][]Record{},
+		descendantsCount: map[string]int{},
+	}
+}
+
+// set replaces the records of the VMI, empty records remove the VMI from the store. It returns the change of the
+// records of the VMI, only the added and the removed records are applied to the store.
+func (store *recordStore) set(key string, records []Record) ChangeSet {
+	sortZoneRecords(records)
+	changeSet := diffRecords(store.vmiRecords[key], records)
+	if !changeSet.IsChanged() {
+		return changeSet
+	}
+
+	store.remove(changeSet.Removed)
+	if len(records) == 0 {
+		delete(store.vmiRecords, key)
+	} else {
+		store.vmiRecords[key] = records
+	}
+	store.addedRecords = append(store.addedRecords, changeSet.Added...)
+	for _, record := range changeSet.Added {
+		store.linesLength += record.lineLength()
+		store.index(record)
+	}
+	return changeSet
+}
+
+func (store *recordStore) remove(records []Record) {
+	for _, record := range records {
+		store.removedRecords[record]++
+		store.linesLength -= record.lineLength()
+		store.unindex(record)
+	}
+}
+
+func (store *recordStore) index(record Record) {
+	name := strings.ToLower(record.name)
+	store.nameRecords[name] = append(store.nameRecords[name], record)
+	for ancestor, hasAncestor := parentName(name); hasAncestor; ancestor, hasAncestor = parentName(ancestor) {
+		store.descendantsCount[ancestor]++
+	}
+}
+
+func (store *recordStore) unindex(record Record) {
+	name := strings.ToLower(record.name)
+	nameRecords := store.nameRecords[name]
+	for i := range nameRecords {
+		if nameRecords[i] == record {
+			nameRecords = append(nameRecords[:i], nameRecords[i+1:]...)
+			break
+		}
+	}
+	if len(nameRecords) == 0 {
+		delete(store.nameRecords, name)
+	} else {
+		store.nameRecords[name] = nameRecords
+	}
+	for ancestor, hasAncestor := parentName(name); hasAncestor; ancestor, hasAncestor = parentName(ancestor) {
+		if store.descendantsCount[ancestor]--; store.descendantsCount[ancestor] == 0 {
+			delete(store.descendantsCount, ancestor)
+		}
+	}
+}
+
+// parentName returns the name without its leftmost label, a single label name has no parent within the zone
+func parentName(name string) (string, bool) {
+	_, parent, hasParent := strings.Cut(name, ".")
+	return parent, hasParent
+}
+
+// lookup returns the records of the name, relative to the zone origin, and whether the name exists, i.e. whether it
+// has records or records below it. The records are ordered canonically.
+func (store *recordStore) lookup(name string) ([]Record, bool) {
+	name = strings.ToLower(name)
+	records := append([]Record{}, store.nameRecords[name]...)
+	sortZoneRecords(records)
+	return records, len(records) > 0 || store.descendantsCount[name] > 0
+}
+
+// merge applies the pending additions and removals to the sorted records, and returns the records that were added and
+// the records that were removed since the last merge, in canonical order
+func (store *recordStore) merge() ([]Record, []Record) {
+	if len(store.addedRecords) == 0 && len(store.removedRecords) == 0 {
+		return nil, nil
+	}
+
+	// A record that was added and removed since the last merge is not part of the sorted records
+	addedRecords := store.addedRecords[:0]
+	for _, record := range store.addedRecords {
+		if store.removedRecords[record] > 0 {
+			store.removedRecords[record]--
+			continue
+		}
+		addedRecords = append(addedRecords, record)
+	}
+	sortZoneRecords(addedRecords)
+
+	// The records are located by a binary search and the untouched ranges in between are copied as is
+	keptRecords := store.sortedRecords
+	var removedRecords []Record
+	if len(store.removedRecords) > 0 {
+		keptRecords = make([]Record, 0, len(store.sortedRecords))
+		start := 0
+		for _, removedIdx := range store.findRemovedRecords() {
+			keptRecords = append(keptRecords, store.sortedRecords[start:removedIdx]...)
+			removedRecords = append(removedRecords, store.sortedRecords[removedIdx])
+			start = removedIdx + 1
+		}
+		keptRecords = append(keptRecords, store.sortedRecords[start:]...)
+	}
+
+	mergedRecords := make([]Record, 0, len(keptRecords)+len(addedRecords))
+	start := 0
+	for _, record := range addedRecords {
+		end := start + sort.Search(len(keptRecords)-start, func(i int) bool {
+			return !isRecordLess(keptRecords[start+i], record)
+		})
+		mergedRecords = append(mergedRecords, keptRecords[start:end]...)
+		mergedRecords = append(mergedRecords, record)
+		start = end
+	}
+	mergedRecords = append(mergedRecords, keptRecords[start:]...)
+
+	store.sortedRecords = mergedRecords
+	store.addedRecords = nil
+	store.removedRecords = map[Record]int{}
+	return addedRecords, removedRecords
+}
+
+// findRemovedRecords returns the sorted indexes of the removed records in the sorted records
+func (store *recordStore) findRemovedRecords() []int {
+	var removedIdxs []int
+	for record, count := range store.removedRecords {
+		idx := sort.Search(len(store.sortedRecords), func(i int) bool {
+			return !isRecordLess(store.sortedRecords[i], record)
+		})
+		for ; count > 0 && idx < len(store.sortedRecords) && store.sortedRecords[idx] == record; count-- {
+			removedIdxs = append(removedIdxs, idx)
+			idx++
+		}
+	}
+	sort.Ints(removedIdxs)
+	return removedIdxs
+}
+
+// render returns the lines of all the records, in canonical order
+func (store *recordStore) render() string {
+	store.merge()
+	return joinRecords(store.sortedRecords, store.linesLength)
+}
+
+// joinRecords returns the lines of the records, the lines length is a hint of their total size
+func joinRecords(records []Record, linesLength int) string {
+	var lines strings.Builder
+	lines.Grow(linesLength)
+	for _, record := range records {
+		record.writeTo(&lines)
+	}
+	return lines.String()
+}
@@ -0,0 +1,103 @@
+package zone_file_cache
+
+import (
+	"sort"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("record store", func() {
+	var store *recordStore
+
+	BeforeEach(func() {
+		store = newRecordStore()
+	})
+
+	It("should render the records of all the VMIs in canonical order", func() {
+		Expect(store.set("vmi2_ns1", []Record{
+			newRecord("vmi2.ns1", recordTypeA, "10.0.0.2"),
+			newRecord("nic1.vmi2.ns1", recordTypeA, "10.0.0.2"),
+		}).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi1_ns1", []Record{
+			newRecord("vmi1.ns1", recordTypeAAAA, "2001:db8::1"),
+			newRecord("vmi1.ns1", recordTypeA, "10.0.0.1"),
+		}).IsChanged()).To(BeTrue())
+
+		Expect(store.render()).To(Equal(
+			"vmi1.ns1 IN A 10.0.0.1\n" +
+				"vmi1.ns1 IN AAAA 2001:db8::1\n" +
+				"vmi2.ns1 IN A 10.0.0.2\n" +
+				"nic1.vmi2.ns1 IN A 10.0.0.2\n"))
+	})
+
+	It("should report whether the VMI records were changed", func() {
+		records := []Record{newRecord("vmi1.ns1", recordTypeA, "10.0.0.1"), newRecord("nic1.vmi1.ns1", recordTypeA, "10.0.0.1")}
+		Expect(store.set("vmi1_ns1", records).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi1_ns1", []Record{records[1], records[0]}).IsChanged()).To(BeFalse())
+		Expect(store.set("vmi2_ns1", nil).IsChanged()).To(BeFalse())
+
+		Expect(store.set("vmi1_ns1", nil).IsChanged()).To(BeTrue())
+		Expect(store.render()).To(BeEmpty())
+		Expect(store.linesLength).To(BeZero())
+	})
+
+	It("should render the records that were replaced since the last render", func() {
+		Expect(store.set("vmi1_ns1", []Record{newRecord("vmi1.ns1", recordTypeA, "10.0.0.1")}).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi2_ns1", []Record{newRecord("vmi2.ns1", recordTypeA, "10.0.0.2")}).IsChanged()).To(BeTrue())
+		Expect(store.render()).To(Equal("vmi1.ns1 IN A 10.0.0.1\nvmi2.ns1 IN A 10.0.0.2\n"))
+
+		Expect(store.set("vmi3_ns1", []Record{newRecord("vmi3.ns1", recordTypeA, "10.0.0.3")}).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi3_ns1", nil).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi1_ns1", []Record{newRecord("vmi1.ns1", recordTypeA, "10.0.0.4")}).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi1_ns1", []Record{newRecord("vmi1.ns1", recordTypeA, "10.0.0.1"),
+			newRecord("nic1.vmi1.ns1", recordTypeA, "10.0.0.1")}).IsChanged()).To(BeTrue())
+		Expect(store.render()).To(Equal(
+			"vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi1.ns1 IN A 10.0.0.1\n" +
+				"vmi2.ns1 IN A 10.0.0.2\n"))
+	})
+
+	DescribeTable("should order the records canonically", func(record, other Record) {
+		Expect(isRecordLess(record, other)).To(BeTrue())
+		Expect(isRecordLess(other, record)).To(BeFalse())
+	},
+		Entry("by the rightmost label first",
+			newRecord("b.ns1", recordTypeA, "10.0.0.1"), newRecord("a.ns2", recordTypeA, "10.0.0.1")),
+		Entry("a name before the names below it",
+			newRecord("vmi1.ns1", recordTypeA, "10.0.0.1"), newRecord("nic1.vmi1.ns1", recordTypeA, "10.0.0.1")),
+		Entry("a label before the longer labels it prefixes",
+			newRecord("vm.ns1", recordTypeA, "10.0.0.1"), newRecord("vm-1.ns1", recordTypeA, "10.0.0.1")),
+		Entry("names ignoring the case",
+			newRecord("A.ns1", recordTypeA, "10.0.0.1"), newRecord("b.ns1", recordTypeA, "10.0.0.1")),
+		Entry("by the numeric type after the name",
+			newRecord("vmi1.ns1", recordTypeTXT, "\"uid=1234\""), newRecord("vmi1.ns1", recordTypeAAAA, "2001:db8::1")),
+		Entry("by the data after the type",
+			newRecord("vmi1.ns1", recordTypeA, "10.0.0.1"), newRecord("vmi1.ns1", recordTypeA, "10.0.0.2")),
+	)
+
+	It("should track the rendered length of the records", func() {
+		Expect(store.set("vmi1_ns1", []Record{newRecord("vmi1.ns1", recordTypeA, "10.0.0.1")}).IsChanged()).To(BeTrue())
+		Expect(store.set("vmi1_ns1", []Record{newRecord("vmi1.ns1", recordTypeAAAA, "2001:db8::1")}).IsChanged()).To(BeTrue())
+		Expect(store.linesLength).To(Equal(len(store.render())))
+	})
+})
+
+// renderRecords returns the lines of the records, sorted
+func renderRecords(records []Record) []string {
+	var lines []string
+	for _, record := range records {
+		lines = append(lines, record.String())
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// renderRecordsMap returns the lines of the records of each zone, sorted
+func renderRecordsMap(recordsMap map[string][]Record) map[string][]string {
+	linesMap := map[string][]string{}
+	for origin, records := range recordsMap {
+		linesMap[origin] = renderRecords(records)
+	}
+	return linesMap
+}
@@ -0,0 +1,113 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	netutils "k8s.io/utils/net"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+const (
+	recordTypePTR = "PTR"
+
+	ipv4ReverseZoneSuffix = "in-addr.arpa"
+	ipv6ReverseZoneSuffix = "ip6.arpa"
+)
+
+// ValidateIPv4ReverseZonePrefixLength checks that the reverse zones can be delegated on the given prefix length,
+// in-addr.arpa zones are split on octet boundaries only
+func ValidateIPv4ReverseZonePrefixLength(prefixLength int) error {
+	if prefixLength <= 0 || prefixLength >= net.IPv4len*8 || prefixLength%8 != 0 {
+		return fmt.Errorf("invalid IPv4 reverse zone prefix length %d, supported values are 8, 16 and 24", prefixLength)
+	}
+	return nil
+}
+
+// ValidateIPv6ReverseZonePrefixLength checks that the reverse zones can be delegated on the given prefix length,
+// ip6.arpa zones are split on nibble boundaries only
+func ValidateIPv6ReverseZonePrefixLength(prefixLength int) error {
+	if prefixLength <= 0 || prefixLength >= net.IPv6len*8 || prefixLength%4 != 0 {
+		return fmt.Errorf("invalid IPv6 reverse zone prefix length %d, supported values are multiples of 4 between 4 and 124", prefixLength)
+	}
+	return nil
+}
+
+// IsReverseZone returns whether the zone origin is under in-addr.arpa or ip6.arpa
+func IsReverseZone(origin string) bool {
+	origin = strings.ToLower(strings.TrimSuffix(origin, "."))
+	return strings.HasSuffix(origin, "."+ipv4ReverseZoneSuffix) || strings.HasSuffix(origin, "."+ipv6ReverseZoneSuffix)
+}
+
+// BuildPTRRecords returns the PTR records of the VMI interfaces IPs grouped by the origin of their reverse zone.
+// Each PTR record points back to the interface name, relative to the forward zone of the given domain.
+// Interfaces with no name are skipped. A zero prefix length disables the reverse zones of the IP family.
+func BuildPTRRecords(interfaceNames map[string]string, domain string, interfaces []v1.VirtualMachineInstanceNetworkInterface,
+	ipv4PrefixLength int, ipv6PrefixLength int) map[string][]Record {
+	ptrRecordsMap := map[string][]Record{}
+	addPTRRecord := func(origin string, owner string, fqdn string) {
+		ptrRecordsMap[origin] = append(ptrRecordsMap[origin], newRecord(owner, recordTypePTR, fqdn))
+	}
+	for _, iface := range interfaces {
+		interfaceName, exists := interfaceNames[iface.Name]
+		if !exists {
+			continue
+		}
+		fqdn := fmt.Sprintf("%s.%s.", interfaceName, domain)
+		if ipv4PrefixLength != 0 {
+			for _, IP := range getPublishableIPs(iface.IPs, netutils.IsIPv4String) {
+				origin, owner := splitIPv4ReverseName(net.ParseIP(IP).To4(), ipv4PrefixLength)
+				addPTRRecord(origin, owner, fqdn)
+			}
+		}
+		if ipv6PrefixLength != 0 {
+			for _, IP := range getPublishableIPs(iface.IPs, netutils.IsIPv6String) {
+				origin, owner := splitIPv6ReverseName(net.ParseIP(IP).To16(), ipv6PrefixLength)
+				addPTRRecord(origin, owner, fqdn)
+			}
+		}
+	}
+	return ptrRecordsMap
+}
+
+// splitIPv4ReverseName returns the origin of the reverse zone that contains the IP, and the IP owner name relative
+// to that origin, e.g. 10.0.0.5 with prefix length 24 is split into "0.0.10.in-addr.arpa" and "5"
+func splitIPv4ReverseName(IP net.IP, prefixLength int) (string, string) {
+	zoneOctets := prefixLength / 8
+	var originLabels, ownerLabels []string
+	for i := len(IP) - 1; i >= 0; i-- {
+		label := strconv.Itoa(int(IP[i]))
+		if i < zoneOctets {
+			originLabels = append(originLabels, label)
+		} else {
+			ownerLabels = append(ownerLabels, label)
+		}
+	}
+	originLabels = append(originLabels, ipv4ReverseZoneSuffix)
+	return strings.Join(originLabels, "."), strings.Join(ownerLabels, ".")
+}
+
+// splitIPv6ReverseName returns the origin of the nibble format reverse zone that contains the IP, and the IP owner name
+// relative to that origin, e.g. 2001:db8::1 with prefix length 64 is split into
+// "0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa" and "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0"
+func splitIPv6ReverseName(IP net.IP, prefixLength int) (string, string) {
+	zoneNibbles := prefixLength / 4
+	var originLabels, ownerLabels []string
+	for i := len(IP)*2 - 1; i >= 0; i-- {
+		nibble := IP[i/2] >> 4
+		if i%2 == 1 {
+			nibble = IP[i/2] & 0x0f
+		}
+		label := strconv.FormatUint(uint64(nibble), 16)
+		if i < zoneNibbles {
+			originLabels = append(originLabels, label)
+		} else {
+			ownerLabels = append(ownerLabels, label)
+		}
+	}
+	originLabels = append(originLabels, ipv6ReverseZoneSuffix)
+	return strings.Join(originLabels, "."), strings.Join(ownerLabels, ".")
+}
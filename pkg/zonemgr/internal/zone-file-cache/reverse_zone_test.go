@@ -0,0 +1,176 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("reverse zones maintenance", func() {
+	const (
+		domain       = "vm.domain.com"
+		nameServerIP = "185.251.75.10"
+	)
+
+	var interfaceNames = map[string]string{"nic1": "nic1.vmi1.ns1", "nic2": "nic2.vmi1.ns1"}
+
+	DescribeTable("validate IPv4 reverse zone prefix length", func(prefixLength int, expectedValid bool) {
+		err := ValidateIPv4ReverseZonePrefixLength(prefixLength)
+		if expectedValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("8 is valid", 8, true),
+		Entry("16 is valid", 16, true),
+		Entry("24 is valid", 24, true),
+		Entry("0 is invalid", 0, false),
+		Entry("32 is invalid", 32, false),
+		Entry("non octet boundary is invalid", 20, false),
+	)
+
+	DescribeTable("validate IPv6 reverse zone prefix length", func(prefixLength int, expectedValid bool) {
+		err := ValidateIPv6ReverseZonePrefixLength(prefixLength)
+		if expectedValid {
+			Expect(err).ToNot(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	},
+		Entry("48 is valid", 48, true),
+		Entry("64 is valid", 64, true),
+		Entry("nibble boundary is valid", 52, true),
+		Entry("0 is invalid", 0, false),
+		Entry("128 is invalid", 128, false),
+		Entry("non nibble boundary is invalid", 50, false),
+	)
+
+	DescribeTable("identify reverse zones", func(origin string, expectedReverseZone bool) {
+		Expect(IsReverseZone(origin)).To(Equal(expectedReverseZone))
+	},
+		Entry("in-addr.arpa zone", "0.0.10.in-addr.arpa", true),
+		Entry("absolute ip6.arpa zone", "8.b.d.0.1.0.0.2.IP6.ARPA.", true),
+		Entry("forward zone", "vm.domain.com", false),
+		Entry("forward zone named like a reverse zone", "vm.in-addr.arpa.domain.com", false),
+	)
+
+	DescribeTable("build PTR records", func(interfaces []v1.VirtualMachineInstanceNetworkInterface, prefixLength int,
+		expectedPTRRecords map[string][]string) {
+		Expect(renderRecordsMap(BuildPTRRecords(interfaceNames, domain, interfaces, prefixLength, 0))).To(Equal(expectedPTRRecords))
+	},
+		Entry("when there are no interfaces", nil, 24, map[string][]string{}),
+		Entry("when interfaces IPs are in the same /24 zone",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5", "10.0.0.6"}, Name: "nic1"}, {IPs: []string{"10.0.0.7"}, Name: "nic2"}},
+			24,
+			map[string][]string{"0.0.10.in-addr.arpa": {
+				"5 IN PTR nic1.vmi1.ns1.vm.domain.com.\n",
+				"6 IN PTR nic1.vmi1.ns1.vm.domain.com.\n",
+				"7 IN PTR nic2.vmi1.ns1.vm.domain.com.\n",
+			}},
+		),
+		Entry("when interfaces IPs are in different /24 zones",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}, {IPs: []string{"10.0.1.5"}, Name: "nic2"}},
+			24,
+			map[string][]string{
+				"0.0.10.in-addr.arpa": {"5 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+				"1.0.10.in-addr.arpa": {"5 IN PTR nic2.vmi1.ns1.vm.domain.com.\n"},
+			},
+		),
+		Entry("when the zones are split on /16",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}, {IPs: []string{"10.0.1.5"}, Name: "nic2"}},
+			16,
+			map[string][]string{"0.10.in-addr.arpa": {
+				"5.0 IN PTR nic1.vmi1.ns1.vm.domain.com.\n",
+				"5.1 IN PTR nic2.vmi1.ns1.vm.domain.com.\n",
+			}},
+		),
+		Entry("when interfaces contain IPv6 and non global IPs",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"fe80::1", "2001:db8::1", "127.0.0.1", "192.168.1.1"}, Name: "nic1"}},
+			8,
+			map[string][]string{"192.in-addr.arpa": {"1.1.168 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"}},
+		),
+	)
+
+	DescribeTable("build IPv6 PTR records", func(interfaces []v1.VirtualMachineInstanceNetworkInterface, prefixLength int,
+		expectedPTRRecords map[string][]string) {
+		Expect(renderRecordsMap(BuildPTRRecords(interfaceNames, domain, interfaces, 0, prefixLength))).To(Equal(expectedPTRRecords))
+	},
+		Entry("when the zones are split on /64",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"2001:db8::1", "2001:db8:0:1::a"}, Name: "nic1"}},
+			64,
+			map[string][]string{
+				"0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": {"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+				"1.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": {"a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+			},
+		),
+		Entry("when the zones are split on /48",
+			[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"2001:db8::1", "10.0.0.5"}, Name: "nic1"}, {IPs: []string{"2001:db8:0:1::a", "fe80::1"}, Name: "nic2"}},
+			48,
+			map[string][]string{"0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": {
+				"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR nic1.vmi1.ns1.vm.domain.com.\n",
+				"a.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1.0.0.0 IN PTR nic2.vmi1.ns1.vm.domain.com.\n",
+			}},
+		),
+	)
+
+	It("should skip interfaces with no name", func() {
+		interfaces := []v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}, {IPs: []string{"10.0.0.6"}, Name: "nic3"}}
+		Expect(renderRecordsMap(BuildPTRRecords(interfaceNames, domain, interfaces, 24, 0))).To(Equal(map[string][]string{
+			"0.0.10.in-addr.arpa": {"5 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+		}))
+	})
+
+	It("should build PTR records of both IP families", func() {
+		interfaces := []v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5", "2001:db8::1"}, Name: "nic1"}}
+		Expect(renderRecordsMap(BuildPTRRecords(interfaceNames, domain, interfaces, 24, 64))).To(Equal(map[string][]string{
+			"0.0.10.in-addr.arpa":                      {"5 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+			"0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": {"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"},
+		}))
+	})
+
+	Describe("reverse zone file cache", func() {
+		const origin = "0.0.10.in-addr.arpa"
+
+		It("should generate header with forward zone name server and no glue record", func() {
+			zoneFileCache := NewReverseZoneFileCache(nameServerIP, domain, origin, nil, SerialSchemeCounter, nil)
+			Expect(zoneFileCache.header).To(Equal("$ORIGIN 0.0.10.in-addr.arpa. \n$TTL 3600 \n" +
+				"@ IN SOA ns.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n@ IN NS ns.vm.domain.com.\n"))
+		})
+
+		It("should update the VMI PTR records", func() {
+			soaSerial := 7
+			zoneFileCache := NewReverseZoneFileCache(nameServerIP, domain, origin, &soaSerial, SerialSchemeCounter, nil)
+			namespacedName := k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+			ptrRecords := []Record{newRecord("5", recordTypePTR, "nic1.vmi1.ns1.vm.domain.com.")}
+
+			Expect(zoneFileCache.UpdateVMIPTRRecords(namespacedName, nil, ptrRecords).IsChanged()).To(BeTrue())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).To(Equal("5 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"))
+			Expect(zoneFileCache.soaSerial).To(Equal(uint32(8)))
+
+			Expect(zoneFileCache.UpdateVMIPTRRecords(namespacedName, nil, ptrRecords).IsChanged()).To(BeFalse())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.soaSerial).To(Equal(uint32(8)))
+
+			Expect(zoneFileCache.UpdateVMIPTRRecords(namespacedName, nil, nil).IsChanged()).To(BeTrue())
+			zoneFileCache.Flush()
+			Expect(zoneFileCache.aRecords).To(BeEmpty())
+			Expect(zoneFileCache.soaSerial).To(Equal(uint32(9)))
+		})
+
+		It("should regenerate the content of a zone marked as changed", func() {
+			soaSerial := 7
+			zoneFileCache := NewReverseZoneFileCache(nameServerIP, domain, origin, &soaSerial, SerialSchemeCounter, nil)
+			Expect(zoneFileCache.Flush()).To(BeFalse())
+
+			zoneFileCache.MarkChanged()
+			Expect(zoneFileCache.Flush()).To(BeTrue())
+			Expect(zoneFileCache.aRecords).To(BeEmpty())
+			Expect(zoneFileCache.soaSerial).To(Equal(uint32(8)))
+			Expect(zoneFileCache.Flush()).To(BeFalse())
+		})
+	})
+})
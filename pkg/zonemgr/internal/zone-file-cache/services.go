@@ -0,0 +1,139 @@
+package zone_file_cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// ServicesAnnotation is the VMI annotation that declares the services advertised by the VMI, as a JSON list, e.g.
+// [{"service": "etcd-server", "protocol": "tcp", "port": 2380, "priority": 10, "weight": 100, "interface": "nic1"}]
+// Each service is published as _<service>._<protocol>.<parent> SRV record, where <parent> is the VMI default name without
+// its first label, e.g. the namespace in the default layout. The record targets the interface name, or the VMI default
+// name when the interface is omitted. A service may be declared once per protocol.
+const ServicesAnnotation = "secondarydns.kubevirt.io/services"
+
+const recordTypeSRV = "SRV"
+
+const (
+	serviceNameFmt = "_%s._%s"
+
+	maxServicePort     = 65535
+	maxServicePriority = 65535
+	maxServiceWeight   = 65535
+)
+
+var serviceProtocols = []string{"tcp", "udp", "sctp"}
+
+// vmiService is a service entry of the VMI services annotation
+type vmiService struct {
+	Service   string `json:"service"`
+	Protocol  string `json:"protocol"`
+	Port      int    `json:"port"`
+	Priority  int    `json:"priority"`
+	Weight    int    `json:"weight"`
+	Interface string `json:"interface,omitempty"`
+}
+
+// serviceRecord holds the fields of a VMI SRV record, the names are relative to the zone domain
+type serviceRecord struct {
+	name     string
+	priority int
+	weight   int
+	port     int
+	target   string
+}
+
+// generateServiceRecords returns the SRV records of the services advertised by the VMI. Unlike the other VMI names,
+// a service name is shared by all the VMIs that advertise the service, therefore it is not claimed by a single VMI.
+func generateServiceRecords(vmi *v1.VirtualMachineInstance, names vmiNames, domain string) ([]serviceRecord, error) {
+	annotation, exists := vmi.Annotations[ServicesAnnotation]
+	if !exists {
+		return nil, nil
+	}
+
+	var services []vmiService
+	if err := json.Unmarshal([]byte(annotation), &services); err != nil {
+		return nil, fmt.Errorf("failed to parse the %s annotation: %w", ServicesAnnotation, err)
+	}
+
+	var serviceRecords []serviceRecord
+	declaredServices := map[vmiService]bool{}
+	for _, service := range services {
+		if err := validateService(service); err != nil {
+			return nil, fmt.Errorf("invalid service %q: %w", service.Service, err)
+		}
+		serviceKey := vmiService{Service: service.Service, Protocol: service.Protocol}
+		if declaredServices[serviceKey] {
+			return nil, fmt.Errorf("invalid service %q: the service is declared more than once for protocol %q", service.Service, service.Protocol)
+		}
+		declaredServices[serviceKey] = true
+
+		target := names.defaultName
+		if service.Interface != "" {
+			interfaceTarget, isPublished := names.interfaceNames[service.Interface]
+			if !isPublished {
+				return nil, fmt.Errorf("invalid service %q: the interface %q is not reported by the VMI", service.Service, service.Interface)
+			}
+			target = interfaceTarget
+		}
+
+		serviceName := fmt.Sprintf(serviceNameFmt, service.Service, service.Protocol)
+		if _, parentName, hasParent := strings.Cut(names.defaultName, "."); hasParent {
+			serviceName += "." + parentName
+		}
+		if err := validateDNSName(serviceName, domain); err != nil {
+			return nil, fmt.Errorf("invalid service %q: %w", service.Service, err)
+		}
+		serviceRecords = append(serviceRecords, serviceRecord{
+			name:     serviceName,
+			priority: service.Priority,
+			weight:   service.Weight,
+			port:     service.Port,
+			target:   target,
+		})
+	}
+	return serviceRecords, nil
+}
+
+func validateService(service vmiService) error {
+	if errs := validation.IsValidPortName(service.Service); len(errs) > 0 {
+		return fmt.Errorf("invalid service name: %s", strings.Join(errs, ", "))
+	}
+	if !isServiceProtocol(service.Protocol) {
+		return fmt.Errorf("invalid protocol %q, supported protocols are %v", service.Protocol, serviceProtocols)
+	}
+	if service.Port < 1 || service.Port > maxServicePort {
+		return fmt.Errorf("invalid port %d, must be between 1 and %d", service.Port, maxServicePort)
+	}
+	if service.Priority < 0 || service.Priority > maxServicePriority {
+		return fmt.Errorf("invalid priority %d, must be between 0 and %d", service.Priority, maxServicePriority)
+	}
+	if service.Weight < 0 || service.Weight > maxServiceWeight {
+		return fmt.Errorf("invalid weight %d, must be between 0 and %d", service.Weight, maxServiceWeight)
+	}
+	return nil
+}
+
+func isServiceProtocol(protocol string) bool {
+	for _, serviceProtocol := range serviceProtocols {
+		if protocol == serviceProtocol {
+			return true
+		}
+	}
+	return false
+}
+
+// generateSRVRecords returns the SRV records of the services
+func generateSRVRecords(serviceRecords []serviceRecord) []Record {
+	var recordsArr []Record
+	for _, record := range serviceRecords {
+		recordsArr = append(recordsArr, newRecord(record.name, recordTypeSRV,
+			fmt.Sprintf("%d %d %d %s", record.priority, record.weight, record.port, record.target)))
+	}
+	return recordsArr
+}
@@ -0,0 +1,120 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI services", func() {
+	const domain = "vm.domain.com"
+
+	var (
+		vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+		vmi2 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi2"}
+	)
+
+	newServicesVMI := func(services string) *v1.VirtualMachineInstance {
+		vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{
+			{IPs: []string{"10.0.0.1"}, Name: "nic1"},
+			{IPs: []string{"10.0.0.2"}, Name: "nic2"},
+		})
+		vmi.Annotations = map[string]string{ServicesAnnotation: services}
+		return vmi
+	}
+
+	DescribeTable("generate service records", func(services string, expectedRecords []serviceRecord) {
+		names, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, newServicesVMI(services))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(names.services).To(Equal(expectedRecords))
+	},
+		Entry("service without interface targets the default name",
+			`[{"service": "ldap", "protocol": "tcp", "port": 389}]`,
+			[]serviceRecord{{name: "_ldap._tcp.ns1", port: 389, target: "vmi1.ns1"}},
+		),
+		Entry("service with interface targets the interface name",
+			`[{"service": "etcd-server", "protocol": "tcp", "port": 2380, "priority": 10, "weight": 100, "interface": "nic2"}]`,
+			[]serviceRecord{{name: "_etcd-server._tcp.ns1", priority: 10, weight: 100, port: 2380, target: "nic2.vmi1.ns1"}},
+		),
+		Entry("multiple services",
+			`[{"service": "consul", "protocol": "tcp", "port": 8300}, {"service": "consul-dns", "protocol": "udp", "port": 8600, "interface": "nic1"}]`,
+			[]serviceRecord{
+				{name: "_consul._tcp.ns1", port: 8300, target: "vmi1.ns1"},
+				{name: "_consul-dns._udp.ns1", port: 8600, target: "nic1.vmi1.ns1"},
+			},
+		),
+		Entry("same service with different protocols",
+			`[{"service": "dns", "protocol": "tcp", "port": 53}, {"service": "dns", "protocol": "udp", "port": 53}]`,
+			[]serviceRecord{
+				{name: "_dns._tcp.ns1", port: 53, target: "vmi1.ns1"},
+				{name: "_dns._udp.ns1", port: 53, target: "vmi1.ns1"},
+			},
+		),
+		Entry("empty list", `[]`, nil),
+	)
+
+	DescribeTable("publish the services under the parent of the VMI default name",
+		func(namingMode NamingMode, defaultTemplate string, spec v1.VirtualMachineInstanceSpec, expectedName string) {
+			naming, err := NewNaming(namingMode, "", defaultTemplate, "", domain)
+			Expect(err).ToNot(HaveOccurred())
+			vmi := newServicesVMI(`[{"service": "etcd-server", "protocol": "tcp", "port": 2380}]`)
+			vmi.Spec = spec
+			names, err := naming.generateNames(vmi1, vmi)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(names.services).To(HaveLen(1))
+			Expect(names.services[0].name).To(Equal(expectedName))
+			Expect(names.services[0].target).To(Equal(names.defaultName))
+		},
+		Entry("default layout", NamingModeName, "", v1.VirtualMachineInstanceSpec{}, "_etcd-server._tcp.ns1"),
+		Entry("hostname mode with subdomain", NamingModeHostname, "",
+			v1.VirtualMachineInstanceSpec{Hostname: "host1", Subdomain: "sub1"}, "_etcd-server._tcp.sub1.ns1"),
+		Entry("custom default template", NamingModeName, "{{.Name}}.vms.{{.Namespace}}", v1.VirtualMachineInstanceSpec{},
+			"_etcd-server._tcp.vms.ns1"),
+		Entry("single label default template, under the zone apex", NamingModeName, "{{.Name}}-{{.Namespace}}",
+			v1.VirtualMachineInstanceSpec{}, "_etcd-server._tcp"),
+	)
+
+	DescribeTable("reject invalid services", func(services string) {
+		_, err := newTestNaming(NamingModeName, domain).generateNames(vmi1, newServicesVMI(services))
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("malformed JSON", `[{"service": "ldap"`),
+		Entry("invalid service name", `[{"service": "ldap.server", "protocol": "tcp", "port": 389}]`),
+		Entry("too long service name", `[{"service": "a-very-long-service-name", "protocol": "tcp", "port": 389}]`),
+		Entry("unknown protocol", `[{"service": "ldap", "protocol": "icmp", "port": 389}]`),
+		Entry("missing port", `[{"service": "ldap", "protocol": "tcp"}]`),
+		Entry("port out of range", `[{"service": "ldap", "protocol": "tcp", "port": 65536}]`),
+		Entry("negative priority", `[{"service": "ldap", "protocol": "tcp", "port": 389, "priority": -1}]`),
+		Entry("weight out of range", `[{"service": "ldap", "protocol": "tcp", "port": 389, "weight": 65536}]`),
+		Entry("interface that is not reported", `[{"service": "ldap", "protocol": "tcp", "port": 389, "interface": "nic3"}]`),
+		Entry("service declared twice for the same protocol",
+			`[{"service": "ldap", "protocol": "tcp", "port": 389}, {"service": "ldap", "protocol": "tcp", "port": 636, "interface": "nic1"}]`),
+	)
+
+	It("should publish the services of all the VMIs that advertise them, and remove them with the VMI", func() {
+		const etcdService = `[{"service": "etcd-server", "protocol": "tcp", "port": 2380, "priority": 10, "weight": 100, "interface": "nic1"}]`
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newServicesVMI(etcdService)).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal(
+			"_etcd-server._tcp.ns1 IN SRV 10 100 2380 nic1.vmi1.ns1\n" +
+				"vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic1.vmi1.ns1 IN A 10.0.0.1\n" +
+				"nic2.vmi1.ns1 IN A 10.0.0.2\n"))
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi2, newServicesVMI(etcdService)).IsChanged()).To(BeTrue())
+		_, isConflicted := zoneFileCache.GetNameConflict(vmi2)
+		Expect(isConflicted).To(BeFalse())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(ContainSubstring("_etcd-server._tcp.ns1 IN SRV 10 100 2380 nic1.vmi1.ns1\n"))
+		Expect(zoneFileCache.aRecords).To(ContainSubstring("_etcd-server._tcp.ns1 IN SRV 10 100 2380 nic1.vmi2.ns1\n"))
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, nil).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).ToNot(ContainSubstring("nic1.vmi1.ns1\n"))
+		Expect(zoneFileCache.aRecords).To(ContainSubstring("_etcd-server._tcp.ns1 IN SRV 10 100 2380 nic1.vmi2.ns1\n"))
+	})
+})
@@ -0,0 +1,283 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	netutils "k8s.io/utils/net"
+)
+
+const (
+	nameServerDefault  = "ns"
+	adminEmailDefault  = "email"
+	refreshDefault     = 3600    // 1 hour (seconds) - how long a nameserver should wait prior to checking for a Serial Number increase within the primary zone file
+	retryDefault       = 3600    // 1 hour (seconds) - how long a nameserver should wait prior to retrying to update a zone after a failed attempt.
+	expireDefault      = 1209600 // 2 weeks (seconds) - how long a nameserver should wait prior to considering data from a secondary zone invalid and stop answering queries for that zone
+	negativeTTLDefault = 3600    // 1 hour (seconds) - the duration that a name error (NXDOMAIN) may be cached by any resolver
+	ttlDefault         = 3600    // 1 hour (seconds) - the duration that the record may be cached by any resolver
+
+	// maxTimer is the highest SOA timer and TTL, they are 31 bits values (RFC 2181)
+	maxTimer = math.MaxInt32
+
+	nameServersSeparator   = ","
+	nameServerIPsSeparator = ";"
+)
+
+// SOAParams holds the raw SOA and TTL parameters, an empty parameter is set to its default
+type SOAParams struct {
+	// NameServer is the primary name server name, relative to the zone domain, or absolute when it ends with a dot
+	NameServer string
+	// NameServers is a comma separated list of the zone authoritative name servers, each is a name server name with
+	// optional glue addresses, e.g. ns1=10.0.0.1;2001:db8::1,ns2=10.0.0.2,ns.example.com.
+	// The first name server is the primary name server, it can not be set along with NameServer.
+	NameServers string
+	// AdminEmail is the zone administrator mailbox, either as an email address, e.g. hostmaster@example.com, or as a
+	// name, relative to the zone domain, or absolute when it ends with a dot
+	AdminEmail string
+	// Refresh, Retry and Expire are the secondary servers timers, in seconds
+	Refresh string
+	Retry   string
+	Expire  string
+	// NegativeTTL is the duration, in seconds, that a negative answer may be cached, the SOA minimum field
+	NegativeTTL string
+	// TTL is the duration, in seconds, that the zone records may be cached
+	TTL string
+	// MinTTL and MaxTTL bound the TTL, in seconds, that the VMIs set to their records by annotation
+	MinTTL string
+	MaxTTL string
+}
+
+// nameServer is an authoritative name server of the zones
+type nameServer struct {
+	name string
+	IPs  []string
+}
+
+// SOA determines the SOA record, the NS records and the records TTL of the zones
+type SOA struct {
+	nameServer  string
+	nameServers []nameServer
+	adminEmail  string
+	refresh     uint32
+	retry       uint32
+	expire      uint32
+	negativeTTL uint32
+	ttl         uint32
+	minTTL      uint32
+	maxTTL      uint32
+}
+
+// NewSOA validates the SOA parameters
+func NewSOA(params SOAParams) (*SOA, error) {
+	soa := defaultSOA()
+	var err error
+	if params.NameServer != "" {
+		if err = validateSOAName(params.NameServer); err != nil {
+			return nil, fmt.Errorf("invalid name server %q: %w", params.NameServer, err)
+		}
+		soa.nameServer = params.NameServer
+	}
+	if params.NameServers != "" {
+		if params.NameServer != "" {
+			return nil, fmt.Errorf("the name servers list and the primary name server %q can not be set together", params.NameServer)
+		}
+		if soa.nameServers, err = parseNameServers(params.NameServers); err != nil {
+			return nil, err
+		}
+		soa.nameServer = soa.nameServers[0].name
+	}
+	if params.AdminEmail != "" {
+		if soa.adminEmail, err = parseAdminEmail(params.AdminEmail); err != nil {
+			return nil, fmt.Errorf("invalid admin email %q: %w", params.AdminEmail, err)
+		}
+	}
+	if soa.refresh, err = parseTimer("refresh", params.Refresh, soa.refresh, 1); err != nil {
+		return nil, err
+	}
+	if soa.retry, err = parseTimer("retry", params.Retry, soa.retry, 1); err != nil {
+		return nil, err
+	}
+	if soa.expire, err = parseTimer("expire", params.Expire, soa.expire, 1); err != nil {
+		return nil, err
+	}
+	if soa.negativeTTL, err = parseTimer("negative TTL", params.NegativeTTL, soa.negativeTTL, 0); err != nil {
+		return nil, err
+	}
+	if soa.ttl, err = parseTimer("TTL", params.TTL, soa.ttl, 0); err != nil {
+		return nil, err
+	}
+	if soa.minTTL, err = parseTimer("minimum TTL", params.MinTTL, soa.minTTL, 0); err != nil {
+		return nil, err
+	}
+	if soa.maxTTL, err = parseTimer("maximum TTL", params.MaxTTL, soa.maxTTL, 0); err != nil {
+		return nil, err
+	}
+	if soa.minTTL > soa.maxTTL {
+		return nil, fmt.Errorf("invalid minimum TTL %d, must not be greater than the maximum TTL %d", soa.minTTL, soa.maxTTL)
+	}
+	if soa.expire <= soa.refresh || soa.expire <= soa.retry {
+		return nil, fmt.Errorf("invalid expire %d, must be greater than the refresh %d and the retry %d", soa.expire,
+			soa.refresh, soa.retry)
+	}
+	return soa, nil
+}
+
+func defaultSOA() *SOA {
+	return &SOA{
+		nameServer:  nameServerDefault,
+		adminEmail:  adminEmailDefault,
+		refresh:     refreshDefault,
+		retry:       retryDefault,
+		expire:      expireDefault,
+		negativeTTL: negativeTTLDefault,
+		ttl:         ttlDefault,
+		minTTL:      0,
+		maxTTL:      maxTimer,
+	}
+}
+
+func parseTimer(name string, value string, defaultValue uint32, minValue uint64) (uint32, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	timer, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || timer < minValue || timer > maxTimer {
+		return 0, fmt.Errorf("invalid %s %q, must be a number of seconds between %d and %d", name, value, minValue, maxTimer)
+	}
+	return uint32(timer), nil
+}
+
+// parseNameServers parses the name servers list, e.g. ns1=10.0.0.1;2001:db8::1,ns2=10.0.0.2,ns.example.com.
+func parseNameServers(nameServers string) ([]nameServer, error) {
+	var parsedNameServers []nameServer
+	names := map[string]bool{}
+	for _, entry := range strings.Split(nameServers, nameServersSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, IPs, _ := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if err := validateSOAName(name); err != nil {
+			return nil, fmt.Errorf("invalid name server %q: %w", name, err)
+		}
+		if names[strings.ToLower(name)] {
+			return nil, fmt.Errorf("invalid name server %q: declared more than once", name)
+		}
+		names[strings.ToLower(name)] = true
+
+		parsedNameServer := nameServer{name: name}
+		for _, IP := range strings.Split(IPs, nameServerIPsSeparator) {
+			if IP = strings.TrimSpace(IP); IP == "" {
+				continue
+			}
+			parsedIP := netutils.ParseIPSloppy(IP)
+			if parsedIP == nil {
+				return nil, fmt.Errorf("invalid name server %q: invalid IP %q", name, IP)
+			}
+			// The IP is published in its canonical form, e.g. 010.0.0.1 is not a valid address record data
+			parsedNameServer.IPs = append(parsedNameServer.IPs, parsedIP.String())
+		}
+		parsedNameServers = append(parsedNameServers, parsedNameServer)
+	}
+	if len(parsedNameServers) == 0 {
+		return nil, fmt.Errorf("invalid name servers %q: no name server is declared", nameServers)
+	}
+	return parsedNameServers, nil
+}
+
+// ValidateGlue checks the name servers of the list that are in the forward zone of the given domain have glue
+// addresses, since a resolver can not reach a name server that is in the zone it serves otherwise
+func (soa *SOA) ValidateGlue(domain string) error {
+	for _, server := range soa.nameServers {
+		if _, isInZone := glueName(server.name, domain); isInZone && len(server.IPs) == 0 {
+			return fmt.Errorf("invalid name server %q: it is in zone %s, and has no glue address", server.name, domain)
+		}
+	}
+	return nil
+}
+
+// validateSOAName validates a name that is relative to the zone domain, or absolute when it ends with a dot
+func validateSOAName(name string) error {
+	if errs := validation.IsDNS1123Subdomain(strings.ToLower(strings.TrimSuffix(name, "."))); len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// parseAdminEmail returns the mailbox as a name, an email address is converted to an absolute name, where the dots
+// of the local part are escaped, e.g. john.doe@example.com is john\.doe.example.com.
+func parseAdminEmail(adminEmail string) (string, error) {
+	localPart, mailDomain, isEmailAddress := strings.Cut(adminEmail, "@")
+	if !isEmailAddress {
+		return adminEmail, validateSOAName(adminEmail)
+	}
+	if localPart == "" || strings.ContainsAny(localPart, " \t\\;()\"") {
+		return "", fmt.Errorf("invalid local part %q", localPart)
+	}
+	if err := validateSOAName(mailDomain); err != nil {
+		return "", err
+	}
+	return strings.ReplaceAll(localPart, ".", "\\.") + "." + strings.TrimSuffix(mailDomain, ".") + ".", nil
+}
+
+// absoluteName returns the name as an absolute name without the trailing dot, a relative name is relative to the
+// given domain
+func absoluteName(name string, domain string) string {
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	return name + "." + domain
+}
+
+// glueName returns the name server name relative to the given domain, when it is below the domain, so its address
+// records can be published in the zone of the domain
+func glueName(nameServer string, domain string) (string, bool) {
+	if !strings.HasSuffix(nameServer, ".") {
+		return nameServer, true
+	}
+	name := strings.TrimSuffix(nameServer, ".")
+	suffix := "." + domain
+	if len(name) <= len(suffix) || !strings.EqualFold(name[len(name)-len(suffix):], suffix) {
+		return "", false
+	}
+	return name[:len(name)-len(suffix)], true
+}
+
+// generateNSRecords returns the NS records of the name servers and the glue address records of the name servers that
+// are in the forward zone of the given domain. Without a name servers list, the primary name server with the given IP
+// is the only name server, and it is published only when the IP is set.
+func (soa *SOA) generateNSRecords(nameServerIP string, domain string, isForwardZone bool) []Record {
+	nameServers := soa.nameServers
+	if len(nameServers) == 0 {
+		if nameServerIP == "" {
+			return nil
+		}
+		nameServers = []nameServer{{name: soa.nameServer, IPs: []string{nameServerIP}}}
+	}
+
+	var recordsArr []Record
+	for _, server := range nameServers {
+		recordsArr = append(recordsArr, newRecord("@", recordTypeNS, absoluteName(server.name, domain)+"."))
+	}
+	if !isForwardZone {
+		return recordsArr
+	}
+	for _, server := range nameServers {
+		name, isInZone := glueName(server.name, domain)
+		if !isInZone {
+			continue
+		}
+		for _, IP := range server.IPs {
+			recordType := recordTypeA
+			if netutils.IsIPv6String(IP) {
+				recordType = recordTypeAAAA
+			}
+			recordsArr = append(recordsArr, newRecord(name, recordType, IP))
+		}
+	}
+	return recordsArr
+}
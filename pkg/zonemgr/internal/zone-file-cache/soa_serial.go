@@ -0,0 +1,85 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"time"
+)
+
+// SerialScheme is the scheme of the zone SOA serial
+type SerialScheme string
+
+const (
+	// SerialSchemeCounter increments the serial on each zone update
+	SerialSchemeCounter SerialScheme = "counter"
+	// SerialSchemeDate sets the serial to the update date in the YYYYMMDDnn format, nn counts the updates of the day
+	SerialSchemeDate SerialScheme = "date"
+	// SerialSchemeUnixTime sets the serial to the update time in seconds since the epoch
+	SerialSchemeUnixTime SerialScheme = "unix-time"
+)
+
+const (
+	// serialHalfRange is 2^(SERIAL_BITS - 1), the bound of the RFC 1982 serial number arithmetic
+	serialHalfRange = 1 << 31
+
+	dateSerialMaxCount = 99
+)
+
+// ParseSerialScheme returns the serial scheme, the counter scheme is the default
+func ParseSerialScheme(scheme string) (SerialScheme, error) {
+	switch SerialScheme(scheme) {
+	case "":
+		return SerialSchemeCounter, nil
+	case SerialSchemeCounter, SerialSchemeDate, SerialSchemeUnixTime:
+		return SerialScheme(scheme), nil
+	default:
+		return "", fmt.Errorf("invalid SOA serial scheme %q, supported schemes are %q, %q and %q", scheme,
+			SerialSchemeCounter, SerialSchemeDate, SerialSchemeUnixTime)
+	}
+}
+
+// nextSerial returns the serial that follows the current serial. The serial of the scheme at the given time is used
+// when it is greater than the current serial in the RFC 1982 sequence space, otherwise the current serial is
+// incremented, so the serial always moves forward, e.g. when the clock was set back, or when more updates than the
+// scheme can count were done.
+func (scheme SerialScheme) nextSerial(current uint32, now time.Time) uint32 {
+	var candidate uint32
+	switch scheme {
+	case SerialSchemeDate:
+		candidate = dateSerial(now, 0)
+	case SerialSchemeUnixTime:
+		candidate = uint32(now.Unix())
+	default:
+		candidate = current + 1
+	}
+	if isSerialGreater(candidate, current) {
+		return candidate
+	}
+	return current + 1
+}
+
+// RecoverySerial returns the serial that a zone, rebuilt because neither its serial nor the serial of its
+// last-known-good copy could be read, follows. The serial is meant to be above any serial the zone could have had, at
+// the cost of a jump:
+//   - date: the last serial of the day, YYYYMMDD99, so the rebuilt zone serial is the first serial of the next day,
+//     and the serials carry the next day date until the date catches up.
+//   - unix-time: the time.
+//   - counter: the time, so the counter jumps to the Unix time and continues from it, it is not set back. The counter,
+//     starting from zero, is assumed to be below the time, which is not checked.
+func (scheme SerialScheme) RecoverySerial(now time.Time) int {
+	if scheme == SerialSchemeDate {
+		return int(dateSerial(now, dateSerialMaxCount))
+	}
+	return int(uint32(now.Unix()))
+}
+
+// dateSerial returns the YYYYMMDDnn serial of the UTC date of the given time, with the given count as nn
+func dateSerial(now time.Time, count uint32) uint32 {
+	year, month, day := now.UTC().Date()
+	return ((uint32(year)*100+uint32(month))*100+uint32(day))*100 + count
+}
+
+// isSerialGreater returns whether the serial is greater than the other serial, according to the RFC 1982 serial
+// number arithmetic, where the serials wrap around at 2^32
+func isSerialGreater(serial, other uint32) bool {
+	return (serial < other && other-serial > serialHalfRange) || (serial > other && serial-other < serialHalfRange)
+}
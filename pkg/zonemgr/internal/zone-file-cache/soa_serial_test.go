@@ -0,0 +1,62 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"math"
+	"time"
+)
+
+var _ = Describe("SOA serial", func() {
+	var now = time.Date(2026, time.October, 18, 13, 45, 0, 0, time.UTC)
+
+	DescribeTable("parse serial scheme", func(scheme string, expectedScheme SerialScheme) {
+		Expect(ParseSerialScheme(scheme)).To(Equal(expectedScheme))
+	},
+		Entry("default is counter", "", SerialSchemeCounter),
+		Entry("counter", "counter", SerialSchemeCounter),
+		Entry("date", "date", SerialSchemeDate),
+		Entry("unix time", "unix-time", SerialSchemeUnixTime),
+	)
+
+	It("should reject an unknown serial scheme", func() {
+		_, err := ParseSerialScheme("epoch")
+		Expect(err).To(HaveOccurred())
+	})
+
+	DescribeTable("compare serials in the RFC 1982 sequence space", func(serial, other uint32, expectedIsGreater bool) {
+		Expect(isSerialGreater(serial, other)).To(Equal(expectedIsGreater))
+	},
+		Entry("higher serial", uint32(2), uint32(1), true),
+		Entry("lower serial", uint32(1), uint32(2), false),
+		Entry("equal serials", uint32(1), uint32(1), false),
+		Entry("serial that wrapped around", uint32(0), uint32(math.MaxUint32), true),
+		Entry("serial before the wrap around", uint32(math.MaxUint32), uint32(0), false),
+		Entry("serial that is half the sequence space ahead", uint32(1<<31), uint32(0), false),
+		Entry("serial that is less than half the sequence space ahead", uint32(1<<31-1), uint32(0), true),
+	)
+
+	DescribeTable("generate the next serial", func(scheme SerialScheme, current uint32, expectedSerial uint32) {
+		Expect(scheme.nextSerial(current, now)).To(Equal(expectedSerial))
+	},
+		Entry("counter is incremented", SerialSchemeCounter, uint32(5), uint32(6)),
+		Entry("counter wraps around", SerialSchemeCounter, uint32(math.MaxUint32), uint32(0)),
+		Entry("date of a new zone", SerialSchemeDate, uint32(0), uint32(2026101800)),
+		Entry("date of a previous day", SerialSchemeDate, uint32(2026101712), uint32(2026101800)),
+		Entry("date of the same day is incremented", SerialSchemeDate, uint32(2026101805), uint32(2026101806)),
+		Entry("date of the next day, the clock was set back, is incremented", SerialSchemeDate, uint32(2026101900), uint32(2026101901)),
+		Entry("date after a counter serial", SerialSchemeDate, uint32(12), uint32(2026101800)),
+		Entry("unix time", SerialSchemeUnixTime, uint32(5), uint32(now.Unix())),
+		Entry("unix time ahead of the clock is incremented", SerialSchemeUnixTime, uint32(now.Unix()+60), uint32(now.Unix()+61)),
+		Entry("unix time ahead of a date serial is incremented", SerialSchemeUnixTime, uint32(2026101800), uint32(2026101801)),
+	)
+
+	DescribeTable("generate the recovery serial", func(scheme SerialScheme, expectedSerial int) {
+		Expect(scheme.RecoverySerial(now)).To(Equal(expectedSerial))
+	},
+		Entry("counter", SerialSchemeCounter, int(now.Unix())),
+		Entry("date", SerialSchemeDate, 2026101899),
+		Entry("unix time", SerialSchemeUnixTime, int(now.Unix())),
+	)
+})
@@ -0,0 +1,109 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SOA parameters", func() {
+	const domain = "vm.domain.com"
+
+	DescribeTable("render the SOA parameters into the zone header", func(params SOAParams, expectedHeader string) {
+		soa, err := NewSOA(params)
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewZoneFileCache("185.251.75.10", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.header).To(Equal(expectedHeader))
+	},
+		Entry("default parameters", SOAParams{},
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns.vm.domain.com.\nns IN A 185.251.75.10\n"),
+		Entry("custom timers and TTLs", SOAParams{Refresh: "7200", Retry: "900", Expire: "604800", NegativeTTL: "300", TTL: "60"},
+			"$ORIGIN vm.domain.com. \n$TTL 60 \n@ IN SOA ns.vm.domain.com. email.vm.domain.com. (0 7200 900 604800 300)\n"+
+				"@ IN NS ns.vm.domain.com.\nns IN A 185.251.75.10\n"),
+		Entry("relative name server and admin mailbox", SOAParams{NameServer: "dns1", AdminEmail: "hostmaster"},
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA dns1.vm.domain.com. hostmaster.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS dns1.vm.domain.com.\ndns1 IN A 185.251.75.10\n"),
+		Entry("absolute name server in the zone", SOAParams{NameServer: "dns1.vm.domain.com."},
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA dns1.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS dns1.vm.domain.com.\ndns1 IN A 185.251.75.10\n"),
+		Entry("absolute name server out of the zone has no address record", SOAParams{NameServer: "ns1.example.com."},
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns1.example.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns1.example.com.\n"),
+		Entry("admin email address", SOAParams{AdminEmail: "dns.admin@example.com"},
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns.vm.domain.com. dns\\.admin.example.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns.vm.domain.com.\nns IN A 185.251.75.10\n"),
+	)
+
+	DescribeTable("render the name servers into the zone header", func(nameServers string, expectedHeader string) {
+		soa, err := NewSOA(SOAParams{NameServers: nameServers})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+		Expect(zoneFileCache.header).To(Equal(expectedHeader))
+	},
+		Entry("name servers with IPv4 and IPv6 glue", "ns1=10.0.0.1;2001:db8::1, ns2=10.0.0.2",
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns1.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns1.vm.domain.com.\n@ IN NS ns2.vm.domain.com.\n"+
+				"ns1 IN A 10.0.0.1\nns1 IN AAAA 2001:db8::1\nns2 IN A 10.0.0.2\n"),
+		Entry("external secondary without glue", "ns1=10.0.0.1,ns.example.com.",
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns1.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns1.vm.domain.com.\n@ IN NS ns.example.com.\nns1 IN A 10.0.0.1\n"),
+		Entry("external name server glue is not published", "ns.example.com.=10.0.0.3,ns2.vm.domain.com.=10.0.0.2",
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns.example.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns.example.com.\n@ IN NS ns2.vm.domain.com.\nns2 IN A 10.0.0.2\n"),
+		Entry("glue addresses in their canonical form", "ns1=010.0.0.1;2001:DB8:0::1",
+			"$ORIGIN vm.domain.com. \n$TTL 3600 \n@ IN SOA ns1.vm.domain.com. email.vm.domain.com. (0 3600 3600 1209600 3600)\n"+
+				"@ IN NS ns1.vm.domain.com.\nns1 IN A 10.0.0.1\nns1 IN AAAA 2001:db8::1\n"),
+	)
+
+	DescribeTable("validate the glue of the name servers in the zone", func(nameServers string, expectedValid bool) {
+		soa, err := NewSOA(SOAParams{NameServers: nameServers})
+		Expect(err).ToNot(HaveOccurred())
+		if expectedValid {
+			Expect(soa.ValidateGlue(domain)).To(Succeed())
+		} else {
+			Expect(soa.ValidateGlue(domain)).ToNot(Succeed())
+		}
+	},
+		Entry("name servers with glue", "ns1=10.0.0.1,ns2.vm.domain.com.=10.0.0.2", true),
+		Entry("external name server without glue", "ns1=10.0.0.1,ns.example.com.", true),
+		Entry("relative name server without glue", "ns1=10.0.0.1,ns2", false),
+		Entry("absolute name server in the zone without glue", "ns1=10.0.0.1,ns2.VM.domain.com.", false),
+	)
+
+	It("should publish the name servers, without glue, in the reverse zones", func() {
+		soa, err := NewSOA(SOAParams{NameServers: "ns1=10.0.0.1,ns2=10.0.0.2"})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewReverseZoneFileCache("", domain, "0.0.10.in-addr.arpa", nil, SerialSchemeCounter, soa)
+		Expect(zoneFileCache.header).To(HaveSuffix("@ IN NS ns1.vm.domain.com.\n@ IN NS ns2.vm.domain.com.\n"))
+	})
+
+	It("should render the SOA parameters into the reverse zones header", func() {
+		soa, err := NewSOA(SOAParams{NameServer: "dns1", AdminEmail: "hostmaster@example.com", TTL: "60"})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewReverseZoneFileCache("185.251.75.10", domain, "0.0.10.in-addr.arpa", nil, SerialSchemeCounter, soa)
+		Expect(zoneFileCache.header).To(Equal("$ORIGIN 0.0.10.in-addr.arpa. \n$TTL 60 \n" +
+			"@ IN SOA dns1.vm.domain.com. hostmaster.example.com. (0 3600 3600 1209600 3600)\n@ IN NS dns1.vm.domain.com.\n"))
+	})
+
+	DescribeTable("reject invalid SOA parameters", func(params SOAParams) {
+		_, err := NewSOA(params)
+		Expect(err).To(HaveOccurred())
+	},
+		Entry("invalid name server", SOAParams{NameServer: "ns_1"}),
+		Entry("invalid admin mailbox", SOAParams{AdminEmail: "host master"}),
+		Entry("admin email without local part", SOAParams{AdminEmail: "@example.com"}),
+		Entry("admin email with invalid domain", SOAParams{AdminEmail: "hostmaster@example..com"}),
+		Entry("refresh that is not a number", SOAParams{Refresh: "1h"}),
+		Entry("zero retry", SOAParams{Retry: "0"}),
+		Entry("negative expire", SOAParams{Expire: "-1"}),
+		Entry("TTL out of range", SOAParams{TTL: "2147483648"}),
+		Entry("expire lower than refresh", SOAParams{Refresh: "7200", Expire: "3600"}),
+		Entry("name servers list along with the primary name server", SOAParams{NameServer: "ns", NameServers: "ns1,ns2"}),
+		Entry("empty name servers list", SOAParams{NameServers: " , "}),
+		Entry("invalid name server in the list", SOAParams{NameServers: "ns1,ns_2"}),
+		Entry("name server declared more than once", SOAParams{NameServers: "ns1=10.0.0.1,NS1=10.0.0.2"}),
+		Entry("name server with an invalid IP", SOAParams{NameServers: "ns1=10.0.0"}),
+		Entry("maximum TTL out of range", SOAParams{MaxTTL: "2147483648"}),
+		Entry("minimum TTL greater than the maximum TTL", SOAParams{MinTTL: "600", MaxTTL: "60"}),
+	)
+})
@@ -0,0 +1,46 @@
+package zone_file_cache
+
+import (
+	"fmt"
+	"strconv"
+
+	v1 "kubevirt.io/api/core/v1"
+)
+
+// TTLAnnotation is the VMI annotation that sets the TTL, in seconds, of the VMI records, e.g. "60". A namespace
+// annotation sets the TTL of the records of the namespace VMIs that do not set it. The TTL is bounded by the
+// operator minimum and maximum TTLs, the records of the VMIs without the annotation, or with an invalid one, have the
+// zone TTL.
+const TTLAnnotation = "secondarydns.kubevirt.io/ttl"
+
+// recordTTL returns the TTL set by the VMI TTL annotation, bounded by the minimum and maximum TTLs, and whether the
+// annotation is set
+func (soa *SOA) recordTTL(vmi *v1.VirtualMachineInstance) (uint32, bool, error) {
+	annotation, exists := vmi.Annotations[TTLAnnotation]
+	if !exists {
+		return 0, false, nil
+	}
+	ttl, err := strconv.ParseUint(annotation, 10, 32)
+	if err != nil || ttl > maxTimer {
+		return 0, false, fmt.Errorf("invalid %s annotation %q, must be a number of seconds between 0 and %d",
+			TTLAnnotation, annotation, maxTimer)
+	}
+	return boundTTL(uint32(ttl), soa.minTTL, soa.maxTTL), true, nil
+}
+
+func boundTTL(ttl uint32, minTTL uint32, maxTTL uint32) uint32 {
+	if ttl < minTTL {
+		return minTTL
+	}
+	if ttl > maxTTL {
+		return maxTTL
+	}
+	return ttl
+}
+
+// setRecordsTTL sets an explicit TTL to the records
+func setRecordsTTL(records []Record, ttl uint32) {
+	for i := range records {
+		records[i] = records[i].withTTL(ttl)
+	}
+}
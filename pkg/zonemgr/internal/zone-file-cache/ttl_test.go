@@ -0,0 +1,89 @@
+package zone_file_cache
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	k8stypes "k8s.io/apimachinery/pkg/types"
+	v1 "kubevirt.io/api/core/v1"
+)
+
+var _ = Describe("VMI records TTL", func() {
+	const domain = "vm.domain.com"
+
+	var vmi1 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi1"}
+
+	newTTLVMI := func(ttl string) *v1.VirtualMachineInstance {
+		vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.1"}, Name: "nic1"}})
+		if ttl != "" {
+			vmi.Annotations = map[string]string{TTLAnnotation: ttl}
+		}
+		return vmi
+	}
+
+	newTTLZoneFileCache := func(minTTL, maxTTL string) *ZoneFileCache {
+		soa, err := NewSOA(SOAParams{MinTTL: minTTL, MaxTTL: maxTTL})
+		Expect(err).ToNot(HaveOccurred())
+		return NewZoneFileCache("", domain, nil, SerialSchemeCounter, soa, newTestNaming(NamingModeName, domain), nil)
+	}
+
+	DescribeTable("render the VMI records TTL", func(minTTL, maxTTL, ttl string, expectedRecords string) {
+		zoneFileCache := newTTLZoneFileCache(minTTL, maxTTL)
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI(ttl)).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal(expectedRecords))
+	},
+		Entry("records without TTL annotation have the zone TTL", "", "", "",
+			"vmi1.ns1 IN A 10.0.0.1\nnic1.vmi1.ns1 IN A 10.0.0.1\n"),
+		Entry("records with TTL annotation", "", "", "60",
+			"vmi1.ns1 60 IN A 10.0.0.1\nnic1.vmi1.ns1 60 IN A 10.0.0.1\n"),
+		Entry("TTL lower than the minimum TTL", "30", "", "5",
+			"vmi1.ns1 30 IN A 10.0.0.1\nnic1.vmi1.ns1 30 IN A 10.0.0.1\n"),
+		Entry("TTL higher than the maximum TTL", "", "86400", "604800",
+			"vmi1.ns1 86400 IN A 10.0.0.1\nnic1.vmi1.ns1 86400 IN A 10.0.0.1\n"),
+	)
+
+	It("should update the records when the TTL annotation is changed", func() {
+		zoneFileCache := newTTLZoneFileCache("", "")
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI("60")).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI("60")).IsChanged()).To(BeFalse())
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI("300")).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal("vmi1.ns1 300 IN A 10.0.0.1\nnic1.vmi1.ns1 300 IN A 10.0.0.1\n"))
+		Expect(zoneFileCache.records.linesLength).To(Equal(len(zoneFileCache.aRecords)))
+	})
+
+	DescribeTable("fall back to the zone TTL on invalid TTL annotation", func(ttl string) {
+		zoneFileCache := newTTLZoneFileCache("", "")
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI(ttl)).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.GetInvalidTTLError(vmi1)).To(HaveOccurred())
+		Expect(zoneFileCache.GetInvalidNameError(vmi1)).ToNot(HaveOccurred())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal("vmi1.ns1 IN A 10.0.0.1\nnic1.vmi1.ns1 IN A 10.0.0.1\n"))
+
+		Expect(zoneFileCache.UpdateVMIRecords(vmi1, newTTLVMI("60")).IsChanged()).To(BeTrue())
+		Expect(zoneFileCache.GetInvalidTTLError(vmi1)).ToNot(HaveOccurred())
+	},
+		Entry("not a number", "1m"),
+		Entry("negative TTL", "-1"),
+		Entry("TTL out of range", "2147483648"),
+	)
+
+	It("should render the VMI TTL on its PTR records", func() {
+		soa, err := NewSOA(SOAParams{MaxTTL: "120"})
+		Expect(err).ToNot(HaveOccurred())
+		zoneFileCache := NewReverseZoneFileCache("", domain, "0.0.10.in-addr.arpa", nil, SerialSchemeCounter, soa)
+		ptrRecords := []Record{newRecord("1", recordTypePTR, "nic1.vmi1.ns1.vm.domain.com.")}
+		Expect(zoneFileCache.UpdateVMIPTRRecords(vmi1, newTTLVMI("300"), ptrRecords).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal("1 120 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"))
+	})
+
+	It("should render the zone TTL on the PTR records of a VMI with an invalid TTL annotation", func() {
+		zoneFileCache := NewReverseZoneFileCache("", domain, "0.0.10.in-addr.arpa", nil, SerialSchemeCounter, nil)
+		ptrRecords := []Record{newRecord("1", recordTypePTR, "nic1.vmi1.ns1.vm.domain.com.")}
+		Expect(zoneFileCache.UpdateVMIPTRRecords(vmi1, newTTLVMI("1m"), ptrRecords).IsChanged()).To(BeTrue())
+		zoneFileCache.Flush()
+		Expect(zoneFileCache.aRecords).To(Equal("1 IN PTR nic1.vmi1.ns1.vm.domain.com.\n"))
+	})
+})
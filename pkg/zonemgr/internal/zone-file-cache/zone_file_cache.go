@@ -2,56 +2,102 @@ package zone_file_cache
 
 import (
 	"fmt"
-	"reflect"
-	"regexp"
+	"net"
 	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	k8stypes "k8s.io/apimachinery/pkg/types"
-	"k8s.io/utils/net"
+	netutils "k8s.io/utils/net"
 
 	v1 "kubevirt.io/api/core/v1"
 )
 
 const (
-	refresh = "3600"    // 1 hour (seconds) - how long a nameserver should wait prior to checking for a Serial Number increase within the primary zone file
-	retry   = "3600"    // 1 hour (seconds) - how long a nameserver should wait prior to retrying to update a zone after a failed attempt.
-	expire  = "1209600" // 2 weeks (seconds) - how long a nameserver should wait prior to considering data from a secondary zone invalid and stop answering queries for that zone
-	ttl     = "3600"    // 1 hour (seconds) - the duration that the record may be cached by any resolver
-
-	nameServerDefault = "ns"
-	adminEmailDefault = "email"
+	recordTypeA    = "A"
+	recordTypeAAAA = "AAAA"
+	recordTypeNS   = "NS"
 )
 
-var aRecordRegex = regexp.MustCompile(`IN A ([\d.]+)`)
-
 type ZoneFileCache struct {
-	soaSerial      int
+	soaSerial    uint32
+	serialScheme SerialScheme
+	// now returns the current time, the SOA serials of the time based schemes are derived from it
+	now            func() time.Time
+	soa            *SOA
 	adminEmail     string
 	nameServerName string
 	nameServerIP   string
 	domain         string
+	origin         string
 
 	headerPref string
 	headerSuf  string
+	// headerRecords are the NS records and the glue records of the header
+	headerRecords []Record
 
 	header   string
 	aRecords string
 	Content  string
+	// isChanged is set when the records were changed since the content was last generated
+	isChanged bool
+
+	naming   *Naming
+	metadata *Metadata
+
+	records            *recordStore
+	journal            *journal
+	changes            []rrsetChange
+	vmiNamesMap        map[string]vmiNames
+	nameOwnersMap      map[string]k8stypes.NamespacedName
+	vmiConflictsMap    map[string]k8stypes.NamespacedName
+	vmiInvalidNamesMap map[string]error
+	vmiInvalidTTLsMap  map[string]error
+	// vmiAliasConflictsMap holds the aliases of each VMI that are owned by another VMI, mapped to their owners
+	vmiAliasConflictsMap map[string]map[string]k8stypes.NamespacedName
+}
+
+// NameServersOwner is the owner of the names of the name servers glue records, a VMI that claims one of them is in
+// conflict with it
+var NameServersOwner = k8stypes.NamespacedName{Name: "name-servers"}
+
+// NewZoneFileCache creates the cache of the forward zone of the given domain. The SOA serial is the serial of the
+// existing zone file, or nil when there is none, the serials of the following updates are generated by the serial
+// scheme. A nil SOA sets the default SOA parameters.
+func NewZoneFileCache(nameServerIP string, domain string, soaSerial *int, serialScheme SerialScheme, soa *SOA,
+	naming *Naming, metadata *Metadata) *ZoneFileCache {
+	zoneFileCache := newZoneFileCache(nameServerIP, domain, domain, soaSerial, serialScheme, soa)
+	zoneFileCache.naming = naming
+	zoneFileCache.metadata = metadata
+	return zoneFileCache
+}
 
-	vmiRecordsMap map[string][]string
+// NewReverseZoneFileCache creates the cache of a reverse zone with the given origin, its SOA and NS records refer to
+// the name server of the forward zone of the given domain
+func NewReverseZoneFileCache(nameServerIP string, domain string, origin string, soaSerial *int, serialScheme SerialScheme,
+	soa *SOA) *ZoneFileCache {
+	return newZoneFileCache(nameServerIP, domain, origin, soaSerial, serialScheme, soa)
 }
 
-func NewZoneFileCache(nameServerIP string, domain string, soaSerial *int) *ZoneFileCache {
-	soaSerialInt := 0
+func newZoneFileCache(nameServerIP string, domain string, origin string, soaSerial *int, serialScheme SerialScheme,
+	soa *SOA) *ZoneFileCache {
+	var soaSerialUint uint32
 	if soaSerial != nil {
-		soaSerialInt = *soaSerial
+		soaSerialUint = uint32(*soaSerial)
+	}
+	if soa == nil {
+		soa = defaultSOA()
 	}
 
 	zoneFileCache := &ZoneFileCache{
 		nameServerIP: nameServerIP,
 		domain:       domain,
-		soaSerial:    soaSerialInt,
+		origin:       origin,
+		soaSerial:    soaSerialUint,
+		serialScheme: serialScheme,
+		now:          time.Now,
+		soa:          soa,
 	}
 	zoneFileCache.prepare()
 	return zoneFileCache
@@ -62,101 +108,281 @@ func (zoneFileCache *ZoneFileCache) prepare() {
 	zoneFileCache.generateHeaderPrefix()
 	zoneFileCache.generateHeaderSuffix()
 	zoneFileCache.header = zoneFileCache.generateHeader()
-	zoneFileCache.vmiRecordsMap = make(map[string][]string)
+	zoneFileCache.records = newRecordStore()
+	zoneFileCache.vmiNamesMap = make(map[string]vmiNames)
+	zoneFileCache.nameOwnersMap = make(map[string]k8stypes.NamespacedName)
+	zoneFileCache.reserveGlueNames()
+	zoneFileCache.vmiConflictsMap = make(map[string]k8stypes.NamespacedName)
+	zoneFileCache.vmiInvalidNamesMap = make(map[string]error)
+	zoneFileCache.vmiInvalidTTLsMap = make(map[string]error)
+	zoneFileCache.vmiAliasConflictsMap = make(map[string]map[string]k8stypes.NamespacedName)
 }
 
 func (zoneFileCache *ZoneFileCache) initCustomFields() {
-	zoneFileCache.nameServerName = fmt.Sprintf("%s.%s", nameServerDefault, zoneFileCache.domain)
-	zoneFileCache.adminEmail = fmt.Sprintf("%s.%s", adminEmailDefault, zoneFileCache.domain)
+	zoneFileCache.nameServerName = absoluteName(zoneFileCache.soa.nameServer, zoneFileCache.domain)
+	zoneFileCache.adminEmail = absoluteName(zoneFileCache.soa.adminEmail, zoneFileCache.domain)
 }
 
 func (zoneFileCache *ZoneFileCache) generateHeaderPrefix() {
-	zoneFileCache.headerPref = fmt.Sprintf("$ORIGIN %s. \n$TTL %s \n@ IN SOA %s. %s. (", zoneFileCache.domain, ttl,
-		zoneFileCache.nameServerName, zoneFileCache.adminEmail)
+	zoneFileCache.headerPref = fmt.Sprintf("$ORIGIN %s. \n$TTL %d \n@ IN SOA %s. %s. (", zoneFileCache.origin,
+		zoneFileCache.soa.ttl, zoneFileCache.nameServerName, zoneFileCache.adminEmail)
 }
 
+// generateHeaderSuffix renders the SOA timers, the NS records and the glue records of the name servers
 func (zoneFileCache *ZoneFileCache) generateHeaderSuffix() {
-	zoneFileCache.headerSuf = fmt.Sprintf(" %s %s %s %s)\n", refresh, retry, expire, ttl)
+	soa := zoneFileCache.soa
+	zoneFileCache.headerSuf = fmt.Sprintf(" %d %d %d %d)\n", soa.refresh, soa.retry, soa.expire, soa.negativeTTL)
 
-	if zoneFileCache.nameServerIP != "" {
-		zoneFileCache.headerSuf += fmt.Sprintf("@ IN NS %s.\n", zoneFileCache.nameServerName)
-		zoneFileCache.headerSuf += fmt.Sprintf("%s IN A %s\n", nameServerDefault, zoneFileCache.nameServerIP)
+	zoneFileCache.headerRecords = soa.generateNSRecords(zoneFileCache.nameServerIP, zoneFileCache.domain,
+		zoneFileCache.origin == zoneFileCache.domain)
+	for _, record := range zoneFileCache.headerRecords {
+		zoneFileCache.headerSuf += record.String()
 	}
 }
 
 func (zoneFileCache *ZoneFileCache) generateHeader() string {
-	return zoneFileCache.headerPref + strconv.Itoa(zoneFileCache.soaSerial) + zoneFileCache.headerSuf
+	return zoneFileCache.headerPref + strconv.FormatUint(uint64(zoneFileCache.soaSerial), 10) + zoneFileCache.headerSuf
 }
 
-func (zoneFileCache *ZoneFileCache) UpdateVMIRecords(namespacedName k8stypes.NamespacedName, interfaces []v1.VirtualMachineInstanceNetworkInterface) bool {
-	key := fmt.Sprintf("%s_%s", namespacedName.Name, namespacedName.Namespace)
-	isUpdated := false
+// UpdateVMIRecords sets the records of the VMI interfaces, a nil VMI removes its records from the zone. It returns the
+// records the update added, removed and left unchanged.
+// The VMI is not published when its names are invalid, see GetInvalidNameError, or when one of its names is already
+// used by another VMI, see GetNameConflict. An alias that is used by another VMI is not published, while the rest of the
+// VMI records are, see GetAliasConflicts. A VMI with an invalid TTL annotation is published with the zone TTL, see
+// GetInvalidTTLError.
+func (zoneFileCache *ZoneFileCache) UpdateVMIRecords(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance) ChangeSet {
+	key := generateVMIKey(namespacedName)
+	delete(zoneFileCache.vmiConflictsMap, key)
+	delete(zoneFileCache.vmiInvalidNamesMap, key)
+	delete(zoneFileCache.vmiInvalidTTLsMap, key)
+	delete(zoneFileCache.vmiAliasConflictsMap, key)
+	zoneFileCache.releaseNames(key)
 
-	if interfaces == nil {
-		if zoneFileCache.vmiRecordsMap[key] != nil {
-			delete(zoneFileCache.vmiRecordsMap, key)
-			isUpdated = true
+	var newRecords []Record
+	if vmi != nil {
+		names, err := zoneFileCache.naming.generateNames(namespacedName, vmi)
+		ttl, hasTTL, ttlErr := zoneFileCache.soa.recordTTL(vmi)
+		if ttlErr != nil {
+			zoneFileCache.vmiInvalidTTLsMap[key] = ttlErr
 		}
-	} else {
-		newRecords := buildARecordsArr(namespacedName.Name, namespacedName.Namespace, interfaces)
-		isUpdated = !reflect.DeepEqual(newRecords, zoneFileCache.vmiRecordsMap[key])
-		if isUpdated {
-			zoneFileCache.vmiRecordsMap[key] = newRecords
+		if err != nil {
+			zoneFileCache.vmiInvalidNamesMap[key] = err
+		} else if owner, isClaimed := zoneFileCache.getNamesOwner(names.getAddressNames()); isClaimed {
+			zoneFileCache.vmiConflictsMap[key] = owner
+		} else {
+			zoneFileCache.dropClaimedAliases(key, &names)
+			if newRecords = buildRecordsArr(names, zoneFileCache.naming.defaultInterfacePolicy.sortDefaultCandidates(vmi)); len(newRecords) > 0 {
+				newRecords = append(newRecords, zoneFileCache.metadata.generateTXTRecords(names, vmi)...)
+				if hasTTL {
+					setRecordsTTL(newRecords, ttl)
+				}
+				zoneFileCache.claimNames(key, namespacedName, names)
+			}
 		}
 	}
+	return zoneFileCache.updateRecords(key, newRecords)
+}
+
+// GetInterfacesNames returns the names of the VMI published interfaces records, relative to the zone domain
+func (zoneFileCache *ZoneFileCache) GetInterfacesNames(namespacedName k8stypes.NamespacedName) (map[string]string, bool) {
+	names, exists := zoneFileCache.vmiNamesMap[generateVMIKey(namespacedName)]
+	return names.interfaceNames, exists
+}
+
+// GetNameConflict returns the VMI that owns a name claimed by the given VMI on its last update, if there is one
+func (zoneFileCache *ZoneFileCache) GetNameConflict(namespacedName k8stypes.NamespacedName) (k8stypes.NamespacedName, bool) {
+	owner, exists := zoneFileCache.vmiConflictsMap[generateVMIKey(namespacedName)]
+	return owner, exists
+}
 
-	if isUpdated {
-		zoneFileCache.updateContent()
+// GetAliasConflicts returns the aliases of the VMI that were not published on its last update since they are used by
+// another VMI, mapped to the VMIs that own them, relative to the zone domain
+func (zoneFileCache *ZoneFileCache) GetAliasConflicts(namespacedName k8stypes.NamespacedName) map[string]k8stypes.NamespacedName {
+	return zoneFileCache.vmiAliasConflictsMap[generateVMIKey(namespacedName)]
+}
+
+// GetInvalidNameError returns the error of generating the VMI names on its last update, if there is one
+func (zoneFileCache *ZoneFileCache) GetInvalidNameError(namespacedName k8stypes.NamespacedName) error {
+	return zoneFileCache.vmiInvalidNamesMap[generateVMIKey(namespacedName)]
+}
+
+// GetInvalidTTLError returns the error of reading the VMI TTL annotation on its last update, if there is one, the VMI
+// records then have the zone TTL
+func (zoneFileCache *ZoneFileCache) GetInvalidTTLError(namespacedName k8stypes.NamespacedName) error {
+	return zoneFileCache.vmiInvalidTTLsMap[generateVMIKey(namespacedName)]
+}
+
+// reserveGlueNames claims the names of the name servers glue records, so a VMI record is not merged with them. The
+// names are owned by NameServersOwner.
+func (zoneFileCache *ZoneFileCache) reserveGlueNames() {
+	for _, record := range zoneFileCache.headerRecords {
+		if record.recordType == recordTypeA || record.recordType == recordTypeAAAA {
+			zoneFileCache.nameOwnersMap[strings.ToLower(record.name)] = NameServersOwner
+		}
 	}
-	return isUpdated
 }
 
-func buildARecordsArr(name string, namespace string, interfaces []v1.VirtualMachineInstanceNetworkInterface) []string {
-	var recordsArr []string
-	for _, iface := range interfaces {
-		IPs := iface.IPs
-		for _, IP := range IPs {
-			if net.IsIPv4String(IP) {
-				recordsArr = append(recordsArr, generateIfaceARecord(name, namespace, iface.Name, IP))
-				break
-			}
+// getNamesOwner returns the owner of the first of the names that is claimed. The names are owned case insensitively, as
+// the records of names that differ by case only are merged.
+func (zoneFileCache *ZoneFileCache) getNamesOwner(names []string) (k8stypes.NamespacedName, bool) {
+	for _, name := range names {
+		if owner, isClaimed := zoneFileCache.nameOwnersMap[strings.ToLower(name)]; isClaimed {
+			return owner, true
 		}
 	}
-	sort.Strings(recordsArr)
-	if len(recordsArr) > 0 {
-		recordsArr = append(recordsArr, generateDefaultARecord(name, namespace, getIPFromARecord(recordsArr[0])))
+	return k8stypes.NamespacedName{}, false
+}
+
+// dropClaimedAliases removes the aliases that are owned by another VMI from the VMI names, so only their CNAME records
+// are not published, and keeps them as the VMI alias conflicts
+func (zoneFileCache *ZoneFileCache) dropClaimedAliases(key string, names *vmiNames) {
+	for aliasName := range names.aliasNames {
+		owner, isClaimed := zoneFileCache.getNamesOwner([]string{aliasName})
+		if !isClaimed {
+			continue
+		}
+		if zoneFileCache.vmiAliasConflictsMap[key] == nil {
+			zoneFileCache.vmiAliasConflictsMap[key] = map[string]k8stypes.NamespacedName{}
+		}
+		zoneFileCache.vmiAliasConflictsMap[key][aliasName] = owner
+		delete(names.aliasNames, aliasName)
+	}
+}
+
+func (zoneFileCache *ZoneFileCache) claimNames(key string, namespacedName k8stypes.NamespacedName, names vmiNames) {
+	zoneFileCache.vmiNamesMap[key] = names
+	for _, name := range names.getNames() {
+		zoneFileCache.nameOwnersMap[strings.ToLower(name)] = namespacedName
+	}
+}
+
+func (zoneFileCache *ZoneFileCache) releaseNames(key string) {
+	if names, exists := zoneFileCache.vmiNamesMap[key]; exists {
+		for _, name := range names.getNames() {
+			delete(zoneFileCache.nameOwnersMap, strings.ToLower(name))
+		}
+		delete(zoneFileCache.vmiNamesMap, key)
 	}
-	return recordsArr
 }
 
-func generateIfaceARecord(name string, namespace string, ifaceName string, ifaceIP string) string {
-	fqdn := fmt.Sprintf("%s.%s.%s", ifaceName, name, namespace)
-	return fmt.Sprintf("%s IN A %s\n", fqdn, ifaceIP)
+// UpdateVMIPTRRecords sets the PTR records of the VMI in a reverse zone cache, nil records remove the VMI from the zone.
+// The records have the TTL set by the VMI TTL annotation, or the zone TTL when the annotation is invalid, as the VMI
+// records in the forward zone.
+func (zoneFileCache *ZoneFileCache) UpdateVMIPTRRecords(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance,
+	ptrRecords []Record) ChangeSet {
+	if vmi != nil {
+		if ttl, hasTTL, err := zoneFileCache.soa.recordTTL(vmi); err == nil && hasTTL {
+			setRecordsTTL(ptrRecords, ttl)
+		}
+	}
+	return zoneFileCache.updateRecords(generateVMIKey(namespacedName), ptrRecords)
 }
 
-func generateDefaultARecord(name string, namespace string, ifaceIP string) string {
-	fqdn := fmt.Sprintf("%s.%s", name, namespace)
-	return fmt.Sprintf("%s IN A %s\n", fqdn, ifaceIP)
+func generateVMIKey(namespacedName k8stypes.NamespacedName) string {
+	return fmt.Sprintf("%s_%s", namespacedName.Name, namespacedName.Namespace)
 }
 
-func getIPFromARecord(record string) string {
-	return aRecordRegex.FindStringSubmatch(record)[1]
+func (zoneFileCache *ZoneFileCache) updateRecords(key string, newRecords []Record) ChangeSet {
+	changeSet := zoneFileCache.records.set(key, newRecords)
+	if changeSet.IsChanged() {
+		zoneFileCache.isChanged = true
+	}
+	return changeSet
 }
 
-func (zoneFileCache ZoneFileCache) generateARecords() string {
-	aRecords := ""
-	for _, recordsArr := range zoneFileCache.vmiRecordsMap {
-		for _, aRecord := range recordsArr {
-			aRecords += aRecord
+// buildRecordsArr returns the records of the interfaces, the default records of the first interfaces with IPv4 and
+// with IPv6 addresses, the CNAME records of the aliases and the SRV records of the services. The interfaces are expected to be sorted by their
+// preference to back the default records.
+func buildRecordsArr(names vmiNames, interfaces []v1.VirtualMachineInstanceNetworkInterface) []Record {
+	var recordsArr []Record
+	var defaultIPv4s, defaultIPv6s []string
+	for _, iface := range interfaces {
+		IPv4s := getPublishableIPs(iface.IPs, netutils.IsIPv4String)
+		IPv6s := getPublishableIPs(iface.IPs, netutils.IsIPv6String)
+		recordsArr = append(recordsArr, generateRecords(names.interfaceNames[iface.Name], recordTypeA, IPv4s)...)
+		recordsArr = append(recordsArr, generateRecords(names.interfaceNames[iface.Name], recordTypeAAAA, IPv6s)...)
+		if defaultIPv4s == nil {
+			defaultIPv4s = IPv4s
+		}
+		if defaultIPv6s == nil {
+			defaultIPv6s = IPv6s
 		}
 	}
-	return aRecords
+
+	recordsArr = append(recordsArr, generateRecords(names.defaultName, recordTypeA, defaultIPv4s)...)
+	recordsArr = append(recordsArr, generateRecords(names.defaultName, recordTypeAAAA, defaultIPv6s)...)
+	recordsArr = append(recordsArr, generateAliasRecords(names.aliasNames)...)
+	recordsArr = append(recordsArr, generateSRVRecords(names.services)...)
+	return recordsArr
+}
+
+// getPublishableIPs returns the sorted and deduplicated global unicast addresses of the requested IP family, in their
+// canonical form, so the records would not change when the guest agent reorders or reformats the reported IPs.
+// Link-local, loopback and multicast addresses are not reachable outside the guest link, therefore not published.
+func getPublishableIPs(IPs []string, isIPFamily func(string) bool) []string {
+	var publishableIPs []string
+	seen := map[string]bool{}
+	for _, IP := range IPs {
+		if !isIPFamily(IP) {
+			continue
+		}
+		parsedIP := net.ParseIP(IP)
+		if parsedIP == nil || !parsedIP.IsGlobalUnicast() {
+			continue
+		}
+		canonicalIP := parsedIP.String()
+		if !seen[canonicalIP] {
+			seen[canonicalIP] = true
+			publishableIPs = append(publishableIPs, canonicalIP)
+		}
+	}
+	sort.Strings(publishableIPs)
+	return publishableIPs
+}
+
+func generateRecords(fqdn string, recordType string, IPs []string) []Record {
+	var recordsArr []Record
+	for _, IP := range IPs {
+		recordsArr = append(recordsArr, newRecord(fqdn, recordType, IP))
+	}
+	return recordsArr
+}
+
+// Flush regenerates the zone content with a new SOA serial when the records were changed since the last flush, and
+// returns whether the content was regenerated. Records updates are applied to the cache straight away, while the
+// content is generated once per flush, so the SOA serial is bumped once for all the updates of a flush.
+func (zoneFileCache *ZoneFileCache) Flush() bool {
+	if !zoneFileCache.isChanged {
+		return false
+	}
+	zoneFileCache.updateContent()
+	return true
+}
+
+// MarkChanged sets the zone as changed, so the next flush regenerates its content although its records were not changed,
+// e.g. to replace a zone file that was written before a restart
+func (zoneFileCache *ZoneFileCache) MarkChanged() {
+	zoneFileCache.isChanged = true
+}
+
+// ForceUpdate moves the SOA serial forward and regenerates the zone content, so the zone can be rewritten although its records
+// were not changed
+func (zoneFileCache *ZoneFileCache) ForceUpdate() {
+	zoneFileCache.updateContent()
 }
 
 func (zoneFileCache *ZoneFileCache) updateContent() {
-	zoneFileCache.soaSerial++
+	zoneFileCache.isChanged = false
+	fromSerial := zoneFileCache.soaSerial
+	zoneFileCache.soaSerial = zoneFileCache.serialScheme.nextSerial(zoneFileCache.soaSerial, zoneFileCache.now())
 	zoneFileCache.header = zoneFileCache.generateHeader()
-	zoneFileCache.aRecords = zoneFileCache.generateARecords()
+	addedRecords, removedRecords := zoneFileCache.records.merge()
+	if zoneFileCache.journal != nil {
+		zoneFileCache.journal.add(fromSerial, zoneFileCache.soaSerial, addedRecords, removedRecords,
+			len(zoneFileCache.records.sortedRecords))
+	}
+	zoneFileCache.changes = zoneFileCache.records.rrsetChanges(addedRecords, removedRecords)
+	zoneFileCache.aRecords = zoneFileCache.records.render()
 
 	zoneFileCache.Content = zoneFileCache.header + zoneFileCache.aRecords
 }
@@ -5,8 +5,11 @@ import (
 	. "github.com/onsi/gomega"
 
 	"fmt"
+	"math"
 	"sort"
 	"strings"
+	"testing"
+	"time"
 
 	k8stypes "k8s.io/apimachinery/pkg/types"
 	v1 "kubevirt.io/api/core/v1"
@@ -33,7 +36,7 @@ var _ = Describe("cached zone file content maintenance", func() {
 		)
 
 		DescribeTable("generate zone file header", func(nameServerIP, domain, expectedHeader string) {
-			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil)
+			zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
 			Expect(zoneFileCache.header).To(Equal(expectedHeader))
 		},
 			Entry("header should contain default values", "", "vm", headerDefault),
@@ -42,7 +45,7 @@ var _ = Describe("cached zone file content maintenance", func() {
 
 		It("should init header with existing SOA serial", func() {
 			soaSerial := 12345
-			zoneFileCache = NewZoneFileCache("", "vm", &soaSerial)
+			zoneFileCache = NewZoneFileCache("", "vm", &soaSerial, SerialSchemeCounter, nil, newTestNaming(NamingModeName, "vm"), nil)
 			Expect(zoneFileCache.header).To(Equal(headerSoaSerial))
 		})
 	})
@@ -62,9 +65,16 @@ var _ = Describe("cached zone file content maintenance", func() {
 			nic4Name   = "nic4"
 			nic4IP     = "13.14.15.16"
 			IPv6       = "fe80::74c8:f2ff:fe5f:ff2b"
+			nic1IPv6   = "2001:db8::1"
+			nic2IPv6   = "2001:db8::2"
+			vipIP      = "1.2.3.100"
+			vipIPv6    = "2001:db8::100"
+			loopbackIP = "127.0.0.1"
 
-			aRecordFmt        = "%s.%s.%s IN A %s\n"
-			defaultARecordFmt = "%s.%s IN A %s\n"
+			aRecordFmt           = "%s.%s.%s IN A %s\n"
+			defaultARecordFmt    = "%s.%s IN A %s\n"
+			aaaaRecordFmt        = "%s.%s.%s IN AAAA %s\n"
+			defaultAAAARecordFmt = "%s.%s IN AAAA %s\n"
 
 			updated    = true
 			notUpdated = false
@@ -87,19 +97,30 @@ var _ = Describe("cached zone file content maintenance", func() {
 			defARecord_nic1_vm2_ns1 = fmt.Sprintf(defaultARecordFmt, vmi2Name, namespace1, nic1IP)
 			defARecord_nic3_vm2_ns1 = fmt.Sprintf(defaultARecordFmt, vmi2Name, namespace1, nic3IP)
 			defARecord_nic3_vm1_ns2 = fmt.Sprintf(defaultARecordFmt, vmi1Name, namespace2, nic3IP)
+
+			aaaaRecord_nic1_vm1_ns1    = fmt.Sprintf(aaaaRecordFmt, nic1Name, vmi1Name, namespace1, nic1IPv6)
+			aaaaRecord_nic2_vm1_ns1    = fmt.Sprintf(aaaaRecordFmt, nic2Name, vmi1Name, namespace1, nic2IPv6)
+			defAAAARecord_nic1_vm1_ns1 = fmt.Sprintf(defaultAAAARecordFmt, vmi1Name, namespace1, nic1IPv6)
+			defAAAARecord_nic2_vm1_ns1 = fmt.Sprintf(defaultAAAARecordFmt, vmi1Name, namespace1, nic2IPv6)
+
+			aRecord_vip_nic1_vm1_ns1       = fmt.Sprintf(aRecordFmt, nic1Name, vmi1Name, namespace1, vipIP)
+			aaaaRecord_vip_nic1_vm1_ns1    = fmt.Sprintf(aaaaRecordFmt, nic1Name, vmi1Name, namespace1, vipIPv6)
+			defARecord_vip_nic1_vm1_ns1    = fmt.Sprintf(defaultARecordFmt, vmi1Name, namespace1, vipIP)
+			defAAAARecord_vip_nic1_vm1_ns1 = fmt.Sprintf(defaultAAAARecordFmt, vmi1Name, namespace1, vipIPv6)
 		)
 
 		validateUpdateFunc := func(vmiName, vmiNamespace string, newInterfaces []v1.VirtualMachineInstanceNetworkInterface,
 			expectedIsUpdated bool, expectedRecords string, expectedSoaSerial int) {
-			isUpdated := zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: vmiNamespace, Name: vmiName}, newInterfaces)
+			isUpdated := zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: vmiNamespace, Name: vmiName}, newVMI(newInterfaces)).IsChanged()
 			Expect(isUpdated).To(Equal(expectedIsUpdated))
+			zoneFileCache.Flush()
 			Expect(sortRecords(zoneFileCache.aRecords)).To(Equal(sortRecords(expectedRecords)))
-			Expect(zoneFileCache.soaSerial).To(Equal(expectedSoaSerial))
+			Expect(zoneFileCache.soaSerial).To(Equal(uint32(expectedSoaSerial)))
 		}
 
 		When("interfaces records list is empty", func() {
 			BeforeEach(func() {
-				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil)
+				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
 			})
 
 			DescribeTable("Updating interfaces records", validateUpdateFunc,
@@ -125,19 +146,60 @@ var _ = Describe("cached zone file content maintenance", func() {
 		When("SOA serial already exist", func() {
 			It("should init SOA serial with the existing value", func() {
 				soaSerial := 5
-				zoneFileCache = NewZoneFileCache("", "", &soaSerial)
+				zoneFileCache = NewZoneFileCache("", "", &soaSerial, SerialSchemeCounter, nil, newTestNaming(NamingModeName, ""), nil)
 				zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi1Name},
-					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}})
-				Expect(zoneFileCache.soaSerial).To(Equal(6))
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}}))
+				zoneFileCache.Flush()
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(6)))
+			})
+
+			It("should bump SOA serial once for all the updates of a flush", func() {
+				soaSerial := 5
+				zoneFileCache = NewZoneFileCache("", "", &soaSerial, SerialSchemeCounter, nil, newTestNaming(NamingModeName, ""), nil)
+				Expect(zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi1Name},
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}})).IsChanged()).To(BeTrue())
+				Expect(zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi2Name},
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic2IP}, Name: nic2Name}})).IsChanged()).To(BeTrue())
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(5)))
+
+				Expect(zoneFileCache.Flush()).To(BeTrue())
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(6)))
+				Expect(zoneFileCache.Content).To(ContainSubstring(nic2IP))
+				Expect(zoneFileCache.Flush()).To(BeFalse())
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(6)))
+			})
+
+			It("should wrap the SOA serial around", func() {
+				soaSerial := math.MaxUint32
+				zoneFileCache = NewZoneFileCache("", "vm", &soaSerial, SerialSchemeCounter, nil, newTestNaming(NamingModeName, "vm"), nil)
+				zoneFileCache.ForceUpdate()
+				Expect(zoneFileCache.header).To(ContainSubstring("SOA ns.vm. email.vm. (0 "))
+			})
+
+			It("should move a date based SOA serial forward across restarts and clock skew", func() {
+				soaSerial := 2026101805
+				zoneFileCache = NewZoneFileCache("", "vm", &soaSerial, SerialSchemeDate, nil, newTestNaming(NamingModeName, "vm"), nil)
+				zoneFileCache.now = func() time.Time { return time.Date(2026, time.October, 18, 9, 0, 0, 0, time.UTC) }
+				zoneFileCache.ForceUpdate()
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(2026101806)))
+
+				zoneFileCache.now = func() time.Time { return time.Date(2026, time.October, 17, 9, 0, 0, 0, time.UTC) }
+				zoneFileCache.ForceUpdate()
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(2026101807)))
+
+				zoneFileCache.now = func() time.Time { return time.Date(2026, time.October, 19, 9, 0, 0, 0, time.UTC) }
+				zoneFileCache.ForceUpdate()
+				Expect(zoneFileCache.soaSerial).To(Equal(uint32(2026101900)))
 			})
 		})
 
 		When("interfaces records list contains single vmi", func() {
 			BeforeEach(func() {
-				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil)
+				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
 				isUpdated := zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi1Name},
-					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})).IsChanged()
 				Expect(isUpdated).To(BeTrue())
+				Expect(zoneFileCache.Flush()).To(BeTrue())
 			})
 
 			DescribeTable("Updating interfaces records list", validateUpdateFunc,
@@ -187,13 +249,15 @@ var _ = Describe("cached zone file content maintenance", func() {
 
 		When("interfaces records list contains multiple vmis", func() {
 			BeforeEach(func() {
-				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil)
+				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
 				isUpdated := zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi1Name},
-					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})).IsChanged()
 				Expect(isUpdated).To(BeTrue())
+				Expect(zoneFileCache.Flush()).To(BeTrue())
 				isUpdated = zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi2Name},
-					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})).IsChanged()
 				Expect(isUpdated).To(BeTrue())
+				Expect(zoneFileCache.Flush()).To(BeTrue())
 			})
 
 			DescribeTable("update interfaces records list", validateUpdateFunc,
@@ -254,7 +318,7 @@ var _ = Describe("cached zone file content maintenance", func() {
 
 		When("interfaces records list contains vmi with multiple IPs", func() {
 			BeforeEach(func() {
-				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil)
+				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
 			})
 
 			DescribeTable("Updating interfaces records list", validateUpdateFunc,
@@ -268,7 +332,7 @@ var _ = Describe("cached zone file content maintenance", func() {
 						defARecord_nic1_vm1_ns1,
 					1,
 				),
-				Entry("vmi interfaces contain IPv6 only",
+				Entry("vmi interfaces contain link-local IPv6 only",
 					vmi1Name,
 					namespace1,
 					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IPv6}, Name: nic1Name}, {IPs: []string{IPv6}, Name: nic2Name}},
@@ -276,13 +340,251 @@ var _ = Describe("cached zone file content maintenance", func() {
 					"",
 					0,
 				),
+				Entry("vmi interfaces contain IPv4 and global IPv6",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP, IPv6, nic1IPv6}, Name: nic1Name}, {IPs: []string{nic2IPv6, nic2IP}, Name: nic2Name}},
+					updated,
+					aRecord_nic1_vm1_ns1+
+						aRecord_nic2_vm1_ns1+
+						aaaaRecord_nic1_vm1_ns1+
+						aaaaRecord_nic2_vm1_ns1+
+						defARecord_nic1_vm1_ns1+
+						defAAAARecord_nic1_vm1_ns1,
+					1,
+				),
+				Entry("vmi interfaces contain global IPv6 only",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic2IPv6}, Name: nic2Name}, {IPs: []string{IPv6, nic1IPv6}, Name: nic1Name}},
+					updated,
+					aaaaRecord_nic1_vm1_ns1+
+						aaaaRecord_nic2_vm1_ns1+
+						defAAAARecord_nic1_vm1_ns1,
+					1,
+				),
+				Entry("vmi default records are taken from the first interface of each IP family",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP}, Name: nic1Name}, {IPs: []string{nic2IPv6}, Name: nic2Name}},
+					updated,
+					aRecord_nic1_vm1_ns1+
+						aaaaRecord_nic2_vm1_ns1+
+						defARecord_nic1_vm1_ns1+
+						defAAAARecord_nic2_vm1_ns1,
+					1,
+				),
 			)
 		})
+
+		When("interfaces records list contains vmi with multiple addresses per interface", func() {
+			BeforeEach(func() {
+				zoneFileCache = NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+				isUpdated := zoneFileCache.UpdateVMIRecords(k8stypes.NamespacedName{Namespace: namespace1, Name: vmi1Name},
+					newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP, vipIP, nic1IPv6, vipIPv6}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}})).IsChanged()
+				Expect(isUpdated).To(BeTrue())
+				Expect(zoneFileCache.Flush()).To(BeTrue())
+			})
+
+			DescribeTable("Updating interfaces records list", validateUpdateFunc,
+				Entry("when the interface addresses order is changed",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{vipIPv6, vipIP, nic1IPv6, nic1IP}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}},
+					notUpdated,
+					aRecord_nic1_vm1_ns1+
+						aRecord_vip_nic1_vm1_ns1+
+						aaaaRecord_nic1_vm1_ns1+
+						aaaaRecord_vip_nic1_vm1_ns1+
+						aRecord_nic2_vm1_ns1+
+						defARecord_nic1_vm1_ns1+
+						defARecord_vip_nic1_vm1_ns1+
+						defAAAARecord_nic1_vm1_ns1+
+						defAAAARecord_vip_nic1_vm1_ns1,
+					1,
+				),
+				Entry("when non global addresses and duplicates are reported",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{loopbackIP, IPv6, nic1IP, vipIP, nic1IP, nic1IPv6, "2001:DB8::100"}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}},
+					notUpdated,
+					aRecord_nic1_vm1_ns1+
+						aRecord_vip_nic1_vm1_ns1+
+						aaaaRecord_nic1_vm1_ns1+
+						aaaaRecord_vip_nic1_vm1_ns1+
+						aRecord_nic2_vm1_ns1+
+						defARecord_nic1_vm1_ns1+
+						defARecord_vip_nic1_vm1_ns1+
+						defAAAARecord_nic1_vm1_ns1+
+						defAAAARecord_vip_nic1_vm1_ns1,
+					1,
+				),
+				Entry("when a secondary address is removed from the interface",
+					vmi1Name,
+					namespace1,
+					[]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{nic1IP, nic1IPv6, vipIPv6}, Name: nic1Name}, {IPs: []string{nic2IP}, Name: nic2Name}},
+					updated,
+					aRecord_nic1_vm1_ns1+
+						aaaaRecord_nic1_vm1_ns1+
+						aaaaRecord_vip_nic1_vm1_ns1+
+						aRecord_nic2_vm1_ns1+
+						defARecord_nic1_vm1_ns1+
+						defAAAARecord_nic1_vm1_ns1+
+						defAAAARecord_vip_nic1_vm1_ns1,
+					2,
+				),
+			)
+		})
+	})
+
+	Describe("cached zone file records order", func() {
+		var (
+			vmi1 = k8stypes.NamespacedName{Namespace: "ns2", Name: "vmi1"}
+			vmi2 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi2"}
+			vmi3 = k8stypes.NamespacedName{Namespace: "ns1", Name: "vmi3"}
+		)
+
+		newOrderVMI := func(IPs ...string) *v1.VirtualMachineInstance {
+			return newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: IPs, Name: "nic1"}, {IPs: []string{"10.0.1.1"}, Name: "nic2"}})
+		}
+
+		newPopulatedCache := func(keys ...k8stypes.NamespacedName) *ZoneFileCache {
+			zoneFileCache := NewZoneFileCache(nameServerIP, domain, nil, SerialSchemeCounter, nil, newTestNaming(NamingModeName, domain), nil)
+			for _, key := range keys {
+				Expect(zoneFileCache.UpdateVMIRecords(key, newOrderVMI("10.0.0.1", "2001:db8::1")).IsChanged()).To(BeTrue())
+			}
+			Expect(zoneFileCache.Flush()).To(BeTrue())
+			return zoneFileCache
+		}
+
+		It("should render the records in canonical order", func() {
+			zoneFileCache = newPopulatedCache(vmi1, vmi2)
+			Expect(zoneFileCache.aRecords).To(Equal(
+				"vmi2.ns1 IN A 10.0.0.1\n" +
+					"vmi2.ns1 IN AAAA 2001:db8::1\n" +
+					"nic1.vmi2.ns1 IN A 10.0.0.1\n" +
+					"nic1.vmi2.ns1 IN AAAA 2001:db8::1\n" +
+					"nic2.vmi2.ns1 IN A 10.0.1.1\n" +
+					"vmi1.ns2 IN A 10.0.0.1\n" +
+					"vmi1.ns2 IN AAAA 2001:db8::1\n" +
+					"nic1.vmi1.ns2 IN A 10.0.0.1\n" +
+					"nic1.vmi1.ns2 IN AAAA 2001:db8::1\n" +
+					"nic2.vmi1.ns2 IN A 10.0.1.1\n"))
+		})
+
+		It("should render the same records when the cache is rendered twice", func() {
+			zoneFileCache = newPopulatedCache(vmi1, vmi2, vmi3)
+			aRecords := zoneFileCache.aRecords
+			zoneFileCache.ForceUpdate()
+			Expect(zoneFileCache.aRecords).To(Equal(aRecords))
+		})
+
+		It("should render the same records regardless of the updates order", func() {
+			Expect(newPopulatedCache(vmi3, vmi2, vmi1).aRecords).To(Equal(newPopulatedCache(vmi1, vmi2, vmi3).aRecords))
+		})
+
+		It("should render the same records after unrelated updates", func() {
+			zoneFileCache = newPopulatedCache(vmi1, vmi2)
+			aRecords := zoneFileCache.aRecords
+
+			Expect(zoneFileCache.UpdateVMIRecords(vmi3, newOrderVMI("10.0.0.3")).IsChanged()).To(BeTrue())
+			Expect(zoneFileCache.Flush()).To(BeTrue())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi3, newOrderVMI("10.0.0.4")).IsChanged()).To(BeTrue())
+			Expect(zoneFileCache.Flush()).To(BeTrue())
+			Expect(zoneFileCache.UpdateVMIRecords(vmi3, nil).IsChanged()).To(BeTrue())
+			Expect(zoneFileCache.Flush()).To(BeTrue())
+			Expect(zoneFileCache.aRecords).To(Equal(aRecords))
+		})
 	})
 })
 
+func newTestNaming(namingMode NamingMode, domain string) *Naming {
+	naming, err := NewNaming(namingMode, "", "", "", domain)
+	Expect(err).ToNot(HaveOccurred())
+	return naming
+}
+
+func newVMI(interfaces []v1.VirtualMachineInstanceNetworkInterface) *v1.VirtualMachineInstance {
+	if interfaces == nil {
+		return nil
+	}
+	return &v1.VirtualMachineInstance{Status: v1.VirtualMachineInstanceStatus{Interfaces: interfaces}}
+}
+
 func sortRecords(recordsStr string) (sortedRecordsStr string) {
 	strArr := strings.Split(recordsStr, "\n")
 	sort.Strings(strArr)
 	return strings.Join(strArr, "\n")
 }
+
+func BenchmarkPopulate10k(b *testing.B) {
+	benchmarkPopulate(b, 10000)
+}
+
+func BenchmarkPopulate50k(b *testing.B) {
+	benchmarkPopulate(b, 50000)
+}
+
+func BenchmarkUpdateAndFlush10k(b *testing.B) {
+	benchmarkUpdateAndFlush(b, 10000)
+}
+
+func BenchmarkUpdateAndFlush50k(b *testing.B) {
+	benchmarkUpdateAndFlush(b, 50000)
+}
+
+// benchmarkPopulate measures the publication of all the VMIs of a cluster, as done on startup
+func benchmarkPopulate(b *testing.B, vmiCount int) {
+	vmis := newBenchmarkVMIs(vmiCount, 0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		zoneFileCache := newBenchmarkZoneFileCache(b)
+		for key, vmi := range vmis {
+			zoneFileCache.UpdateVMIRecords(key, vmi)
+		}
+		zoneFileCache.Flush()
+	}
+}
+
+// benchmarkUpdateAndFlush measures the update of a single VMI in a populated zone
+func benchmarkUpdateAndFlush(b *testing.B, vmiCount int) {
+	zoneFileCache := newBenchmarkZoneFileCache(b)
+	for key, vmi := range newBenchmarkVMIs(vmiCount, 0) {
+		zoneFileCache.UpdateVMIRecords(key, vmi)
+	}
+	zoneFileCache.Flush()
+	updatedVMIs := newBenchmarkVMIs(1, 1)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for key, vmi := range updatedVMIs {
+			if i%2 == 0 {
+				zoneFileCache.UpdateVMIRecords(key, vmi)
+			} else {
+				zoneFileCache.UpdateVMIRecords(key, nil)
+			}
+		}
+		zoneFileCache.Flush()
+	}
+}
+
+func newBenchmarkZoneFileCache(b *testing.B) *ZoneFileCache {
+	naming, err := NewNaming(NamingModeName, "", "", "", "vm")
+	if err != nil {
+		b.Fatal(err)
+	}
+	return NewZoneFileCache("", "vm", nil, SerialSchemeCounter, nil, naming, nil)
+}
+
+// newBenchmarkVMIs returns VMIs with two interfaces each, the IPs are derived from the VMI index and the offset
+func newBenchmarkVMIs(vmiCount int, offset int) map[k8stypes.NamespacedName]*v1.VirtualMachineInstance {
+	vmis := make(map[k8stypes.NamespacedName]*v1.VirtualMachineInstance, vmiCount)
+	for i := 0; i < vmiCount; i++ {
+		key := k8stypes.NamespacedName{Namespace: fmt.Sprintf("ns%d", i%100), Name: fmt.Sprintf("vmi%d", i)}
+		ipIndex := i + offset
+		vmis[key] = newVMI([]v1.VirtualMachineInstanceNetworkInterface{
+			{Name: "nic1", IPs: []string{fmt.Sprintf("10.%d.%d.%d", ipIndex>>16&0xff, ipIndex>>8&0xff, ipIndex&0xff)}},
+			{Name: "nic2", IPs: []string{fmt.Sprintf("fd00::%x", ipIndex)}},
+		})
+	}
+	return vmis
+}
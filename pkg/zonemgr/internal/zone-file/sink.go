@@ -0,0 +1,36 @@
+package zone_file
+
+import (
+	"fmt"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+// Sink writes the zones updates into the zone files, which CoreDNS serves. The whole content of a zone is written on
+// each update, so a resync is not different from any other update.
+type Sink struct {
+	zoneFiles map[string]ZoneFileInterface
+}
+
+func NewSink() *Sink {
+	return &Sink{zoneFiles: map[string]ZoneFileInterface{}}
+}
+
+// AddZone sets the zone file the updates of the zone origin are written to
+func (fileSink *Sink) AddZone(origin string, zoneFile ZoneFileInterface) {
+	fileSink.zoneFiles[dns.CanonicalName(origin)] = zoneFile
+}
+
+func (fileSink *Sink) Name() string {
+	return "zone-files"
+}
+
+func (fileSink *Sink) Update(update sink.ZoneUpdate) error {
+	zoneFile, exists := fileSink.zoneFiles[dns.CanonicalName(update.Origin)]
+	if !exists {
+		return fmt.Errorf("zone %s has no zone file", update.Origin)
+	}
+	return zoneFile.WriteFile(update.Content)
+}
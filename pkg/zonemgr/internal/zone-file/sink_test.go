@@ -0,0 +1,45 @@
+package zone_file_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"os"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+var _ = Describe("zone files sink", func() {
+	const (
+		zoneFileName = "zones/db.vm"
+		zoneContent  = "$ORIGIN vm. \n$TTL 3600 \n@ IN SOA ns.vm. email.vm. (1 3600 3600 1209600 3600)\nnic1.vmi1.ns1 IN A 10.0.0.1\n"
+	)
+	var fileSink *zone_file.Sink
+
+	BeforeEach(func() {
+		Expect(os.Mkdir("zones", 0777)).To(Succeed())
+		fileSink = zone_file.NewSink()
+		fileSink.AddZone("vm", zone_file.NewZoneFile(zoneFileName))
+	})
+	AfterEach(func() {
+		Expect(os.RemoveAll("zones")).To(Succeed())
+	})
+
+	It("should write the zone content into the zone file", func() {
+		Expect(fileSink.Update(sink.ZoneUpdate{Origin: "vm.", Content: zoneContent})).To(Succeed())
+		content, err := os.ReadFile(zoneFileName)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(content)).To(Equal(zoneContent))
+	})
+
+	It("should fail to update a zone that has no zone file", func() {
+		Expect(fileSink.Update(sink.ZoneUpdate{Origin: "0.0.10.in-addr.arpa.", Content: zoneContent})).ToNot(Succeed())
+		Expect(zoneFileName).ToNot(BeAnExistingFile())
+	})
+
+	It("should fail to update a zone with an invalid content", func() {
+		Expect(fileSink.Update(sink.ZoneUpdate{Origin: "vm.", Content: "nic1.vmi1.ns1 IN A 10.0.0.1\n"})).ToNot(Succeed())
+		Expect(zoneFileName).ToNot(BeAnExistingFile())
+	})
+})
@@ -4,22 +4,86 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	zoneFilePerm = 0644
+	// zoneFileNamePrefix is the prefix of the zone files names, which the CoreDNS auto plugin loads as zones
+	zoneFileNamePrefix = "db."
 )
 
-const zoneFilePerm = 0644
+const (
+	// The temporary and the last-known-good files are hidden, so the CoreDNS auto plugin does not load them as zones
+	tempFileNameFmt   = ".%s.tmp"
+	backupFileNameFmt = ".%s.last-good"
+)
 
 var soaSerialReg = regexp.MustCompile("SOA .*\\(([0-9]+) ") //nolint:gosimple
 
+// ErrCorruptZoneFile is returned by ReadSoaSerial when the zone file is corrupt, whether the last-known-good zone was
+// restored or not
+var ErrCorruptZoneFile = errors.New("corrupt zone file")
+
+// CorruptZoneFileError is the ErrCorruptZoneFile error of a zone file. RestoredSoaSerial is the SOA serial of the
+// last-known-good zone when it replaced the corrupt zone file, otherwise it is nil. LastGoodSoaSerial is the SOA serial
+// of the last-known-good zone when it could still be read, although the zone could not be restored, otherwise it is nil.
+type CorruptZoneFileError struct {
+	Err               error
+	RestoredSoaSerial *int
+	LastGoodSoaSerial *int
+}
+
+func (e *CorruptZoneFileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CorruptZoneFileError) Unwrap() error {
+	return e.Err
+}
+
 type ZoneFile struct {
 	zoneFileFullName string
+	tempFileFullName string
+	backupFullName   string
 }
 
 func NewZoneFile(fileName string) ZoneFileInterface {
+	dir, base := filepath.Split(fileName)
 	return &ZoneFile{
 		zoneFileFullName: fileName,
+		tempFileFullName: filepath.Join(dir, fmt.Sprintf(tempFileNameFmt, base)),
+		backupFullName:   filepath.Join(dir, fmt.Sprintf(backupFileNameFmt, base)),
+	}
+}
+
+// ZoneFileName returns the name of the zone file of the zone origin in the zones directory
+func ZoneFileName(dir string, origin string) string {
+	return filepath.Join(dir, zoneFileNamePrefix+origin)
+}
+
+// ListZones returns the origins of the zones that have a zone file in the zones directory, in lexical order. A missing
+// directory has no zones.
+func ListZones(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+	var origins []string
+	for _, entry := range entries {
+		if origin, isZoneFile := strings.CutPrefix(entry.Name(), zoneFileNamePrefix); isZoneFile && entry.Type().IsRegular() {
+			origins = append(origins, origin)
+		}
+	}
+	return origins, nil
 }
 
 type ZoneFileInterface interface {
@@ -27,18 +91,88 @@ type ZoneFileInterface interface {
 	ReadSoaSerial() (*int, error)
 }
 
+// WriteFile validates the zone content and atomically replaces the zone file with it, so a reader never observes a
+// partially written zone. A copy of the content is kept as the last-known-good zone, to recover from a corrupt zone
+// file on startup. An invalid content is not written and the zone file is left untouched.
 func (zoneFile *ZoneFile) WriteFile(content string) (err error) {
-	return os.WriteFile(zoneFile.zoneFileFullName, []byte(content), zoneFilePerm)
+	if err = validateZone(content); err != nil {
+		return fmt.Errorf("refusing to write an invalid zone to %s: %w", zoneFile.zoneFileFullName, err)
+	}
+	if err = zoneFile.writeFileAtomically(zoneFile.backupFullName, content); err != nil {
+		return err
+	}
+	return zoneFile.writeFileAtomically(zoneFile.zoneFileFullName, content)
+}
+
+// writeFileAtomically writes the content to the temporary file, syncs it to the disk and renames it to the file name
+func (zoneFile *ZoneFile) writeFileAtomically(fileName string, content string) (err error) {
+	tempFile, err := os.OpenFile(zoneFile.tempFileFullName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, zoneFilePerm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(zoneFile.tempFileFullName)
+		}
+	}()
+
+	if _, err = tempFile.WriteString(content); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+	if err = tempFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(zoneFile.tempFileFullName, fileName); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(fileName))
+}
+
+// syncDir persists the directory entries, so a rename is not lost on a crash
+func syncDir(dirName string) error {
+	dir, err := os.Open(dirName)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// validateZone parses the zone content with a zone file parser, the content must include a SOA record. The parser
+// accepts a last line that is cut in the middle, therefore the content is also expected to end with a new line.
+func validateZone(content string) error {
+	if !strings.HasSuffix(content, "\n") {
+		return errors.New("the zone does not end with a new line, it may be truncated")
+	}
+	zoneParser := dns.NewZoneParser(strings.NewReader(content), "", "")
+	hasSOA := false
+	for record, ok := zoneParser.Next(); ok; record, ok = zoneParser.Next() {
+		if record.Header().Rrtype == dns.TypeSOA {
+			hasSOA = true
+		}
+	}
+	if err := zoneParser.Err(); err != nil {
+		return err
+	}
+	if !hasSOA {
+		return errors.New("the zone has no SOA record")
+	}
+	return nil
 }
 
-func (zoneFile *ZoneFile) readFile() ([]byte, error) {
-	return os.ReadFile(zoneFile.zoneFileFullName)
+func readFile(fileName string) ([]byte, error) {
+	return os.ReadFile(fileName)
 }
 
-func (zoneFile *ZoneFile) isFileExist() (bool, error) {
+func isFileExist(fileName string) (bool, error) {
 	var err error
 	isExist := false
-	if _, err = os.Stat(zoneFile.zoneFileFullName); err == nil {
+	if _, err = os.Stat(fileName); err == nil {
 		isExist = true
 	} else if errors.Is(err, os.ErrNotExist) {
 		err = nil
@@ -46,15 +180,76 @@ func (zoneFile *ZoneFile) isFileExist() (bool, error) {
 	return isExist, err
 }
 
+// ReadSoaSerial returns the SOA serial of the zone file, or nil when there is no zone file. A corrupt zone file, e.g.
+// truncated by a crash, is replaced by the last-known-good zone, if there is one, and a CorruptZoneFileError with the
+// serial of that zone is returned, so the corruption is reported.
 func (zoneFile *ZoneFile) ReadSoaSerial() (*int, error) {
-	if isFileExist, err := zoneFile.isFileExist(); !isFileExist || err != nil {
+	if isFileExist, err := isFileExist(zoneFile.zoneFileFullName); !isFileExist || err != nil {
 		return nil, err
 	}
-	if content, err := zoneFile.readFile(); content == nil || err != nil {
+	content, err := readFile(zoneFile.zoneFileFullName)
+	if err != nil {
 		return nil, err
-	} else {
-		return fetchSoaSerial(string(content))
 	}
+	soaSerial, err := fetchValidSoaSerial(string(content))
+	if err == nil {
+		return soaSerial, nil
+	}
+
+	backupSoaSerial, backupErr := zoneFile.restoreBackup()
+	if backupErr != nil {
+		return nil, &CorruptZoneFileError{
+			Err: fmt.Errorf("%w %s: %v, and failed to restore the last-known-good zone: %v", ErrCorruptZoneFile,
+				zoneFile.zoneFileFullName, err, backupErr),
+			LastGoodSoaSerial: zoneFile.readBackupSoaSerial(),
+		}
+	}
+	return nil, &CorruptZoneFileError{
+		Err:               fmt.Errorf("%w %s: %v, the last-known-good zone was restored", ErrCorruptZoneFile, zoneFile.zoneFileFullName, err),
+		RestoredSoaSerial: backupSoaSerial,
+	}
+}
+
+// readBackupSoaSerial returns the SOA serial of the last-known-good zone, even when the zone is not valid, or nil when
+// it can not be read
+func (zoneFile *ZoneFile) readBackupSoaSerial() *int {
+	content, err := readFile(zoneFile.backupFullName)
+	if err != nil {
+		return nil
+	}
+	soaSerial, err := fetchSoaSerial(string(content))
+	if err != nil {
+		return nil
+	}
+	return soaSerial
+}
+
+// restoreBackup replaces the zone file by the last-known-good zone and returns its SOA serial
+func (zoneFile *ZoneFile) restoreBackup() (*int, error) {
+	if isFileExist, err := isFileExist(zoneFile.backupFullName); err != nil {
+		return nil, err
+	} else if !isFileExist {
+		return nil, errors.New("there is no last-known-good zone")
+	}
+	content, err := readFile(zoneFile.backupFullName)
+	if err != nil {
+		return nil, err
+	}
+	soaSerial, err := fetchValidSoaSerial(string(content))
+	if err != nil {
+		return nil, err
+	}
+	if err = zoneFile.writeFileAtomically(zoneFile.zoneFileFullName, string(content)); err != nil {
+		return nil, err
+	}
+	return soaSerial, nil
+}
+
+func fetchValidSoaSerial(content string) (*int, error) {
+	if err := validateZone(content); err != nil {
+		return nil, fmt.Errorf("invalid zone file content: %w", err)
+	}
+	return fetchSoaSerial(content)
 }
 
 func fetchSoaSerial(content string) (*int, error) {
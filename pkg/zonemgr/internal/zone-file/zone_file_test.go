@@ -4,6 +4,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"errors"
 	"os"
 
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file"
@@ -13,9 +14,12 @@ var _ = Describe("disk zone file maintenance", func() {
 
 	const (
 		zoneFileName           = "zones/db.vm"
-		zoneFileContent        = "zone file content"
-		zoneFileUpdatedContent = "zone file updated content"
+		backupFileName         = "zones/.db.vm.last-good"
+		tempFileName           = "zones/.db.vm.tmp"
 		headerSoaSerial        = "$ORIGIN vm. \n$TTL 3600 \n@ IN SOA ns.vm. email.vm. (12345 3600 3600 1209600 3600)\n"
+		zoneFileContent        = headerSoaSerial + "nic1.vmi1.ns1 IN A 10.0.0.1\n"
+		zoneFileUpdatedContent = headerSoaSerial + "nic1.vmi1.ns1 IN A 10.0.0.2\n"
+		truncatedContent       = "$ORIGIN vm. \n$TTL 3600 \n@ IN SOA ns.vm. email.vm. (12"
 	)
 	var zoneFile zone_file.ZoneFileInterface
 
@@ -32,6 +36,7 @@ var _ = Describe("disk zone file maintenance", func() {
 		content, err := os.ReadFile(zoneFileName)
 		Expect(err).To(BeNil())
 		Expect(string(content)).To(Equal(expectedFileContent))
+		Expect(tempFileName).ToNot(BeAnExistingFile())
 	}
 
 	When("zone file does not exist", func() {
@@ -67,4 +72,87 @@ var _ = Describe("disk zone file maintenance", func() {
 			Expect(*soaSerial).To(Equal(12345))
 		})
 	})
+
+	When("zone content is invalid", func() {
+		DescribeTable("should not override the zone file", func(invalidContent string) {
+			Expect(zoneFile.WriteFile(zoneFileContent)).To(Succeed())
+			Expect(zoneFile.WriteFile(invalidContent)).ToNot(Succeed())
+
+			content, err := os.ReadFile(zoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(zoneFileContent))
+			Expect(tempFileName).ToNot(BeAnExistingFile())
+		},
+			Entry("truncated zone", truncatedContent),
+			Entry("zone without SOA record", "$ORIGIN vm. \nnic1.vmi1.ns1 IN A 10.0.0.1\n"),
+			Entry("record with an invalid IP", headerSoaSerial+"nic1.vmi1.ns1 IN A 10.0.0\n"),
+		)
+	})
+
+	When("zone file is corrupt", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(zoneFileName, []byte(truncatedContent), 0644)).To(Succeed())
+		})
+
+		It("should restore the last-known-good zone and report its SOA serial", func() {
+			Expect(os.WriteFile(backupFileName, []byte(zoneFileContent), 0644)).To(Succeed())
+
+			_, err := zoneFile.ReadSoaSerial()
+			Expect(err).To(MatchError(zone_file.ErrCorruptZoneFile))
+			var corruptZoneFileErr *zone_file.CorruptZoneFileError
+			Expect(errors.As(err, &corruptZoneFileErr)).To(BeTrue())
+			Expect(corruptZoneFileErr.RestoredSoaSerial).To(HaveValue(Equal(12345)))
+			Expect(corruptZoneFileErr.LastGoodSoaSerial).To(BeNil())
+			content, err := os.ReadFile(zoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(zoneFileContent))
+		})
+
+		It("should keep the last-known-good zone on write", func() {
+			Expect(zoneFile.WriteFile(zoneFileUpdatedContent)).To(Succeed())
+			content, err := os.ReadFile(backupFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(zoneFileUpdatedContent))
+		})
+
+		It("should fail to read SOA serial when there is no last-known-good zone", func() {
+			_, err := zoneFile.ReadSoaSerial()
+			Expect(err).To(MatchError(zone_file.ErrCorruptZoneFile))
+		})
+
+		It("should report the SOA serial of a last-known-good zone that can not be restored", func() {
+			Expect(os.WriteFile(backupFileName, []byte(headerSoaSerial+"nic1.vmi1.ns1 IN A 10.0.0\n"), 0644)).To(Succeed())
+
+			_, err := zoneFile.ReadSoaSerial()
+			Expect(err).To(MatchError(zone_file.ErrCorruptZoneFile))
+			var corruptZoneFileErr *zone_file.CorruptZoneFileError
+			Expect(errors.As(err, &corruptZoneFileErr)).To(BeTrue())
+			Expect(corruptZoneFileErr.LastGoodSoaSerial).To(HaveValue(Equal(12345)))
+		})
+
+		It("should report no last-known-good SOA serial when there is no last-known-good zone", func() {
+			_, err := zoneFile.ReadSoaSerial()
+			var corruptZoneFileErr *zone_file.CorruptZoneFileError
+			Expect(errors.As(err, &corruptZoneFileErr)).To(BeTrue())
+			Expect(corruptZoneFileErr.LastGoodSoaSerial).To(BeNil())
+		})
+	})
+
+	Describe("zones directory", func() {
+		It("should name the zone file after the zone origin", func() {
+			Expect(zone_file.ZoneFileName("/zones", "0.0.10.in-addr.arpa")).To(Equal("/zones/db.0.0.10.in-addr.arpa"))
+		})
+
+		It("should list the zones that have a zone file", func() {
+			Expect(zone_file.NewZoneFile("zones/db.0.0.10.in-addr.arpa").WriteFile(zoneFileContent)).To(Succeed())
+			Expect(zoneFile.WriteFile(zoneFileContent)).To(Succeed())
+			Expect(os.WriteFile("zones/Corefile", []byte{}, 0644)).To(Succeed())
+			Expect(os.Mkdir("zones/db.dir", 0777)).To(Succeed())
+			Expect(zone_file.ListZones("zones")).To(Equal([]string{"0.0.10.in-addr.arpa", "vm"}))
+		})
+
+		It("should list no zones when the zones directory does not exist", func() {
+			Expect(zone_file.ListZones("zones/missing")).To(BeEmpty())
+		})
+	})
 })
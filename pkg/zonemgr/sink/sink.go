@@ -0,0 +1,84 @@
+package sink
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// Operation is the operation of an RRset change
+type Operation string
+
+const (
+	// Add adds the RRset of an owner name and type that had no records
+	Add Operation = "add"
+	// Remove removes all the records of an owner name and type
+	Remove Operation = "remove"
+	// Replace replaces the records of an owner name and type
+	Replace Operation = "replace"
+)
+
+// RRset is the records of an owner name and type, the name is absolute and lower cased
+type RRset struct {
+	Name string
+	Type uint16
+	RRs  []dns.RR
+}
+
+// RRsetChange is the change of an RRset. The RRset holds the records after the change, or the removed records for a
+// Remove.
+type RRsetChange struct {
+	Operation Operation
+	RRset     RRset
+}
+
+// ZoneUpdate is the state of a zone after a flush, along with the changes of its RRsets since the update the sink
+// applied before. The changes exclude the SOA record and the name servers of the zone, the snapshot has them.
+type ZoneUpdate struct {
+	// Origin is the absolute origin of the zone
+	Origin string
+	SOA    *dns.SOA
+	// Resync is set when the sink has to rebuild the zone from the snapshot, the changes are then not set. The first
+	// update of a zone is a resync, and so is the update that follows a failed update.
+	Resync  bool
+	Changes []RRsetChange
+	// Content is the snapshot of the zone, in the zone file format
+	Content string
+}
+
+// RRsets parses the snapshot of the zone into its RRsets, in the order of the zone content
+func (update ZoneUpdate) RRsets() ([]RRset, error) {
+	type rrsetKey struct {
+		name     string
+		typeCode uint16
+	}
+	var rrsets []RRset
+	rrsetIndexes := map[rrsetKey]int{}
+
+	zoneParser := dns.NewZoneParser(strings.NewReader(update.Content), update.Origin, "")
+	for rr, ok := zoneParser.Next(); ok; rr, ok = zoneParser.Next() {
+		key := rrsetKey{name: dns.CanonicalName(rr.Header().Name), typeCode: rr.Header().Rrtype}
+		index, exists := rrsetIndexes[key]
+		if !exists {
+			index = len(rrsets)
+			rrsetIndexes[key] = index
+			rrsets = append(rrsets, RRset{Name: key.name, Type: key.typeCode})
+		}
+		rrsets[index].RRs = append(rrsets[index].RRs, rr)
+	}
+	if err := zoneParser.Err(); err != nil {
+		return nil, err
+	}
+	return rrsets, nil
+}
+
+// Sink receives the zones updates of the zone manager, e.g. to write the zones into zone files or to push them to a DNS
+// server. The updates of a zone are applied in order, outside of the zones lock, so the VMIs updates go on while a sink
+// applies an update. The next flush waits for the sinks though, so a sink is expected to return quickly and to do slow
+// work, e.g. network calls, in the background.
+type Sink interface {
+	// Name identifies the sink in the logs and the errors
+	Name() string
+	// Update applies the zone update, a failed update is followed by a resync of the zone on the next flush
+	Update(update ZoneUpdate) error
+}
@@ -0,0 +1,43 @@
+package sink_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/miekg/dns"
+
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
+)
+
+var _ = Describe("Zone update", func() {
+	const zoneContent = `$ORIGIN vm.domain.com. 
+$TTL 3600 
+@ IN SOA ns.vm.domain.com. email.vm.domain.com. (3 3600 3600 1209600 3600)
+@ IN NS ns.vm.domain.com.
+ns IN A 1.2.3.4
+nic1.VMI1.ns1 IN A 10.0.0.5
+nic1.vmi1.ns1 IN AAAA fd00::5
+nic1.vmi1.ns1 60 IN A 10.0.0.6
+`
+
+	It("should parse the snapshot into its RRsets", func() {
+		rrsets, err := sink.ZoneUpdate{Origin: "vm.domain.com.", Content: zoneContent}.RRsets()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rrsets).To(HaveLen(5))
+		Expect(rrsets[0].Name).To(Equal("vm.domain.com."))
+		Expect(rrsets[0].Type).To(Equal(dns.TypeSOA))
+		Expect(rrsets[1].Type).To(Equal(dns.TypeNS))
+		Expect(rrsets[2].Name).To(Equal("ns.vm.domain.com."))
+
+		Expect(rrsets[3].Name).To(Equal("nic1.vmi1.ns1.vm.domain.com."))
+		Expect(rrsets[3].Type).To(Equal(dns.TypeA))
+		Expect(rrsets[3].RRs).To(HaveLen(2))
+		Expect(rrsets[3].RRs[1].String()).To(Equal("nic1.vmi1.ns1.vm.domain.com.\t60\tIN\tA\t10.0.0.6"))
+		Expect(rrsets[4].Type).To(Equal(dns.TypeAAAA))
+	})
+
+	It("should fail to parse an invalid snapshot", func() {
+		_, err := sink.ZoneUpdate{Origin: "vm.domain.com.", Content: "nic1.vmi1.ns1 IN A 10.0.0\n"}.RRsets()
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,59 +1,516 @@
 package zonemgr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/record"
 	v1 "kubevirt.io/api/core/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-server"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-update"
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file"
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
 )
 
 const (
-	envVarDomain       = "DOMAIN"
-	envVarNameServerIP = "NAME_SERVER_IP"
-	zoneFileNamePrefix = "/zones/db."
-	domainDefault      = "vm"
+	envVarDomain                      = "DOMAIN"
+	envVarNameServerIP                = "NAME_SERVER_IP"
+	envVarIPv4ReverseZonePrefixLength = "IPV4_REVERSE_ZONE_PREFIX_LENGTH"
+	envVarIPv6ReverseZonePrefixLength = "IPV6_REVERSE_ZONE_PREFIX_LENGTH"
+	envVarNamingMode                  = "NAMING_MODE"
+	envVarInterfaceNameTemplate       = "INTERFACE_NAME_TEMPLATE"
+	envVarDefaultNameTemplate         = "DEFAULT_NAME_TEMPLATE"
+	envVarZoneName                    = "ZONE_NAME"
+	envVarDefaultInterfacePolicy      = "DEFAULT_INTERFACE_POLICY"
+	envVarTXTMetadataFields           = "TXT_METADATA_FIELDS"
+	envVarPodName                     = "POD_NAME"
+	envVarPodNamespace                = "POD_NAMESPACE"
+	envVarZoneFlushInterval           = "ZONE_FLUSH_INTERVAL"
+	envVarSOASerialScheme             = "SOA_SERIAL_SCHEME"
+	envVarNameServerName              = "NAME_SERVER_NAME"
+	envVarNameServers                 = "NAME_SERVERS"
+	envVarAdminEmail                  = "ADMIN_EMAIL"
+	envVarSOARefresh                  = "SOA_REFRESH"
+	envVarSOARetry                    = "SOA_RETRY"
+	envVarSOAExpire                   = "SOA_EXPIRE"
+	envVarSOANegativeTTL              = "SOA_NEGATIVE_TTL"
+	envVarRecordTTL                   = "RECORD_TTL"
+	envVarRecordTTLMin                = "RECORD_TTL_MIN"
+	envVarRecordTTLMax                = "RECORD_TTL_MAX"
+	envVarDNSServerAddress            = "DNS_SERVER_ADDRESS"
+	envVarSecondaryServers            = "SECONDARY_SERVERS"
+	envVarTSIGKeyName                 = "TSIG_KEY_NAME"
+	envVarTSIGSecret                  = "TSIG_SECRET"
+	envVarTSIGAlgorithm               = "TSIG_ALGORITHM"
+	envVarDNSUpdateServer             = "DNS_UPDATE_SERVER"
+	envVarDNSUpdateTSIGKeyName        = "DNS_UPDATE_TSIG_KEY_NAME"
+	envVarDNSUpdateTSIGSecret         = "DNS_UPDATE_TSIG_SECRET"
+	envVarDNSUpdateTSIGAlgorithm      = "DNS_UPDATE_TSIG_ALGORITHM"
+	envVarDNSUpdateReconcileInterval  = "DNS_UPDATE_RECONCILE_INTERVAL"
+	envVarNamespaceSelector           = "NAMESPACE_SELECTOR"
+	domainDefault                     = "vm"
+	zoneFlushIntervalDefault          = time.Second
+	dnsUpdateReconcileIntervalDefault = 10 * time.Minute
+
+	corruptZoneFileReason = "CorruptZoneFile"
 )
 
+// TTLAnnotation is the VMI, or namespace, annotation that sets the TTL of the VMI records
+const TTLAnnotation = zone_file_cache.TTLAnnotation
+
+var log = logf.Log.WithName("zonemgr")
+
+// zonesDir is the directory of the zone files, which CoreDNS loads the zones from
+var zonesDir = "/zones"
+
 type ZoneManager struct {
+	// flushLock serializes the flushes, so each sink applies the updates of a zone in order, it guards the sinks. It is
+	// held while the sinks apply the updates, and is taken before lock.
+	flushLock sync.Mutex
+	// lock guards the zones caches, which are updated by the reconciler and flushed by the flush loop
+	lock sync.Mutex
+
+	zoneFileCache *zone_file_cache.ZoneFileCache
+
+	domain                      string
+	nameServerIP                string
+	ipv4ReverseZonePrefixLength int
+	ipv6ReverseZonePrefixLength int
+	reverseZones                map[string]*reverseZone
+	serialScheme                zone_file_cache.SerialScheme
+	soa                         *zone_file_cache.SOA
+	newZoneFile                 func(string) zone_file.ZoneFileInterface
+
+	// flushInterval is the minimal interval between zone files writes, zero writes the zone files on each update
+	flushInterval time.Duration
+	// zoneFileSink writes the zones into their zone files, it is the first of the sinks
+	zoneFileSink *zone_file.Sink
+	sinks        []*sinkState
+
+	// dnsServer answers the queries from the zones caches, when the built-in DNS server is enabled
+	dnsServer *dns_server.Server
+	// isTransferEnabled is whether the DNS server transfers the zones to secondary servers, the zones caches then
+	// journal their changes
+	isTransferEnabled bool
+	// dnsUpdater pushes the zones to an external primary server, when the dynamic updates are enabled
+	dnsUpdater *dns_update.Updater
+
+	// namespaceSelector selects the namespaces whose VMIs are published, by their labels
+	namespaceSelector labels.Selector
+
+	// isSynced is set once all the VMIs were reconciled since the start, see MarkSynced
+	isSynced bool
+
+	recorder    record.EventRecorder
+	eventObject *corev1.ObjectReference
+}
+
+// NameConflictError is returned when a name of a VMI is already used by another VMI, or by the name servers glue
+// records, the VMI is not published until the name is released
+type NameConflictError struct {
+	VMI   k8stypes.NamespacedName
+	Owner k8stypes.NamespacedName
+}
+
+func (e *NameConflictError) Error() string {
+	if e.Owner == zone_file_cache.NameServersOwner {
+		return fmt.Sprintf("VMI %s is not published, its name is used by the glue records of the name servers", e.VMI)
+	}
+	return fmt.Sprintf("VMI %s is not published, its name is already used by VMI %s", e.VMI, e.Owner)
+}
+
+// AliasConflictError is returned when aliases of a VMI are already used by other VMIs, or by the name servers glue
+// records. The VMI is published without these aliases until they are released.
+type AliasConflictError struct {
+	VMI k8stypes.NamespacedName
+	// Owners maps the aliases names, relative to the zone domain, to the VMIs that own them
+	Owners map[string]k8stypes.NamespacedName
+}
+
+func (e *AliasConflictError) Error() string {
+	aliases := make([]string, 0, len(e.Owners))
+	for alias := range e.Owners {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	conflicts := make([]string, 0, len(aliases))
+	for _, alias := range aliases {
+		if owner := e.Owners[alias]; owner == zone_file_cache.NameServersOwner {
+			conflicts = append(conflicts, fmt.Sprintf("%s is used by the glue records of the name servers", alias))
+		} else {
+			conflicts = append(conflicts, fmt.Sprintf("%s is already used by VMI %s", alias, owner))
+		}
+	}
+	return fmt.Sprintf("VMI %s is published without its conflicting aliases: %s", e.VMI, strings.Join(conflicts, ", "))
+}
+
+// InvalidNameError is returned when the names of a VMI records, rendered from the name templates, are not valid DNS
+// names. The VMI is not published.
+type InvalidNameError struct {
+	VMI k8stypes.NamespacedName
+	Err error
+}
+
+func (e *InvalidNameError) Error() string {
+	return fmt.Sprintf("VMI %s is not published: %v", e.VMI, e.Err)
+}
+
+func (e *InvalidNameError) Unwrap() error {
+	return e.Err
+}
+
+// InvalidTTLError is returned when the TTL annotation of a VMI, or of its namespace, is not valid. The VMI is published
+// with the zone TTL.
+type InvalidTTLError struct {
+	VMI k8stypes.NamespacedName
+	Err error
+}
+
+func (e *InvalidTTLError) Error() string {
+	return fmt.Sprintf("VMI %s is published with the zone TTL: %v", e.VMI, e.Err)
+}
+
+func (e *InvalidTTLError) Unwrap() error {
+	return e.Err
+}
+
+type reverseZone struct {
 	zoneFileCache *zone_file_cache.ZoneFileCache
-	zoneFile      zone_file.ZoneFileInterface
+	// isLoaded is set for a zone that has a zone file from before the start, the zone is not flushed until the VMIs are
+	// synced, so the zone file keeps the PTR records of the VMIs that were not reconciled yet
+	isLoaded bool
 }
 
-func NewZoneManager() (*ZoneManager, error) {
-	return NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, zone_file.NewZoneFile)
+// sinkState is a sink along with the state of the zones it was sent, by zone origin. A zone is true when the sink
+// applied its last update, and false when the update failed, the zone is then resynced on the next flush. A zone the
+// sink was not sent yet is resynced on its next flush.
+type sinkState struct {
+	sink  sink.Sink
+	zones map[string]bool
 }
 
-func NewZoneManagerWithParams(newZoneFileCache func(string, string, *int) *zone_file_cache.ZoneFileCache,
-	newZoneFile func(string) zone_file.ZoneFileInterface) (*ZoneManager, error) {
-	zoneMgr := &ZoneManager{}
+// NewZoneManager creates the zone manager, the recorder is used to report zone files that were found corrupt and
+// rebuilt, it may be nil
+func NewZoneManager(recorder record.EventRecorder) (*ZoneManager, error) {
+	return NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, zone_file.NewZoneFile, recorder)
+}
+
+func NewZoneManagerWithParams(newZoneFileCache func(string, string, *int, zone_file_cache.SerialScheme, *zone_file_cache.SOA, *zone_file_cache.Naming,
+	*zone_file_cache.Metadata) *zone_file_cache.ZoneFileCache,
+	newZoneFile func(string) zone_file.ZoneFileInterface, recorder record.EventRecorder) (*ZoneManager, error) {
+	zoneMgr := &ZoneManager{recorder: recorder, eventObject: podReference()}
 	err := zoneMgr.prepare(newZoneFileCache, newZoneFile)
 	return zoneMgr, err
 }
 
-func (zoneMgr *ZoneManager) prepare(newZoneFileCache func(string, string, *int) *zone_file_cache.ZoneFileCache,
+// podReference returns the reference of the pod the zone manager runs in, which the events refer to, or nil when the
+// pod is not known
+func podReference() *corev1.ObjectReference {
+	podName, podNamespace := os.Getenv(envVarPodName), os.Getenv(envVarPodNamespace)
+	if podName == "" || podNamespace == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{Kind: "Pod", APIVersion: "v1", Name: podName, Namespace: podNamespace}
+}
+
+func (zoneMgr *ZoneManager) prepare(newZoneFileCache func(string, string, *int, zone_file_cache.SerialScheme, *zone_file_cache.SOA, *zone_file_cache.Naming,
+	*zone_file_cache.Metadata) *zone_file_cache.ZoneFileCache,
 	newZoneFile func(string) zone_file.ZoneFileInterface) error {
 	domain := domainDefault
 	nameServerIP := os.Getenv(envVarNameServerIP)
 	if customDomain := os.Getenv(envVarDomain); customDomain != "" {
 		domain = fmt.Sprintf("%s.%s", domain, customDomain)
 	}
-	zoneFileName := zoneFileNamePrefix + domain
-	zoneMgr.zoneFile = newZoneFile(zoneFileName)
+	if zoneName := os.Getenv(envVarZoneName); zoneName != "" {
+		if errs := validation.IsDNS1123Subdomain(strings.ToLower(zoneName)); len(errs) > 0 {
+			return fmt.Errorf("invalid %s %q: %s", envVarZoneName, zoneName, strings.Join(errs, ", "))
+		}
+		domain = zoneName
+	}
+	namingMode := zone_file_cache.NamingModeName
+	if customNamingMode := os.Getenv(envVarNamingMode); customNamingMode != "" {
+		namingMode = zone_file_cache.NamingMode(customNamingMode)
+	}
+	naming, err := zone_file_cache.NewNaming(namingMode, os.Getenv(envVarInterfaceNameTemplate), os.Getenv(envVarDefaultNameTemplate),
+		os.Getenv(envVarDefaultInterfacePolicy), domain)
+	if err != nil {
+		return err
+	}
+	metadata, err := zone_file_cache.NewMetadata(os.Getenv(envVarTXTMetadataFields))
+	if err != nil {
+		return err
+	}
+	zoneMgr.ipv4ReverseZonePrefixLength, err = readPrefixLength(envVarIPv4ReverseZonePrefixLength,
+		zone_file_cache.ValidateIPv4ReverseZonePrefixLength)
+	if err != nil {
+		return err
+	}
+	zoneMgr.ipv6ReverseZonePrefixLength, err = readPrefixLength(envVarIPv6ReverseZonePrefixLength,
+		zone_file_cache.ValidateIPv6ReverseZonePrefixLength)
+	if err != nil {
+		return err
+	}
+	if zoneMgr.flushInterval, err = readDuration(envVarZoneFlushInterval, zoneFlushIntervalDefault); err != nil {
+		return err
+	}
+	if zoneMgr.serialScheme, err = zone_file_cache.ParseSerialScheme(os.Getenv(envVarSOASerialScheme)); err != nil {
+		return err
+	}
+	if zoneMgr.namespaceSelector, err = labels.Parse(os.Getenv(envVarNamespaceSelector)); err != nil {
+		return fmt.Errorf("invalid %s %q: %w", envVarNamespaceSelector, os.Getenv(envVarNamespaceSelector), err)
+	}
+	if nameServerIP != "" && os.Getenv(envVarNameServers) != "" {
+		return fmt.Errorf("%s and %s can not be set together, the glue addresses are set by %s", envVarNameServerIP,
+			envVarNameServers, envVarNameServers)
+	}
+	if zoneMgr.soa, err = zone_file_cache.NewSOA(zone_file_cache.SOAParams{
+		NameServer:  os.Getenv(envVarNameServerName),
+		NameServers: os.Getenv(envVarNameServers),
+		AdminEmail:  os.Getenv(envVarAdminEmail),
+		Refresh:     os.Getenv(envVarSOARefresh),
+		Retry:       os.Getenv(envVarSOARetry),
+		Expire:      os.Getenv(envVarSOAExpire),
+		NegativeTTL: os.Getenv(envVarSOANegativeTTL),
+		TTL:         os.Getenv(envVarRecordTTL),
+		MinTTL:      os.Getenv(envVarRecordTTLMin),
+		MaxTTL:      os.Getenv(envVarRecordTTLMax),
+	}); err != nil {
+		return err
+	}
+	if err = zoneMgr.soa.ValidateGlue(domain); err != nil {
+		return fmt.Errorf("invalid %s: %w", envVarNameServers, err)
+	}
+	if err = zoneMgr.prepareDNSServer(); err != nil {
+		return err
+	}
+	zoneMgr.zoneFileSink = zone_file.NewSink()
+	zoneMgr.sinks = []*sinkState{newSinkState(zoneMgr.zoneFileSink)}
+	if err = zoneMgr.prepareDNSUpdater(); err != nil {
+		return err
+	}
+	zoneMgr.domain = domain
+	zoneMgr.nameServerIP = nameServerIP
+	zoneMgr.newZoneFile = newZoneFile
+	zoneMgr.reverseZones = map[string]*reverseZone{}
 
-	soaSerial, err := zoneMgr.zoneFile.ReadSoaSerial()
+	zoneFileName := zone_file.ZoneFileName(zonesDir, domain)
+	zoneFile := newZoneFile(zoneFileName)
+
+	soaSerial, isRecovered, err := zoneMgr.readSoaSerial(zoneFile, zoneFileName)
+	if err != nil {
+		return err
+	}
+	zoneMgr.zoneFileCache = newZoneFileCache(nameServerIP, domain, soaSerial, zoneMgr.serialScheme, zoneMgr.soa, naming, metadata)
+	if zoneMgr.isTransferEnabled {
+		zoneMgr.zoneFileCache.EnableJournal()
+	}
+	zoneMgr.zoneFileSink.AddZone(domain, zoneFile)
+	if isRecovered {
+		if err = zoneMgr.rewriteZone(zoneMgr.zoneFileCache, zoneFile); err != nil {
+			return err
+		}
+	}
+	return zoneMgr.loadReverseZones()
+}
+
+// loadReverseZones adds the reverse zones that have a zone file from before the start. Their zone files are kept until
+// the VMIs are synced, then they are rewritten with the PTR records of the VMIs, see MarkSynced. A zone that has no VMIs
+// anymore, e.g. one the prefix lengths no longer split the addresses on, is rewritten with no records.
+func (zoneMgr *ZoneManager) loadReverseZones() error {
+	origins, err := zone_file.ListZones(zonesDir)
+	if err != nil {
+		return fmt.Errorf("failed to list the zone files: %w", err)
+	}
+	for _, origin := range origins {
+		if !zone_file_cache.IsReverseZone(origin) || strings.EqualFold(origin, zoneMgr.domain) {
+			continue
+		}
+		if err = zoneMgr.addReverseZone(origin); err != nil {
+			return err
+		}
+		zoneMgr.reverseZones[origin].isLoaded = true
+	}
+	return nil
+}
+
+// MarkSynced tells the zone manager that all the VMIs were reconciled since the start. The reverse zones that have a
+// zone file from before the start are rewritten by the next flush, with the PTR records of the VMIs. Until then, their
+// zone files are kept, so the PTR records of the VMIs that were not reconciled yet are still served.
+func (zoneMgr *ZoneManager) MarkSynced() error {
+	zoneMgr.lock.Lock()
+	zoneMgr.isSynced = true
+	for _, zone := range zoneMgr.reverseZones {
+		if zone.isLoaded {
+			zone.zoneFileCache.MarkChanged()
+		}
+	}
+	zoneMgr.lock.Unlock()
+
+	if zoneMgr.flushInterval == 0 {
+		return zoneMgr.Flush()
+	}
+	return nil
+}
+
+// prepareDNSServer creates the built-in DNS server when its address is set, with the zone transfers when secondary
+// servers or a TSIG key are set
+func (zoneMgr *ZoneManager) prepareDNSServer() error {
+	dnsServerAddress := os.Getenv(envVarDNSServerAddress)
+	transferParams := dns_server.TransferParams{
+		Secondaries:   os.Getenv(envVarSecondaryServers),
+		TSIGKeyName:   os.Getenv(envVarTSIGKeyName),
+		TSIGSecret:    os.Getenv(envVarTSIGSecret),
+		TSIGAlgorithm: os.Getenv(envVarTSIGAlgorithm),
+	}
+	zoneMgr.isTransferEnabled = transferParams != dns_server.TransferParams{}
+	if dnsServerAddress == "" {
+		if zoneMgr.isTransferEnabled {
+			return fmt.Errorf("%s, %s, %s and %s require %s, the zones are transferred by the built-in DNS server",
+				envVarSecondaryServers, envVarTSIGKeyName, envVarTSIGSecret, envVarTSIGAlgorithm, envVarDNSServerAddress)
+		}
+		return nil
+	}
+	if err := dns_server.ValidateAddress(dnsServerAddress); err != nil {
+		return err
+	}
+	var transfer *dns_server.Transfer
+	if zoneMgr.isTransferEnabled {
+		var err error
+		if transfer, err = dns_server.NewTransfer(transferParams); err != nil {
+			return err
+		}
+	}
+	zoneMgr.dnsServer = dns_server.NewServer(dnsServerAddress, zoneMgr, transfer)
+	return nil
+}
+
+// prepareDNSUpdater creates the dynamic updates of an external primary server when its address is set
+func (zoneMgr *ZoneManager) prepareDNSUpdater() error {
+	dnsUpdateServer := os.Getenv(envVarDNSUpdateServer)
+	if dnsUpdateServer == "" {
+		return nil
+	}
+	reconcileInterval, err := readDuration(envVarDNSUpdateReconcileInterval, dnsUpdateReconcileIntervalDefault)
+	if err != nil {
+		return err
+	}
+	zoneMgr.dnsUpdater, err = dns_update.NewUpdater(dns_update.UpdaterParams{
+		Server:            dnsUpdateServer,
+		TSIGKeyName:       os.Getenv(envVarDNSUpdateTSIGKeyName),
+		TSIGSecret:        os.Getenv(envVarDNSUpdateTSIGSecret),
+		TSIGAlgorithm:     os.Getenv(envVarDNSUpdateTSIGAlgorithm),
+		ReconcileInterval: reconcileInterval,
+	})
 	if err != nil {
 		return err
 	}
-	zoneMgr.zoneFileCache = newZoneFileCache(nameServerIP, domain, soaSerial)
+	zoneMgr.sinks = append(zoneMgr.sinks, newSinkState(zoneMgr.dnsUpdater))
 	return nil
 }
 
-func (zoneMgr *ZoneManager) UpdateZone(namespacedName k8stypes.NamespacedName, interfaces []v1.VirtualMachineInstanceNetworkInterface) error {
+// readSoaSerial returns the SOA serial of the zone file. A corrupt zone file is reported, whether it was restored from
+// the last-known-good zone or not. The serial of a restored zone is returned, and the zone does not have to be
+// rebuilt. Otherwise, the serial of the last-known-good zone is returned when it could still be read, as no zone was
+// written with a higher serial, or else the recovery serial of the scheme. The rebuilt zone is written with the serial
+// that follows it, so the secondaries pick up the rebuilt zone.
+// The records of the zone are rebuilt as the VMIs are reconciled.
+func (zoneMgr *ZoneManager) readSoaSerial(zoneFile zone_file.ZoneFileInterface, zoneFileName string) (*int, bool, error) {
+	soaSerial, err := zoneFile.ReadSoaSerial()
+	if !errors.Is(err, zone_file.ErrCorruptZoneFile) {
+		return soaSerial, false, err
+	}
+
+	var corruptZoneFileErr *zone_file.CorruptZoneFileError
+	isCorruptZoneFileErr := errors.As(err, &corruptZoneFileErr)
+	if isCorruptZoneFileErr && corruptZoneFileErr.RestoredSoaSerial != nil {
+		restoredSerial := *corruptZoneFileErr.RestoredSoaSerial
+		log.Error(err, "restored corrupt zone file from the last-known-good zone", "zoneFile", zoneFileName, "soaSerial", restoredSerial)
+		if zoneMgr.recorder != nil && zoneMgr.eventObject != nil {
+			zoneMgr.recorder.Eventf(zoneMgr.eventObject, corev1.EventTypeWarning, corruptZoneFileReason,
+				"Zone file %s is corrupt, it is restored from the last-known-good zone with SOA serial %d: %v", zoneFileName,
+				restoredSerial, err)
+		}
+		return &restoredSerial, false, nil
+	}
+
+	var recoverySerial int
+	var recoverySerialSource string
+	if isCorruptZoneFileErr && corruptZoneFileErr.LastGoodSoaSerial != nil {
+		recoverySerial = *corruptZoneFileErr.LastGoodSoaSerial
+		recoverySerialSource = "the serial of the last-known-good zone"
+	} else {
+		recoverySerial = zoneMgr.serialScheme.RecoverySerial(time.Now())
+		recoverySerialSource = fmt.Sprintf("the recovery serial of the %s scheme", zoneMgr.serialScheme)
+	}
+	log.Error(err, "rebuilding corrupt zone file", "zoneFile", zoneFileName, "soaSerial", recoverySerial,
+		"soaSerialSource", recoverySerialSource)
+	if zoneMgr.recorder != nil && zoneMgr.eventObject != nil {
+		zoneMgr.recorder.Eventf(zoneMgr.eventObject, corev1.EventTypeWarning, corruptZoneFileReason,
+			"Zone file %s is corrupt, it is rebuilt with the SOA serial that follows %d, %s: %v", zoneFileName,
+			recoverySerial, recoverySerialSource, err)
+	}
+	return &recoverySerial, true, nil
+}
+
+// rewriteZone replaces a corrupt zone file by the zone of the cache
+func (zoneMgr *ZoneManager) rewriteZone(zoneFileCache *zone_file_cache.ZoneFileCache, zoneFile zone_file.ZoneFileInterface) error {
+	zoneFileCache.ForceUpdate()
+	return zoneFile.WriteFile(zoneFileCache.Content)
+}
+
+// readPrefixLength returns the reverse zones prefix length set by the environment variable, or zero when it is not set
+func readPrefixLength(envVar string, validate func(int) error) (int, error) {
+	prefixLength := os.Getenv(envVar)
+	if prefixLength == "" {
+		return 0, nil
+	}
+	prefixLengthInt, err := strconv.Atoi(strings.TrimPrefix(prefixLength, "/"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", envVar, err)
+	}
+	return prefixLengthInt, validate(prefixLengthInt)
+}
+
+// readDuration returns the duration set by the environment variable, or the default duration when it is not set
+func readDuration(envVar string, defaultDuration time.Duration) (time.Duration, error) {
+	duration := os.Getenv(envVar)
+	if duration == "" {
+		return defaultDuration, nil
+	}
+	parsedDuration, err := time.ParseDuration(duration)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %w", envVar, err)
+	}
+	if parsedDuration < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must not be negative", envVar, duration)
+	}
+	return parsedDuration, nil
+}
+
+// UpdateZone publishes the records of the VMI interfaces reported in its status, a nil VMI withdraws its records.
+// A NameConflictError is returned when the VMI is not published since one of its names is used by another VMI,
+// and an InvalidNameError is returned when the VMI is not published since its names are not valid. Otherwise, an
+// AliasConflictError is returned when the VMI is published without the aliases that other VMIs use, and an
+// InvalidTTLError is returned when the VMI is published with the zone TTL since its TTL annotation is not valid, both
+// are joined when both apply.
+// The records are applied to the zones caches straight away, and the zone files are written by the next flush, see
+// Start. When the flush interval is zero, the zone files are written on each update.
+func (zoneMgr *ZoneManager) UpdateZone(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance) error {
 	if namespacedName.Name == "" {
 		return errors.New("VM name in empty")
 	}
@@ -61,9 +518,298 @@ func (zoneMgr *ZoneManager) UpdateZone(namespacedName k8stypes.NamespacedName, i
 		return errors.New("VM namespace is empty")
 	}
 
-	if isUpdated := zoneMgr.zoneFileCache.UpdateVMIRecords(namespacedName, interfaces); isUpdated {
-		return zoneMgr.zoneFile.WriteFile(zoneMgr.zoneFileCache.Content)
+	if err := zoneMgr.updateVMIRecords(namespacedName, vmi); err != nil {
+		return err
+	}
+	if zoneMgr.flushInterval == 0 {
+		if err := zoneMgr.Flush(); err != nil {
+			return err
+		}
+	}
+	return zoneMgr.getVMIError(namespacedName)
+}
+
+func (zoneMgr *ZoneManager) updateVMIRecords(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance) error {
+	zoneMgr.lock.Lock()
+	defer zoneMgr.lock.Unlock()
+
+	changeSet := zoneMgr.zoneFileCache.UpdateVMIRecords(namespacedName, vmi)
+	logChangeSet(namespacedName, zoneMgr.domain, changeSet)
+	return zoneMgr.updateReverseZones(namespacedName, vmi)
+}
+
+// getVMIError returns the error of the last update of the VMI, see UpdateZone
+func (zoneMgr *ZoneManager) getVMIError(namespacedName k8stypes.NamespacedName) error {
+	zoneMgr.lock.Lock()
+	defer zoneMgr.lock.Unlock()
+
+	if owner, isConflicted := zoneMgr.zoneFileCache.GetNameConflict(namespacedName); isConflicted {
+		return &NameConflictError{VMI: namespacedName, Owner: owner}
 	}
+	if err := zoneMgr.zoneFileCache.GetInvalidNameError(namespacedName); err != nil {
+		return &InvalidNameError{VMI: namespacedName, Err: err}
+	}
+	var errs []error
+	if owners := zoneMgr.zoneFileCache.GetAliasConflicts(namespacedName); len(owners) > 0 {
+		errs = append(errs, &AliasConflictError{VMI: namespacedName, Owners: owners})
+	}
+	if err := zoneMgr.zoneFileCache.GetInvalidTTLError(namespacedName); err != nil {
+		errs = append(errs, &InvalidTTLError{VMI: namespacedName, Err: err})
+	}
+	return errors.Join(errs...)
+}
+
+// IsNamespaceSelected returns whether the VMIs of the namespace with the given labels are published, an empty
+// NAMESPACE_SELECTOR selects all the namespaces
+func (zoneMgr *ZoneManager) IsNamespaceSelected(namespaceLabels map[string]string) bool {
+	return zoneMgr.namespaceSelector.Matches(labels.Set(namespaceLabels))
+}
 
+func (zoneMgr *ZoneManager) isReverseZonesEnabled() bool {
+	return zoneMgr.ipv4ReverseZonePrefixLength != 0 || zoneMgr.ipv6ReverseZonePrefixLength != 0
+}
+
+// updateReverseZones sets the published VMI PTR records in the reverse zones that contain its IPs, and removes them from
+// the rest of the zones. Only the zones whose content was changed are written on the next flush.
+func (zoneMgr *ZoneManager) updateReverseZones(namespacedName k8stypes.NamespacedName, vmi *v1.VirtualMachineInstance) error {
+	if !zoneMgr.isReverseZonesEnabled() {
+		return nil
+	}
+
+	ptrRecordsMap := map[string][]zone_file_cache.Record{}
+	if interfaceNames, isPublished := zoneMgr.zoneFileCache.GetInterfacesNames(namespacedName); isPublished {
+		ptrRecordsMap = zone_file_cache.BuildPTRRecords(interfaceNames, zoneMgr.domain, vmi.Status.Interfaces,
+			zoneMgr.ipv4ReverseZonePrefixLength, zoneMgr.ipv6ReverseZonePrefixLength)
+	}
+	for origin := range ptrRecordsMap {
+		if _, exist := zoneMgr.reverseZones[origin]; !exist {
+			if err := zoneMgr.addReverseZone(origin); err != nil {
+				return err
+			}
+		}
+	}
+
+	for origin, zone := range zoneMgr.reverseZones {
+		changeSet := zone.zoneFileCache.UpdateVMIPTRRecords(namespacedName, vmi, ptrRecordsMap[origin])
+		logChangeSet(namespacedName, origin, changeSet)
+	}
+	return nil
+}
+
+// logChangeSet logs the records that the update of the VMI added to the zone and removed from it, at the debug level
+func logChangeSet(namespacedName k8stypes.NamespacedName, origin string, changeSet zone_file_cache.ChangeSet) {
+	if !changeSet.IsChanged() || !log.V(1).Enabled() {
+		return
+	}
+	log.V(1).Info("updated VMI records", "vmi", namespacedName, "zone", origin, "added", recordLines(changeSet.Added),
+		"removed", recordLines(changeSet.Removed), "unchanged", len(changeSet.Unchanged))
+}
+
+func recordLines(records []zone_file_cache.Record) []string {
+	lines := make([]string, 0, len(records))
+	for _, record := range records {
+		lines = append(lines, strings.TrimSuffix(record.String(), "\n"))
+	}
+	return lines
+}
+
+// Start flushes the zones every flush interval until the context is done, then flushes the zones a last time, so the
+// updates applied since the last flush are not lost on shutdown. When the built-in DNS server is enabled, it answers
+// the queries, and when the dynamic updates are enabled, the zones are pushed to the external primary server, until
+// the last flush. It implements the controller-runtime Runnable.
+func (zoneMgr *ZoneManager) Start(ctx context.Context) error {
+	var runners []func(context.Context) error
+	if zoneMgr.dnsServer != nil {
+		if err := zoneMgr.dnsServer.Listen(); err != nil {
+			return fmt.Errorf("failed to start the DNS server: %w", err)
+		}
+		zoneMgr.notifyZones()
+		runners = append(runners, zoneMgr.dnsServer.Serve)
+	}
+	if zoneMgr.dnsUpdater != nil {
+		runners = append(runners, zoneMgr.dnsUpdater.Run)
+	}
+
+	// A runner that fails stops the flush loop, so the failure is reported. The runners are stopped after the last
+	// flush, so its changes are served and pushed.
+	flushCtx, cancelFlush := context.WithCancel(ctx)
+	defer cancelFlush()
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	defer cancelRun()
+	runErrs := make(chan error, len(runners))
+	for _, run := range runners {
+		go func(run func(context.Context) error) {
+			runErrs <- run(runCtx)
+			cancelFlush()
+		}(run)
+	}
+	errs := []error{zoneMgr.runFlushLoop(flushCtx)}
+	cancelRun()
+	for range runners {
+		errs = append(errs, <-runErrs)
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// notifyZones tells the secondary servers the serials of all the zones, so they catch up with the zones served since
+// the start
+func (zoneMgr *ZoneManager) notifyZones() {
+	zoneMgr.lock.Lock()
+	defer zoneMgr.lock.Unlock()
+	zoneMgr.dnsServer.Notify(zoneMgr.zoneFileCache.SOA())
+	for _, zone := range zoneMgr.reverseZones {
+		zoneMgr.dnsServer.Notify(zone.zoneFileCache.SOA())
+	}
+}
+
+func (zoneMgr *ZoneManager) runFlushLoop(ctx context.Context) error {
+	if zoneMgr.flushInterval == 0 {
+		<-ctx.Done()
+		return nil
+	}
+
+	ticker := time.NewTicker(zoneMgr.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return zoneMgr.Flush()
+		case <-ticker.C:
+			if err := zoneMgr.Flush(); err != nil {
+				log.Error(err, "failed to flush the zones, retrying on the next flush")
+			}
+		}
+	}
+}
+
+// Flush sends the zones whose records were changed since the last flush to the sinks, the zone files first. The SOA
+// serial of a zone is bumped once per flush. The zones updates are taken under the zones lock, and are applied by the
+// sinks outside of it, so the sinks do not block the VMIs updates and the DNS server queries.
+func (zoneMgr *ZoneManager) Flush() error {
+	zoneMgr.flushLock.Lock()
+	defer zoneMgr.flushLock.Unlock()
+
+	var errs []error
+	for _, pending := range zoneMgr.takeUpdates() {
+		if err := pending.state.sink.Update(pending.update); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s failed to update zone %s: %w", pending.state.sink.Name(), pending.update.Origin, err))
+			pending.state.zones[pending.update.Origin] = false
+			continue
+		}
+		pending.state.zones[pending.update.Origin] = true
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// sinkUpdate is a zone update to send to a sink
+type sinkUpdate struct {
+	state  *sinkState
+	update sink.ZoneUpdate
+}
+
+// takeUpdates flushes the zones caches and returns the updates to send to the sinks, the forward zone first. The reverse
+// zones loaded from their zone files are not flushed until the VMIs are synced.
+func (zoneMgr *ZoneManager) takeUpdates() []sinkUpdate {
+	zoneMgr.lock.Lock()
+	defer zoneMgr.lock.Unlock()
+
+	updates := zoneMgr.flushZone(zoneMgr.zoneFileCache)
+	for _, zone := range zoneMgr.reverseZones {
+		if zone.isLoaded && !zoneMgr.isSynced {
+			continue
+		}
+		updates = append(updates, zoneMgr.flushZone(zone.zoneFileCache)...)
+	}
+	return updates
+}
+
+// flushZone returns the updates of the zone to send to the sinks when the zone content was regenerated, a sink that
+// failed to apply the last zone update is sent a resync of the zone. The secondary servers of the built-in DNS server
+// are notified of the new serial.
+func (zoneMgr *ZoneManager) flushZone(zoneFileCache *zone_file_cache.ZoneFileCache) []sinkUpdate {
+	isFlushed := zoneFileCache.Flush()
+	if isFlushed && zoneMgr.dnsServer != nil {
+		zoneMgr.dnsServer.Notify(zoneFileCache.SOA())
+	}
+
+	origin := zoneFileCache.Origin()
+	var changes []sink.RRsetChange
+	var changesErr error
+	isChangesRead := false
+	var updates []sinkUpdate
+	for _, state := range zoneMgr.sinks {
+		isSynced, isSent := state.zones[origin]
+		if !isFlushed && (!isSent || isSynced) {
+			continue
+		}
+		update := sink.ZoneUpdate{Origin: origin, SOA: zoneFileCache.SOA(), Resync: !isSynced, Content: zoneFileCache.Content}
+		if !update.Resync {
+			if !isChangesRead {
+				if changes, changesErr = zoneFileCache.Changes(); changesErr != nil {
+					log.Error(changesErr, "failed to read the zone changes, the sinks are resynced", "zone", origin)
+				}
+				isChangesRead = true
+			}
+			update.Changes, update.Resync = changes, changesErr != nil
+		}
+		updates = append(updates, sinkUpdate{state: state, update: update})
+	}
+	return updates
+}
+
+func newSinkState(zonesSink sink.Sink) *sinkState {
+	return &sinkState{sink: zonesSink, zones: map[string]bool{}}
+}
+
+// AddSink adds a sink that is sent the zones updates along with the zone files, e.g. to publish the records to another
+// DNS provider. The sink is sent a resync of each zone on the next flush of the zone.
+func (zoneMgr *ZoneManager) AddSink(zonesSink sink.Sink) {
+	zoneMgr.flushLock.Lock()
+	defer zoneMgr.flushLock.Unlock()
+	zoneMgr.sinks = append(zoneMgr.sinks, newSinkState(zonesSink))
+}
+
+// View calls the view function with the zone cache that contains the absolute name, under the zones lock. It returns
+// false when no zone contains the name. It implements the built-in DNS server zones.
+func (zoneMgr *ZoneManager) View(name string, view func(dns_server.Zone)) bool {
+	zoneMgr.lock.Lock()
+	defer zoneMgr.lock.Unlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	domain := strings.ToLower(zoneMgr.domain)
+	for suffix, hasSuffix := name, true; hasSuffix; _, suffix, hasSuffix = strings.Cut(suffix, ".") {
+		if suffix == domain {
+			view(zoneMgr.zoneFileCache)
+			return true
+		}
+		if zone, exists := zoneMgr.reverseZones[suffix]; exists {
+			view(zone.zoneFileCache)
+			return true
+		}
+	}
+	return false
+}
+
+func (zoneMgr *ZoneManager) addReverseZone(origin string) error {
+	zoneFileName := zone_file.ZoneFileName(zonesDir, origin)
+	zoneFile := zoneMgr.newZoneFile(zoneFileName)
+	soaSerial, isRecovered, err := zoneMgr.readSoaSerial(zoneFile, zoneFileName)
+	if err != nil {
+		return err
+	}
+	zoneFileCache := zone_file_cache.NewReverseZoneFileCache(zoneMgr.nameServerIP, zoneMgr.domain, origin, soaSerial, zoneMgr.serialScheme,
+		zoneMgr.soa)
+	if zoneMgr.isTransferEnabled {
+		zoneFileCache.EnableJournal()
+	}
+	if isRecovered {
+		if err = zoneMgr.rewriteZone(zoneFileCache, zoneFile); err != nil {
+			return err
+		}
+	}
+	zoneMgr.zoneFileSink.AddZone(zoneFileCache.Origin(), zoneFile)
+	zoneMgr.reverseZones[origin] = &reverseZone{
+		zoneFileCache: zoneFileCache,
+	}
 	return nil
 }
@@ -4,13 +4,26 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"context"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/miekg/dns"
 	k8stypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	v1 "kubevirt.io/api/core/v1"
 
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/dns-server"
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file"
 	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/internal/zone-file-cache"
+	"github.com/kubevirt/kubesecondarydns/pkg/zonemgr/sink"
 )
 
 const (
@@ -23,34 +36,896 @@ var _ = Describe("Zone Manager functionality", func() {
 	BeforeEach(func() {
 		os.Setenv("DOMAIN", customDomain)
 		os.Setenv("NAME_SERVER_IP", customNSIP)
+		os.Setenv("ZONE_FLUSH_INTERVAL", "0")
 	})
 
 	Context("Initialization", func() {
 		It("should fail updating a VMI with no name", func() {
-			zoneMgr, err := zonemgr.NewZoneManager()
+			zoneMgr, err := zonemgr.NewZoneManager(nil)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1"}, nil)).NotTo(Succeed())
 		})
 
 		It("should fail updating a VMI with no namespace", func() {
-			zoneMgr, err := zonemgr.NewZoneManager()
+			zoneMgr, err := zonemgr.NewZoneManager(nil)
 			Expect(err).ToNot(HaveOccurred())
 			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Name: "vm1"}, nil)).NotTo(Succeed())
 		})
 
 		It("should set custom data", func() {
-			_, err := zonemgr.NewZoneManagerWithParams(newZoneFileCacheStub, zone_file.NewZoneFile)
+			_, err := zonemgr.NewZoneManagerWithParams(newZoneFileCacheStub, zone_file.NewZoneFile, nil)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("should create zone file with correct name", func() {
-			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub)
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("should fail with invalid IPv4 reverse zone prefix length", func() {
+			os.Setenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH", "20")
+			defer os.Unsetenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with invalid naming mode", func() {
+			os.Setenv("NAMING_MODE", "fqdn")
+			defer os.Unsetenv("NAMING_MODE")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with invalid name template", func() {
+			os.Setenv("INTERFACE_NAME_TEMPLATE", "{{.Interface}}_{{.Unknown}}")
+			defer os.Unsetenv("INTERFACE_NAME_TEMPLATE")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with invalid zone name", func() {
+			os.Setenv("ZONE_NAME", "cluster..example.com")
+			defer os.Unsetenv("ZONE_NAME")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should create zone file named after the custom zone name", func() {
+			os.Setenv("ZONE_NAME", "cluster.example.com")
+			defer os.Unsetenv("ZONE_NAME")
+			var zoneFileName string
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, func(fileName string) zone_file.ZoneFileInterface {
+				zoneFileName = fileName
+				return &ZoneFileStub{}
+			}, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zoneFileName).To(Equal("/zones/db.cluster.example.com"))
+		})
+
+		It("should fail with invalid IPv6 reverse zone prefix length", func() {
+			os.Setenv("IPV6_REVERSE_ZONE_PREFIX_LENGTH", "/63")
+			defer os.Unsetenv("IPV6_REVERSE_ZONE_PREFIX_LENGTH")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail when the default interface policy is invalid", func() {
+			os.Setenv("DEFAULT_INTERFACE_POLICY", "network:")
+			defer os.Unsetenv("DEFAULT_INTERFACE_POLICY")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail when a TXT metadata field is invalid", func() {
+			os.Setenv("TXT_METADATA_FIELDS", "uid,annotations")
+			defer os.Unsetenv("TXT_METADATA_FIELDS")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with invalid SOA timers", func() {
+			os.Setenv("SOA_REFRESH", "7200")
+			defer os.Unsetenv("SOA_REFRESH")
+			os.Setenv("SOA_EXPIRE", "3600")
+			defer os.Unsetenv("SOA_EXPIRE")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with minimum record TTL greater than the maximum record TTL", func() {
+			os.Setenv("RECORD_TTL_MIN", "600")
+			defer os.Unsetenv("RECORD_TTL_MIN")
+			os.Setenv("RECORD_TTL_MAX", "60")
+			defer os.Unsetenv("RECORD_TTL_MAX")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with name servers list along with name server IP", func() {
+			os.Setenv("NAME_SERVERS", "ns1=10.0.0.1,ns2=10.0.0.2")
+			defer os.Unsetenv("NAME_SERVERS")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with a name server in the zone that has no glue address", func() {
+			os.Unsetenv("NAME_SERVER_IP")
+			os.Setenv("NAME_SERVERS", "ns1=10.0.0.1,ns2")
+			defer os.Unsetenv("NAME_SERVERS")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(MatchError(ContainSubstring("has no glue address")))
+		})
+
+		It("should fail with invalid SOA serial scheme", func() {
+			os.Setenv("SOA_SERIAL_SCHEME", "epoch")
+			defer os.Unsetenv("SOA_SERIAL_SCHEME")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Namespace selection", func() {
+		const publishLabel = "secondarydns.kubevirt.io/publish"
+
+		DescribeTable("select the namespaces", func(selector string, namespaceLabels map[string]string, isSelected bool) {
+			os.Setenv("NAMESPACE_SELECTOR", selector)
+			defer os.Unsetenv("NAMESPACE_SELECTOR")
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zoneMgr.IsNamespaceSelected(namespaceLabels)).To(Equal(isSelected))
+		},
+			Entry("when the selector is empty", "", nil, true),
+			Entry("when the namespace has the label", publishLabel+"=true", map[string]string{publishLabel: "true"}, true),
+			Entry("when the namespace label has another value", publishLabel+"=true", map[string]string{publishLabel: "false"}, false),
+			Entry("when the namespace has no labels", publishLabel+"=true", nil, false),
+			Entry("when the selector requires the label only", publishLabel, map[string]string{publishLabel: ""}, true),
+			Entry("when the selector excludes the label", "!"+publishLabel, map[string]string{publishLabel: "true"}, false),
+		)
+
+		It("should fail with an invalid namespace selector", func() {
+			os.Setenv("NAMESPACE_SELECTOR", publishLabel+" in (true")
+			defer os.Unsetenv("NAMESPACE_SELECTOR")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(MatchError(ContainSubstring("invalid NAMESPACE_SELECTOR")))
+		})
+	})
+
+	Context("Naming", func() {
+		BeforeEach(func() {
+			os.Setenv("NAMING_MODE", "hostname")
+		})
+		AfterEach(func() {
+			os.Unsetenv("NAMING_MODE")
+		})
+
+		It("should return a name conflict error when the VMI hostname is used by another VMI", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi1 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			vmi2 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm2"}
+			newHostnameVMI := func(IP string) *v1.VirtualMachineInstance {
+				vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IP}, Name: "nic1"}})
+				vmi.Spec.Hostname = "host1"
+				return vmi
+			}
+			Expect(zoneMgr.UpdateZone(vmi1, newHostnameVMI("10.0.0.5"))).To(Succeed())
+
+			err = zoneMgr.UpdateZone(vmi2, newHostnameVMI("10.0.0.6"))
+			var nameConflictErr *zonemgr.NameConflictError
+			Expect(errors.As(err, &nameConflictErr)).To(BeTrue())
+			Expect(nameConflictErr.VMI).To(Equal(vmi2))
+			Expect(nameConflictErr.Owner).To(Equal(vmi1))
+
+			Expect(zoneMgr.UpdateZone(vmi1, nil)).To(Succeed())
+			Expect(zoneMgr.UpdateZone(vmi2, newHostnameVMI("10.0.0.6"))).To(Succeed())
+		})
+
+		It("should return a name conflict error when the VMI hostname is used by the name server glue records", func() {
+			os.Setenv("DEFAULT_NAME_TEMPLATE", "{{.Hostname}}")
+			defer os.Unsetenv("DEFAULT_NAME_TEMPLATE")
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			vmiObject := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}})
+			vmiObject.Spec.Hostname = "ns"
+			err = zoneMgr.UpdateZone(vmi, vmiObject)
+			var nameConflictErr *zonemgr.NameConflictError
+			Expect(errors.As(err, &nameConflictErr)).To(BeTrue())
+			Expect(nameConflictErr).To(MatchError(ContainSubstring("glue records")))
+		})
+	})
+
+	Context("Name templates", func() {
+		BeforeEach(func() {
+			os.Setenv("INTERFACE_NAME_TEMPLATE", "{{.Name}}-{{.Interface}}.{{.Label \"team\"}}")
+		})
+		AfterEach(func() {
+			os.Unsetenv("INTERFACE_NAME_TEMPLATE")
+		})
+
+		It("should return an invalid name error when the VMI names can not be rendered", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			err = zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))
+			var invalidNameErr *zonemgr.InvalidNameError
+			Expect(errors.As(err, &invalidNameErr)).To(BeTrue())
+			Expect(invalidNameErr.VMI).To(Equal(vmi))
+		})
+	})
+
+	Context("Aliases", func() {
+		It("should publish the VMI without the alias used by another VMI and return an alias conflict error", func() {
+			zoneFile := &ZoneFileStub{}
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return zoneFile }, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi1 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			vmi2 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm2"}
+			newAliasesVMI := func(IP string, aliases string) *v1.VirtualMachineInstance {
+				vmi := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IP}, Name: "nic1"}})
+				vmi.Annotations = map[string]string{zone_file_cache.AliasesAnnotation: aliases}
+				return vmi
+			}
+			Expect(zoneMgr.UpdateZone(vmi1, newAliasesVMI("10.0.0.5", "db"))).To(Succeed())
+
+			err = zoneMgr.UpdateZone(vmi2, newAliasesVMI("10.0.0.6", "db"))
+			var aliasConflictErr *zonemgr.AliasConflictError
+			Expect(errors.As(err, &aliasConflictErr)).To(BeTrue())
+			Expect(aliasConflictErr.VMI).To(Equal(vmi2))
+			Expect(aliasConflictErr.Owners).To(Equal(map[string]k8stypes.NamespacedName{"db.ns1": vmi1}))
+			Expect(aliasConflictErr).To(MatchError(ContainSubstring("db.ns1 is already used by VMI ns1/vm1")))
+			Expect(zoneFile.content).To(ContainSubstring("vm2.ns1 IN A 10.0.0.6\n"))
+			Expect(zoneFile.content).To(ContainSubstring("db.ns1 IN CNAME vm1.ns1\n"))
+		})
+	})
+
+	Context("TTL", func() {
+		It("should publish the VMI with the zone TTL and return an invalid TTL error when its TTL annotation is invalid", func() {
+			zoneFile := &ZoneFileStub{}
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return zoneFile }, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			vmiObject := newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}})
+			vmiObject.Annotations = map[string]string{zonemgr.TTLAnnotation: "1m"}
+			err = zoneMgr.UpdateZone(vmi, vmiObject)
+			var invalidTTLErr *zonemgr.InvalidTTLError
+			Expect(errors.As(err, &invalidTTLErr)).To(BeTrue())
+			Expect(invalidTTLErr.VMI).To(Equal(vmi))
+			Expect(zoneFile.content).To(HaveSuffix("nic1.vm1.ns1 IN A 10.0.0.5\n"))
+		})
+	})
+
+	Context("Flush interval", func() {
+		var zoneFile *ZoneFileStub
+
+		newFlushedZoneFile := func(string) zone_file.ZoneFileInterface {
+			return zoneFile
+		}
+		newFlushedVMI := func(IP string) *v1.VirtualMachineInstance {
+			return newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IP}, Name: "nic1"}})
+		}
+
+		BeforeEach(func() {
+			zoneFile = &ZoneFileStub{}
+			os.Setenv("ZONE_FLUSH_INTERVAL", "1h")
+		})
+
+		It("should write the zone once per flush", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newFlushedZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}, newFlushedVMI("10.0.0.1"))).To(Succeed())
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm2"}, newFlushedVMI("10.0.0.2"))).To(Succeed())
+			Expect(zoneFile.writes).To(Equal(0))
+
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(zoneFile.writes).To(Equal(1))
+			Expect(zoneFile.content).To(ContainSubstring("(1 "))
+			Expect(zoneFile.content).To(ContainSubstring("10.0.0.1"))
+			Expect(zoneFile.content).To(ContainSubstring("10.0.0.2"))
+
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(zoneFile.writes).To(Equal(1))
+		})
+
+		It("should write the zone again on the next flush when the write failed", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newFlushedZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}, newFlushedVMI("10.0.0.1"))).To(Succeed())
+
+			zoneFile.writeErr = errors.New("no space left on device")
+			Expect(zoneMgr.Flush()).ToNot(Succeed())
+			zoneFile.writeErr = nil
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(zoneFile.content).To(ContainSubstring("10.0.0.1"))
+		})
+
+		It("should flush the zone on shutdown", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newFlushedZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}, newFlushedVMI("10.0.0.1"))).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			Expect(zoneMgr.Start(ctx)).To(Succeed())
+			Expect(zoneFile.writes).To(Equal(1))
+		})
+
+		It("should fail with invalid flush interval", func() {
+			os.Setenv("ZONE_FLUSH_INTERVAL", "-1s")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Corrupt zone file", func() {
+		var zoneFile *ZoneFileStub
+
+		newCorruptZoneFile := func(string) zone_file.ZoneFileInterface {
+			return zoneFile
+		}
+
+		BeforeEach(func() {
+			zoneFile = &ZoneFileStub{soaSerialErr: fmt.Errorf("%w /zones/db.vm: truncated", zone_file.ErrCorruptZoneFile)}
+			os.Setenv("POD_NAME", "secondary-dns-1")
+			os.Setenv("POD_NAMESPACE", "secondary")
+		})
+		AfterEach(func() {
+			os.Unsetenv("POD_NAME")
+			os.Unsetenv("POD_NAMESPACE")
+		})
+
+		It("should rebuild the zone with a serial higher than the corrupt zone serials, and emit an event", func() {
+			recorder := record.NewFakeRecorder(1)
+			startTime := time.Now().Unix()
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newCorruptZoneFile, recorder)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneFile.writes).To(Equal(1))
+			soaSerial := regexp.MustCompile(`SOA .*\(([0-9]+) `).FindStringSubmatch(zoneFile.content)
+			Expect(soaSerial).To(HaveLen(2))
+			Expect(strconv.ParseInt(soaSerial[1], 10, 64)).To(BeNumerically(">", startTime))
+			Expect(recorder.Events).To(Receive(HavePrefix("Warning CorruptZoneFile")))
+		})
+
+		It("should rebuild the zone with a date based serial higher than the serials of the day", func() {
+			os.Setenv("SOA_SERIAL_SCHEME", "date")
+			defer os.Unsetenv("SOA_SERIAL_SCHEME")
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newCorruptZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneFile.writes).To(Equal(1))
+			rebuiltSerial := time.Now().UTC().AddDate(0, 0, 1).Format("20060102") + "00"
+			Expect(zoneFile.content).To(ContainSubstring("(" + rebuiltSerial + " "))
+		})
+
+		It("should rebuild the zone with the serial that follows the last-known-good zone serial", func() {
+			lastGoodSoaSerial := 41
+			zoneFile.soaSerialErr = &zone_file.CorruptZoneFileError{Err: zoneFile.soaSerialErr, LastGoodSoaSerial: &lastGoodSoaSerial}
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newCorruptZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneFile.writes).To(Equal(1))
+			Expect(zoneFile.content).To(ContainSubstring("(42 "))
+		})
+
+		It("should keep the zone restored from the last-known-good zone, and emit an event", func() {
+			restoredSoaSerial := 41
+			zoneFile.soaSerialErr = &zone_file.CorruptZoneFileError{Err: zoneFile.soaSerialErr, RestoredSoaSerial: &restoredSoaSerial}
+			recorder := record.NewFakeRecorder(1)
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newCorruptZoneFile, recorder)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneFile.writes).To(Equal(0))
+			Expect(recorder.Events).To(Receive(ContainSubstring("is corrupt, it is restored from the last-known-good zone with SOA serial 41")))
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"},
+				newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFile.content).To(ContainSubstring("(42 "))
+		})
+
+		It("should fail when the zone file can not be read", func() {
+			zoneFile.soaSerialErr = errors.New("permission denied")
+			_, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newCorruptZoneFile, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Name servers", func() {
+		BeforeEach(func() {
+			os.Unsetenv("NAME_SERVER_IP")
+			os.Setenv("NAME_SERVERS", "ns1=10.0.0.1;2001:db8::1,ns2=10.0.0.2,ns.example.com.")
+		})
+		AfterEach(func() {
+			os.Unsetenv("NAME_SERVERS")
+		})
+
+		It("should publish the NS records and the glue records of the name servers", func() {
+			zoneFile := &ZoneFileStub{}
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return zoneFile }, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(zoneMgr.UpdateZone(k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"},
+				newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFile.content).To(ContainSubstring("@ IN SOA ns1.vm." + customDomain + ". "))
+			Expect(zoneFile.content).To(ContainSubstring(
+				"@ IN NS ns1.vm." + customDomain + ".\n" +
+					"@ IN NS ns2.vm." + customDomain + ".\n" +
+					"@ IN NS ns.example.com.\n" +
+					"ns1 IN A 10.0.0.1\n" +
+					"ns1 IN AAAA 2001:db8::1\n" +
+					"ns2 IN A 10.0.0.2\n"))
+		})
+	})
+
+	Context("Built-in DNS server", func() {
+		var dnsServerAddress string
+
+		BeforeEach(func() {
+			os.Setenv("ZONE_FLUSH_INTERVAL", "1h")
+			os.Setenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH", "24")
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			dnsServerAddress = listener.Addr().String()
+			Expect(listener.Close()).To(Succeed())
+			os.Setenv("DNS_SERVER_ADDRESS", dnsServerAddress)
+		})
+		AfterEach(func() {
+			os.Unsetenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH")
+			os.Unsetenv("DNS_SERVER_ADDRESS")
+		})
+
+		query := func(name string, qtype uint16) *dns.Msg {
+			reply, _, err := new(dns.Client).Exchange(new(dns.Msg).SetQuestion(name, qtype), dnsServerAddress)
+			Expect(err).ToNot(HaveOccurred())
+			return reply
+		}
+
+		It("should answer from the zones as soon as they are updated", func() {
+			zoneFile := &ZoneFileStub{}
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return zoneFile }, nil)
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			startErr := make(chan error, 1)
+			go func() {
+				startErr <- zoneMgr.Start(ctx)
+			}()
+
+			const fqdn = "nic1.vm1.ns1.vm." + customDomain + "."
+			Eventually(func() int {
+				reply, _, err := new(dns.Client).Exchange(new(dns.Msg).SetQuestion(fqdn, dns.TypeA), dnsServerAddress)
+				if err != nil {
+					return -1
+				}
+				return reply.Rcode
+			}).Should(Equal(dns.RcodeNameError))
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFile.writes).To(Equal(0))
+			reply := query(fqdn, dns.TypeA)
+			Expect(reply.Rcode).To(Equal(dns.RcodeSuccess))
+			Expect(reply.Answer).To(HaveLen(1))
+			Expect(reply.Answer[0].(*dns.A).A.String()).To(Equal("10.0.0.5"))
+
+			reply = query("5.0.0.10.in-addr.arpa.", dns.TypePTR)
+			Expect(reply.Answer).To(HaveLen(1))
+			Expect(reply.Answer[0].(*dns.PTR).Ptr).To(Equal(fqdn))
+
+			Expect(query("vm."+customDomain+".", dns.TypeSOA).Answer[0].(*dns.SOA).Serial).To(Equal(uint32(0)))
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(query("vm."+customDomain+".", dns.TypeSOA).Answer[0].(*dns.SOA).Serial).To(Equal(uint32(1)))
+
+			Expect(zoneMgr.UpdateZone(vmi, nil)).To(Succeed())
+			Expect(query(fqdn, dns.TypeA).Rcode).To(Equal(dns.RcodeNameError))
+			Expect(query("www.example.com.", dns.TypeA).Rcode).To(Equal(dns.RcodeRefused))
+
+			cancel()
+			Eventually(startErr).Should(Receive(BeNil()))
+			Expect(zoneFile.content).ToNot(ContainSubstring("10.0.0.5"))
+		})
+
+		It("should fail to start when the address is in use", func() {
+			listener, err := net.Listen("tcp", dnsServerAddress)
+			Expect(err).ToNot(HaveOccurred())
+			defer listener.Close()
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newZoneFileStub, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(zoneMgr.Start(context.Background())).ToNot(Succeed())
+		})
+
+		It("should fail with invalid address", func() {
+			os.Setenv("DNS_SERVER_ADDRESS", "127.0.0.1")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		Context("with secondary servers", func() {
+			var notifications chan *dns.Msg
+			var secondary *dns.Server
+
+			BeforeEach(func() {
+				notifications = make(chan *dns.Msg, 10)
+				packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+				Expect(err).ToNot(HaveOccurred())
+				secondary = &dns.Server{PacketConn: packetConn, Handler: dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+					notifications <- request
+					Expect(writer.WriteMsg(new(dns.Msg).SetReply(request))).To(Succeed())
+				})}
+				started := make(chan struct{})
+				secondary.NotifyStartedFunc = func() { close(started) }
+				go func() {
+					defer GinkgoRecover()
+					Expect(secondary.ActivateAndServe()).To(Succeed())
+				}()
+				Eventually(started).Should(BeClosed())
+				os.Setenv("SECONDARY_SERVERS", packetConn.LocalAddr().String())
+			})
+			AfterEach(func() {
+				os.Unsetenv("SECONDARY_SERVERS")
+				Expect(secondary.Shutdown()).To(Succeed())
+			})
+
+			receiveNotifiedSerial := func() uint32 {
+				var notification *dns.Msg
+				Eventually(notifications).Should(Receive(&notification))
+				return notification.Answer[0].(*dns.SOA).Serial
+			}
+
+			It("should notify the secondary servers and transfer them the zone changes", func() {
+				zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+					func(string) zone_file.ZoneFileInterface { return &ZoneFileStub{} }, nil)
+				Expect(err).ToNot(HaveOccurred())
+				ctx, cancel := context.WithCancel(context.Background())
+				startErr := make(chan error, 1)
+				go func() {
+					startErr <- zoneMgr.Start(ctx)
+				}()
+				Expect(receiveNotifiedSerial()).To(Equal(uint32(0)))
+
+				vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+				Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+				Expect(zoneMgr.Flush()).To(Succeed())
+				Expect([]uint32{receiveNotifiedSerial(), receiveNotifiedSerial()}).To(ConsistOf(uint32(1), uint32(1)))
+
+				Expect(zoneMgr.UpdateZone(vmi, nil)).To(Succeed())
+				Expect(zoneMgr.Flush()).To(Succeed())
+				request := new(dns.Msg).SetIxfr("vm."+customDomain+".", 1, "ns.vm."+customDomain+".", "email.vm."+customDomain+".")
+				envelopes, err := new(dns.Transfer).In(request, dnsServerAddress)
+				Expect(err).ToNot(HaveOccurred())
+				var rrs []dns.RR
+				for envelope := range envelopes {
+					Expect(envelope.Error).ToNot(HaveOccurred())
+					rrs = append(rrs, envelope.RR...)
+				}
+				// The records of the VMI are removed from serial 1 to serial 2
+				Expect(rrs).To(HaveLen(6))
+				Expect(rrs[0].(*dns.SOA).Serial).To(Equal(uint32(2)))
+				Expect(rrs[1].(*dns.SOA).Serial).To(Equal(uint32(1)))
+				Expect(rrs[2].(*dns.A).A.String()).To(Equal("10.0.0.5"))
+				Expect(rrs[3].(*dns.A).A.String()).To(Equal("10.0.0.5"))
+				Expect(rrs[4].(*dns.SOA).Serial).To(Equal(uint32(2)))
+				Expect(rrs[5].(*dns.SOA).Serial).To(Equal(uint32(2)))
+
+				cancel()
+				Eventually(startErr).Should(Receive(BeNil()))
+			})
+
+			It("should fail with an invalid secondary server", func() {
+				os.Setenv("SECONDARY_SERVERS", "ns2.example.com")
+				_, err := zonemgr.NewZoneManager(nil)
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("should fail when the built-in DNS server is disabled", func() {
+				os.Unsetenv("DNS_SERVER_ADDRESS")
+				_, err := zonemgr.NewZoneManager(nil)
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("Dynamic updates", func() {
+		const tsigKeyName = "update.key."
+		const tsigSecret = "c2VjcmV0LXRzaWcta2V5LWZvci10ZXN0cw=="
+
+		var updates chan *dns.Msg
+		var primary *dns.Server
+
+		BeforeEach(func() {
+			updates = make(chan *dns.Msg, 10)
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			started := make(chan struct{})
+			// The primary server has an empty zone, and accepts the updates signed by the TSIG key
+			primary = &dns.Server{Listener: listener, TsigSecret: map[string]string{tsigKeyName: tsigSecret},
+				NotifyStartedFunc: func() { close(started) },
+				MsgAcceptFunc:     func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+				Handler: dns.HandlerFunc(func(writer dns.ResponseWriter, request *dns.Msg) {
+					defer GinkgoRecover()
+					Expect(writer.TsigStatus()).ToNot(HaveOccurred())
+					if request.Opcode == dns.OpcodeUpdate {
+						updates <- request
+						reply := new(dns.Msg).SetReply(request)
+						reply.SetTsig(tsigKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+						Expect(writer.WriteMsg(reply)).To(Succeed())
+						return
+					}
+					soa, err := dns.NewRR(request.Question[0].Name + " 3600 IN SOA ns.corp.com. hostmaster.corp.com. 1 3600 3600 1209600 300")
+					Expect(err).ToNot(HaveOccurred())
+					envelopes := make(chan *dns.Envelope, 1)
+					envelopes <- &dns.Envelope{RR: []dns.RR{soa, soa}}
+					close(envelopes)
+					Expect(new(dns.Transfer).Out(writer, request, envelopes)).To(Succeed())
+				})}
+			go func() {
+				defer GinkgoRecover()
+				Expect(primary.ActivateAndServe()).To(Succeed())
+			}()
+			Eventually(started).Should(BeClosed())
+			os.Setenv("DNS_UPDATE_SERVER", listener.Addr().String())
+			os.Setenv("DNS_UPDATE_TSIG_KEY_NAME", tsigKeyName)
+			os.Setenv("DNS_UPDATE_TSIG_SECRET", tsigSecret)
+		})
+		AfterEach(func() {
+			os.Unsetenv("DNS_UPDATE_SERVER")
+			os.Unsetenv("DNS_UPDATE_TSIG_KEY_NAME")
+			os.Unsetenv("DNS_UPDATE_TSIG_SECRET")
+			Expect(primary.Shutdown()).To(Succeed())
+		})
+
+		It("should push the flushed zone records to the primary server", func() {
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return &ZoneFileStub{} }, nil)
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			startErr := make(chan error, 1)
+			go func() {
+				startErr <- zoneMgr.Start(ctx)
+			}()
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			var update *dns.Msg
+			Eventually(updates).Should(Receive(&update))
+			Expect(update.Question[0].Name).To(Equal("vm." + customDomain + "."))
+			var addresses []string
+			for _, rr := range update.Ns {
+				Expect(rr.Header().Class).To(Equal(uint16(dns.ClassINET)))
+				addresses = append(addresses, rr.(*dns.A).A.String())
+			}
+			Expect(addresses).To(ConsistOf("10.0.0.5", "10.0.0.5"))
+
+			cancel()
+			Eventually(startErr).Should(Receive(BeNil()))
+		})
+
+		It("should fail without a TSIG key", func() {
+			os.Unsetenv("DNS_UPDATE_TSIG_SECRET")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should fail with invalid reconcile interval", func() {
+			os.Setenv("DNS_UPDATE_RECONCILE_INTERVAL", "0s")
+			defer os.Unsetenv("DNS_UPDATE_RECONCILE_INTERVAL")
+			_, err := zonemgr.NewZoneManager(nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Sinks", func() {
+		var zoneFile *ZoneFileStub
+		var zonesSink *SinkStub
+		var zoneMgr *zonemgr.ZoneManager
+
+		vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+		updateVMI := func(IP string) error {
+			return zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{IP}, Name: "nic1"}}))
+		}
+
+		BeforeEach(func() {
+			zoneFile = &ZoneFileStub{}
+			zonesSink = &SinkStub{}
+			var err error
+			zoneMgr, err = zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache,
+				func(string) zone_file.ZoneFileInterface { return zoneFile }, nil)
+			Expect(err).ToNot(HaveOccurred())
+			zoneMgr.AddSink(zonesSink)
+		})
+
+		It("should send a resync of the zone and then its changes", func() {
+			Expect(updateVMI("10.0.0.1")).To(Succeed())
+			Expect(zonesSink.updates).To(HaveLen(1))
+			Expect(zonesSink.updates[0].Origin).To(Equal("vm." + customDomain + "."))
+			Expect(zonesSink.updates[0].Resync).To(BeTrue())
+			Expect(zonesSink.updates[0].Changes).To(BeNil())
+			Expect(zonesSink.updates[0].Content).To(Equal(zoneFile.content))
+
+			Expect(updateVMI("10.0.0.2")).To(Succeed())
+			Expect(zonesSink.updates).To(HaveLen(2))
+			update := zonesSink.updates[1]
+			Expect(update.Resync).To(BeFalse())
+			Expect(update.SOA.Serial).To(Equal(uint32(2)))
+			Expect(update.Changes).To(HaveLen(2))
+			for _, change := range update.Changes {
+				Expect(change.Operation).To(Equal(sink.Replace))
+				Expect(change.RRset.RRs).To(HaveLen(1))
+				Expect(change.RRset.RRs[0].(*dns.A).A.String()).To(Equal("10.0.0.2"))
+			}
+			Expect(zoneFile.writes).To(Equal(2))
+		})
+
+		It("should resync the zone on the next flush after a failed update", func() {
+			zonesSink.updateErr = errors.New("provider unavailable")
+			Expect(updateVMI("10.0.0.1")).To(MatchError(ContainSubstring("provider unavailable")))
+			Expect(zoneFile.writes).To(Equal(1))
+
+			zonesSink.updateErr = nil
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(zonesSink.updates).To(HaveLen(1))
+			Expect(zonesSink.updates[0].Resync).To(BeTrue())
+			Expect(zonesSink.updates[0].Content).To(ContainSubstring("10.0.0.1"))
+			Expect(zoneFile.writes).To(Equal(1))
+
+			Expect(zoneMgr.Flush()).To(Succeed())
+			Expect(zonesSink.updates).To(HaveLen(1))
+		})
+
+		It("should not hold the zones lock while the sinks apply the updates", func() {
+			Expect(updateVMI("10.0.0.1")).To(Succeed())
+			zonesSink.blocked = make(chan struct{})
+			zonesSink.released = make(chan struct{})
+			updateErr := make(chan error)
+			go func() {
+				updateErr <- updateVMI("10.0.0.2")
+			}()
+			Eventually(zonesSink.blocked).Should(Receive())
+
+			isViewed := make(chan bool)
+			go func() {
+				isViewed <- zoneMgr.View("nic1.vm1.ns1.vm."+customDomain+".", func(dns_server.Zone) {})
+			}()
+			Eventually(isViewed).Should(Receive(BeTrue()))
+
+			close(zonesSink.released)
+			Eventually(updateErr).Should(Receive(BeNil()))
+			Expect(zonesSink.updates).To(HaveLen(2))
+		})
+
+		It("should send the zones updates to several sinks", func() {
+			otherSink := &SinkStub{}
+			zoneMgr.AddSink(otherSink)
+			Expect(updateVMI("10.0.0.1")).To(Succeed())
+			Expect(zonesSink.updates).To(HaveLen(1))
+			Expect(otherSink.updates).To(Equal(zonesSink.updates))
+		})
+	})
+
+	Context("Reverse zones", func() {
+		var zoneFiles map[string]*ZoneFileStub
+
+		newRecordingZoneFile := func(fileName string) zone_file.ZoneFileInterface {
+			zoneFiles[fileName] = &ZoneFileStub{}
+			return zoneFiles[fileName]
+		}
+
+		BeforeEach(func() {
+			zoneFiles = map[string]*ZoneFileStub{}
+			os.Setenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH", "24")
+		})
+		AfterEach(func() {
+			os.Unsetenv("IPV4_REVERSE_ZONE_PREFIX_LENGTH")
+		})
+
+		It("should write only the reverse zones whose content was changed", func() {
+			const (
+				forwardZoneFileName = "/zones/db.vm." + customDomain
+				reverseZoneFileName = "/zones/db.0.0.10.in-addr.arpa"
+				otherZoneFileName   = "/zones/db.1.0.10.in-addr.arpa"
+			)
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newRecordingZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi1 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			vmi2 := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm2"}
+			Expect(zoneMgr.UpdateZone(vmi1, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneMgr.UpdateZone(vmi2, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.1.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFiles).To(HaveKey(reverseZoneFileName))
+			Expect(zoneFiles[reverseZoneFileName].content).To(ContainSubstring("$ORIGIN 0.0.10.in-addr.arpa. \n"))
+			Expect(zoneFiles[reverseZoneFileName].content).To(HaveSuffix("5 IN PTR nic1.vm1.ns1.vm." + customDomain + ".\n"))
+			Expect(zoneFiles[reverseZoneFileName].writes).To(Equal(1))
+			Expect(zoneFiles[otherZoneFileName].writes).To(Equal(1))
+
+			Expect(zoneMgr.UpdateZone(vmi2, nil)).To(Succeed())
+			Expect(zoneFiles[forwardZoneFileName].writes).To(Equal(3))
+			Expect(zoneFiles[reverseZoneFileName].writes).To(Equal(1))
+			Expect(zoneFiles[otherZoneFileName].writes).To(Equal(2))
+			Expect(zoneFiles[otherZoneFileName].content).ToNot(ContainSubstring("PTR"))
+		})
+
+		It("should empty a reverse zone once its last record is removed", func() {
+			const reverseZoneFileName = "/zones/db.0.0.10.in-addr.arpa"
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newRecordingZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFiles[reverseZoneFileName].content).To(ContainSubstring("PTR"))
+
+			Expect(zoneMgr.UpdateZone(vmi, nil)).To(Succeed())
+			Expect(zoneFiles[reverseZoneFileName].writes).To(Equal(2))
+			Expect(zoneFiles[reverseZoneFileName].content).To(HaveSuffix("@ IN NS ns.vm." + customDomain + ".\n"))
+		})
+
+		It("should rewrite the reverse zones written before a restart", func() {
+			const (
+				staleZone = "$ORIGIN 0.0.10.in-addr.arpa. \n$TTL 3600 \n" +
+					"@ IN SOA ns.vm.domain.com. email.vm.domain.com. (7 3600 3600 1209600 3600)\n@ IN NS ns.vm.domain.com.\n"
+				stalePTRRecord = "5 IN PTR nic1.vm1.ns1.vm." + customDomain + ".\n"
+			)
+			zonesDir, err := os.MkdirTemp("", "zones")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(zonesDir)
+			defer zonemgr.SetZonesDir(zonesDir)()
+			reverseZoneFileName := zone_file.ZoneFileName(zonesDir, "0.0.10.in-addr.arpa")
+			otherZoneFileName := zone_file.ZoneFileName(zonesDir, "1.0.10.in-addr.arpa")
+			Expect(zone_file.NewZoneFile(reverseZoneFileName).WriteFile(staleZone + stalePTRRecord)).To(Succeed())
+			Expect(zone_file.NewZoneFile(otherZoneFileName).WriteFile(strings.ReplaceAll(staleZone, "0.0.10", "1.0.10"))).To(Succeed())
+
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, zone_file.NewZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm2"}
+			Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.6"}, Name: "nic1"}}))).To(Succeed())
+
+			By("keeping the zone files until the VMIs are synced")
+			content, err := os.ReadFile(reverseZoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(staleZone + stalePTRRecord))
+			content, err = os.ReadFile(otherZoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(strings.ReplaceAll(staleZone, "0.0.10", "1.0.10")))
+
+			By("rewriting the zone files once the VMIs are synced")
+			Expect(zoneMgr.MarkSynced()).To(Succeed())
+			content, err = os.ReadFile(reverseZoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("(8 3600 3600 1209600 3600)"))
+			Expect(string(content)).To(HaveSuffix("6 IN PTR nic1.vm2.ns1.vm." + customDomain + ".\n"))
+			Expect(string(content)).ToNot(ContainSubstring(stalePTRRecord))
+
+			content, err = os.ReadFile(otherZoneFileName)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(ContainSubstring("(8 3600 3600 1209600 3600)"))
+			Expect(string(content)).ToNot(ContainSubstring("PTR"))
+		})
+
+		It("should write IPv6 reverse zones split by the configured prefix length", func() {
+			const reverseZoneFileName = "/zones/db.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"
+			os.Setenv("IPV6_REVERSE_ZONE_PREFIX_LENGTH", "/64")
+			defer os.Unsetenv("IPV6_REVERSE_ZONE_PREFIX_LENGTH")
+			zoneMgr, err := zonemgr.NewZoneManagerWithParams(zone_file_cache.NewZoneFileCache, newRecordingZoneFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			vmi := k8stypes.NamespacedName{Namespace: "ns1", Name: "vm1"}
+			Expect(zoneMgr.UpdateZone(vmi, newVMI([]v1.VirtualMachineInstanceNetworkInterface{{IPs: []string{"10.0.0.5", "2001:db8::1"}, Name: "nic1"}}))).To(Succeed())
+			Expect(zoneFiles).To(HaveKey("/zones/db.0.0.10.in-addr.arpa"))
+			Expect(zoneFiles).To(HaveKey(reverseZoneFileName))
+			Expect(zoneFiles[reverseZoneFileName].content).To(HaveSuffix("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0 IN PTR nic1.vm1.ns1.vm." + customDomain + ".\n"))
+		})
 	})
 })
 
-func newZoneFileCacheStub(nameServerIP string, domain string, soaSerial *int) *zone_file_cache.ZoneFileCache {
+func newZoneFileCacheStub(nameServerIP string, domain string, soaSerial *int, serialScheme zone_file_cache.SerialScheme,
+	soa *zone_file_cache.SOA, naming *zone_file_cache.Naming, metadata *zone_file_cache.Metadata) *zone_file_cache.ZoneFileCache {
 	expectedNameServerIP := customNSIP
 	expectedDomain := "vm." + customDomain
 	Expect(nameServerIP).To(Equal(expectedNameServerIP))
@@ -64,13 +939,50 @@ func newZoneFileStub(fileName string) zone_file.ZoneFileInterface {
 	return &ZoneFileStub{}
 }
 
+func newVMI(interfaces []v1.VirtualMachineInstanceNetworkInterface) *v1.VirtualMachineInstance {
+	return &v1.VirtualMachineInstance{Status: v1.VirtualMachineInstanceStatus{Interfaces: interfaces}}
+}
+
 type ZoneFileStub struct {
+	content      string
+	writes       int
+	writeErr     error
+	soaSerialErr error
 }
 
 func (zoneFileStub *ZoneFileStub) WriteFile(content string) (err error) {
+	if zoneFileStub.writeErr != nil {
+		return zoneFileStub.writeErr
+	}
+	zoneFileStub.content = content
+	zoneFileStub.writes++
 	return nil
 }
 
 func (zoneFileStub *ZoneFileStub) ReadSoaSerial() (*int, error) {
-	return nil, nil
+	return nil, zoneFileStub.soaSerialErr
+}
+
+type SinkStub struct {
+	updates   []sink.ZoneUpdate
+	updateErr error
+	// blocked, when set, is signaled when an update starts, and the update waits until released is closed
+	blocked  chan struct{}
+	released chan struct{}
+}
+
+func (sinkStub *SinkStub) Name() string {
+	return "stub"
+}
+
+func (sinkStub *SinkStub) Update(update sink.ZoneUpdate) error {
+	if sinkStub.blocked != nil {
+		sinkStub.blocked <- struct{}{}
+		<-sinkStub.released
+	}
+	if sinkStub.updateErr != nil {
+		return sinkStub.updateErr
+	}
+	sinkStub.updates = append(sinkStub.updates, update)
+	return nil
 }